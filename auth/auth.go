@@ -1,64 +1,29 @@
-
 package auth
 
 import (
-	
+	"encoding/json"
+	"log"
 	"net/http"
-	
-	"strings"
-	"time"
-	"real/db"
 )
 
-// GetUserID returns the user ID as string from request
-func GetUserID(r *http.Request) (string, bool) {
-	// Try cookie first
-	cookie, err := r.Cookie("session_id")
-	if err == nil && cookie != nil {
-		userID, err := validateSession(cookie.Value)
-		if err == nil && userID != "" {
-			return userID, true
-		}
-	}
-
-	// Try Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if strings.HasPrefix(authHeader, "Bearer ") {
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-		userID, err := validateSession(token)
-		if err == nil && userID != "" {
-			return userID, true
+// AuthMiddleware only lets requests with a valid session through to next.
+// Others get a 401 with a JSON error.
+func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := CurrentSession(r); !ok {
+			writeError(w, http.StatusUnauthorized, "Unauthorized")
+			return
 		}
+		next(w, r)
 	}
-
-	return "", false
 }
 
-// GetCurrentUserID returns the user ID as int from context
-func GetCurrentUserID(r *http.Request) int {
-	if userID, ok := r.Context().Value("userID").(int); ok {
-		return userID
-	}
-	return 0
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
 }
 
-// AuthMiddleware verifies authentication
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		
-	}
+func logSessionError(err error) {
+	log.Printf("Error looking up session: %v", err)
 }
-
-func validateSession(sessionID string) (string, error) {
-	var userID string
-	err := db.DB.QueryRow(
-		"SELECT user_id FROM sessions WHERE session_id = ? AND expires_at > ?",
-		sessionID,
-		time.Now(),
-	).Scan(&userID)
-	
-	if err != nil {
-		return "", err
-	}
-	return userID, nil
-}
\ No newline at end of file
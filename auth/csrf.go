@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"real/db"
+)
+
+// State-changing requests authenticated by the session cookie must repeat the
+// session's CSRF token in CSRFHeader. Pages read the token from CSRFCookie,
+// which unlike the session cookie is readable by scripts; other sites can
+// neither read it nor set the header.
+const (
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate CSRF token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// assignCSRFToken gives s a CSRF token, for sessions created before they
+// existed.
+func assignCSRFToken(s *Session) error {
+	token, err := newCSRFToken()
+	if err != nil {
+		return err
+	}
+	if _, err := db.DB.Exec(`UPDATE sessions SET csrf_token = ? WHERE session_id = ?`, token, s.ID); err != nil {
+		return fmt.Errorf("assign CSRF token: %w", err)
+	}
+	s.CSRFToken = token
+	return nil
+}
+
+func setCSRFCookie(w http.ResponseWriter, s Session) {
+	cookie := &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    s.CSRFToken,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+	}
+	if s.Remember {
+		cookie.Expires = s.ExpiresAt
+	}
+	http.SetCookie(w, cookie)
+}
+
+// validCSRFToken reports whether r carries the CSRF token of s.
+func validCSRFToken(r *http.Request, s Session) bool {
+	sent := []byte(r.Header.Get(CSRFHeader))
+	if len(sent) == 0 {
+		return false
+	}
+	for _, token := range []string{s.CSRFToken, s.previousCSRFToken} {
+		if token != "" && subtle.ConstantTimeCompare(sent, []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// CSRFMiddleware turns away state-changing requests sent from origins that
+// are not allowed, and those authenticated by the session cookie without the
+// session's CSRF token. Bearer tokens are never sent by browsers on their
+// own, so requests using them need no CSRF token. It must run inside
+// SessionMiddleware.
+func CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if safeMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !OriginAllowed(r) {
+			writeError(w, http.StatusForbidden, "Origin not allowed")
+			return
+		}
+		if s, ok := CurrentSession(r); ok && s.fromCookie && !validCSRFToken(r, s) {
+			writeError(w, http.StatusForbidden, "Invalid CSRF token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
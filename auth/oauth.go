@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"real/db"
+	"real/oauth"
+)
+
+var (
+	ErrOAuthState = errors.New("login expired, please try again")
+	// ErrEmailInUse is returned when the provider's email address belongs to
+	// an account that has to link the provider itself, after logging in
+	ErrEmailInUse = errors.New("an account already uses this email address")
+	// ErrAccountLinked is returned when the account is already linked to
+	// another provider account
+	ErrAccountLinked = errors.New("account is already linked to a provider")
+	// ErrIdentityLinked is returned when the provider account is already
+	// linked to another user
+	ErrIdentityLinked = errors.New("provider account is linked to another user")
+	// ErrEmailUnverified is returned when no account uses the provider's
+	// email address and the provider has not verified it
+	ErrEmailUnverified = errors.New("the provider has not verified this email address")
+	ErrNotLinked       = errors.New("account is not linked to a provider")
+)
+
+// oauthStateTimeout is how long a user has to log in at the provider.
+const oauthStateTimeout = 10 * time.Minute
+
+// AuthTypeEmail is the auth_type of users who only log in with a password.
+const AuthTypeEmail = "email"
+
+// OAuthState is a login in progress at a provider.
+type OAuthState struct {
+	Provider     string
+	CodeVerifier string
+	// LinkUserID is the user linking the provider to their account, 0 when
+	// logging in
+	LinkUserID int
+	Remember   bool
+}
+
+// CreateOAuthState records a login starting at provider and returns the
+// state to send to it, with the PKCE code verifier for the login. linkUserID
+// is the logged-in user linking the provider, or 0.
+func CreateOAuthState(provider string, linkUserID int, remember bool) (state, codeVerifier string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("generate OAuth state: %w", err)
+	}
+	state = base64.RawURLEncoding.EncodeToString(b)
+	if codeVerifier, err = oauth.NewCodeVerifier(); err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	if _, err := db.DB.Exec(`DELETE FROM oauth_states WHERE expires_at < ?`, now); err != nil {
+		return "", "", fmt.Errorf("delete expired OAuth states: %w", err)
+	}
+	var link sql.NullInt64
+	if linkUserID != 0 {
+		link = sql.NullInt64{Int64: int64(linkUserID), Valid: true}
+	}
+	_, err = db.DB.Exec(`
+		INSERT INTO oauth_states (state_hash, provider, code_verifier, link_user_id, remember, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		hashToken(state), provider, codeVerifier, link, remember, now.Add(oauthStateTimeout),
+	)
+	if err != nil {
+		return "", "", fmt.Errorf("create OAuth state: %w", err)
+	}
+	return state, codeVerifier, nil
+}
+
+// ConsumeOAuthState uses up state, returning the login it was created for,
+// or ErrOAuthState when it is unknown, used or expired.
+func ConsumeOAuthState(state string) (OAuthState, error) {
+	var (
+		s         OAuthState
+		link      sql.NullInt64
+		expiresAt time.Time
+	)
+	hash := hashToken(state)
+	err := db.DB.QueryRow(`
+		SELECT provider, code_verifier, link_user_id, remember, expires_at
+		FROM oauth_states WHERE state_hash = ?`, hash,
+	).Scan(&s.Provider, &s.CodeVerifier, &link, &s.Remember, &expiresAt)
+	if err == sql.ErrNoRows {
+		return s, ErrOAuthState
+	} else if err != nil {
+		return s, fmt.Errorf("load OAuth state: %w", err)
+	}
+
+	// Only the request that deletes the state gets to use it
+	result, err := db.DB.Exec(`DELETE FROM oauth_states WHERE state_hash = ?`, hash)
+	if err != nil {
+		return s, fmt.Errorf("delete OAuth state: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 || !expiresAt.After(time.Now()) {
+		return s, ErrOAuthState
+	}
+	s.LinkUserID = int(link.Int64)
+	return s, nil
+}
+
+// OAuthLogin returns the user id logs in as. The user linked to id is used
+// when there is one. Otherwise an account with the same email address is
+// linked to id, provided both the provider and this site have verified the
+// address, since an unverified account may have been registered by someone
+// else to take over the address's owner when they first log in. Failing
+// both, a new account is created with no password, provided the provider has
+// verified the address: otherwise anyone could claim an address at a
+// provider that does not check it, and keep the account from its owner.
+func OAuthLogin(id oauth.Identity) (userID int, err error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		`SELECT user_id FROM users WHERE auth_type = ? AND provider_id = ?`, id.Provider, id.Subject,
+	).Scan(&userID)
+	if err == nil {
+		return userID, nil
+	} else if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("look up provider account: %w", err)
+	}
+
+	var (
+		providerID sql.NullString
+		verified   bool
+	)
+	err = tx.QueryRow(
+		`SELECT user_id, provider_id, email_verified_at IS NOT NULL FROM users WHERE email = ? COLLATE NOCASE`, id.Email,
+	).Scan(&userID, &providerID, &verified)
+	switch {
+	case err == sql.ErrNoRows && !id.EmailVerified:
+		return 0, ErrEmailUnverified
+	case err == sql.ErrNoRows:
+		userID, err = createOAuthUser(tx, id)
+		if err != nil {
+			return 0, err
+		}
+	case err != nil:
+		return 0, fmt.Errorf("look up user by email: %w", err)
+	case providerID.Valid:
+		return 0, ErrAccountLinked
+	case !id.EmailVerified || !verified:
+		return 0, ErrEmailInUse
+	default:
+		if err := setProvider(tx, userID, id.Provider, id.Subject); err != nil {
+			return 0, err
+		}
+	}
+	return userID, tx.Commit()
+}
+
+// LinkOAuth links id to userID, so that they can log in with the provider.
+// A user can only be linked to one provider account at a time.
+func LinkOAuth(userID int, id oauth.Identity) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var owner int
+	err = tx.QueryRow(
+		`SELECT user_id FROM users WHERE auth_type = ? AND provider_id = ?`, id.Provider, id.Subject,
+	).Scan(&owner)
+	switch {
+	case err == nil && owner == userID:
+		return nil
+	case err == nil:
+		return ErrIdentityLinked
+	case err != sql.ErrNoRows:
+		return fmt.Errorf("look up provider account: %w", err)
+	}
+
+	var linked bool
+	if err := tx.QueryRow(`SELECT provider_id IS NOT NULL FROM users WHERE user_id = ?`, userID).Scan(&linked); err != nil {
+		return fmt.Errorf("load user: %w", err)
+	}
+	if linked {
+		return ErrAccountLinked
+	}
+	if err := setProvider(tx, userID, id.Provider, id.Subject); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UnlinkOAuth unlinks userID from their provider account, leaving them to
+// log in with their password.
+func UnlinkOAuth(userID int) error {
+	result, err := db.DB.Exec(`
+		UPDATE users SET auth_type = ?, provider_id = NULL, updated_at = ?
+		WHERE user_id = ? AND provider_id IS NOT NULL`, AuthTypeEmail, time.Now(), userID)
+	if err != nil {
+		return fmt.Errorf("unlink provider: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotLinked
+	}
+	return nil
+}
+
+// OAuthAccount returns the provider userID is linked to, empty when none,
+// and whether they have a password to log in with.
+func OAuthAccount(userID int) (provider string, hasPassword bool, err error) {
+	var providerID sql.NullString
+	err = db.DB.QueryRow(
+		`SELECT auth_type, provider_id, password != '' FROM users WHERE user_id = ?`, userID,
+	).Scan(&provider, &providerID, &hasPassword)
+	if err != nil {
+		return "", false, fmt.Errorf("load user: %w", err)
+	}
+	if !providerID.Valid {
+		provider = ""
+	}
+	return provider, hasPassword, nil
+}
+
+func setProvider(tx *sql.Tx, userID int, provider, subject string) error {
+	_, err := tx.Exec(
+		`UPDATE users SET auth_type = ?, provider_id = ?, updated_at = ? WHERE user_id = ?`,
+		provider, subject, time.Now(), userID,
+	)
+	if err != nil {
+		return fmt.Errorf("link provider: %w", err)
+	}
+	return nil
+}
+
+// createOAuthUser creates an account for id, whose email address the
+// provider has verified. It has no password, which no password matches,
+// until the user sets one through a password reset.
+func createOAuthUser(tx *sql.Tx, id oauth.Identity) (int, error) {
+	username, err := availableUsername(tx, id)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	result, err := tx.Exec(`
+		INSERT INTO users (username, email, password, auth_type, provider_id, email_verified_at,
+			first_name, last_name, created_at)
+		VALUES (?, ?, '', ?, ?, ?, ?, ?, ?)`,
+		username, id.Email, id.Provider, id.Subject, now, id.FirstName, id.LastName, now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("create user: %w", err)
+	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("create user: %w", err)
+	}
+	return int(userID), nil
+}
+
+// usernameInvalidChars are left out of usernames taken from providers.
+var usernameInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// availableUsername picks a username for a new account of id: the username
+// at the provider or the start of the email address, with a number added
+// when it is taken.
+func availableUsername(tx *sql.Tx, id oauth.Identity) (string, error) {
+	base := id.Username
+	if base == "" {
+		base, _, _ = strings.Cut(id.Email, "@")
+	}
+	base = usernameInvalidChars.ReplaceAllString(base, "")
+	if len(base) > 30 {
+		base = base[:30]
+	}
+	for len(base) < 3 {
+		base += "_"
+	}
+
+	username := base
+	for n := 2; ; n++ {
+		var taken bool
+		if err := tx.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM users WHERE username = ? COLLATE NOCASE)`, username,
+		).Scan(&taken); err != nil {
+			return "", fmt.Errorf("check username: %w", err)
+		}
+		if !taken {
+			return username, nil
+		}
+		username = base + strconv.Itoa(n)
+	}
+}
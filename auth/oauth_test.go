@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"real/db"
+	"real/oauth"
+)
+
+// TestMain opens a fresh database for the package. db.Init reads
+// db/schema.sql relative to the working directory, so the tests run from the
+// repository root.
+func TestMain(m *testing.M) {
+	os.Exit(runWithDatabase(m))
+}
+
+func runWithDatabase(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "auth-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Chdir(".."); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := db.Init(filepath.Join(dir, "forum.db")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.DB.Close()
+	return m.Run()
+}
+
+// testUser describes an account created by createUser.
+type testUser struct {
+	username, email   string
+	verified          bool
+	provider, subject string
+}
+
+func createUser(t *testing.T, u testUser) int {
+	t.Helper()
+	authType, providerID := AuthTypeEmail, sql.NullString{}
+	if u.provider != "" {
+		authType, providerID = u.provider, sql.NullString{String: u.subject, Valid: true}
+	}
+	verifiedAt := "NULL"
+	if u.verified {
+		verifiedAt = "CURRENT_TIMESTAMP"
+	}
+	result, err := db.DB.Exec(`
+		INSERT INTO users (username, email, password, auth_type, provider_id, email_verified_at)
+		VALUES (?, ?, 'hash', ?, ?, `+verifiedAt+`)`,
+		u.username, u.email, authType, providerID,
+	)
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+// linkedProvider returns the provider and subject userID is linked to.
+func linkedProvider(t *testing.T, userID int) (authType string, subject sql.NullString) {
+	t.Helper()
+	err := db.DB.QueryRow(`SELECT auth_type, provider_id FROM users WHERE user_id = ?`, userID).Scan(&authType, &subject)
+	if err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+	return authType, subject
+}
+
+func TestOAuthLogin(t *testing.T) {
+	linked := createUser(t, testUser{username: "linked", email: "linked@example.com", provider: "oidc", subject: "sub-linked"})
+	verified := createUser(t, testUser{username: "verified", email: "Verified@Example.com", verified: true})
+	unverified := createUser(t, testUser{username: "unverified", email: "unverified@example.com"})
+	createUser(t, testUser{username: "carol", email: "carol@example.com", verified: true})
+	otherProvider := createUser(t, testUser{username: "other", email: "other@example.com", verified: true,
+		provider: "github", subject: "42"})
+	createUser(t, testUser{username: "ada", email: "ada.old@example.com"})
+
+	tests := []struct {
+		name     string
+		id       oauth.Identity
+		wantUser int // -1 for a new account
+		wantErr  error
+	}{
+		{
+			name:     "linked identity",
+			id:       oauth.Identity{Provider: "oidc", Subject: "sub-linked", Email: "changed@example.com"},
+			wantUser: linked,
+		},
+		{
+			name:     "verified on both sides",
+			id:       oauth.Identity{Provider: "oidc", Subject: "sub-verified", Email: "verified@example.com", EmailVerified: true},
+			wantUser: verified,
+		},
+		{
+			name:    "unverified here",
+			id:      oauth.Identity{Provider: "oidc", Subject: "sub-1", Email: "unverified@example.com", EmailVerified: true},
+			wantErr: ErrEmailInUse,
+		},
+		{
+			name:    "unverified at the provider",
+			id:      oauth.Identity{Provider: "oidc", Subject: "sub-2", Email: "carol@example.com"},
+			wantErr: ErrEmailInUse,
+		},
+		{
+			name:    "account linked elsewhere",
+			id:      oauth.Identity{Provider: "oidc", Subject: "sub-3", Email: "other@example.com", EmailVerified: true},
+			wantErr: ErrAccountLinked,
+		},
+		{
+			name:     "new verified address",
+			id:       oauth.Identity{Provider: "oidc", Subject: "sub-new", Email: "ada@example.com", EmailVerified: true, Username: "ada"},
+			wantUser: -1,
+		},
+		{
+			name:    "new unverified address",
+			id:      oauth.Identity{Provider: "oidc", Subject: "sub-squatter", Email: "victim@example.com", Username: "victim"},
+			wantErr: ErrEmailUnverified,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, err := OAuthLogin(tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OAuthLogin = %d, %v; want error %v", userID, err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			switch tt.wantUser {
+			case -1:
+				for _, existing := range []int{linked, verified, unverified, otherProvider} {
+					if userID == existing {
+						t.Fatalf("OAuthLogin = %d, want a new account", userID)
+					}
+				}
+			default:
+				if userID != tt.wantUser {
+					t.Fatalf("OAuthLogin = %d, want %d", userID, tt.wantUser)
+				}
+			}
+			authType, subject := linkedProvider(t, userID)
+			if authType != tt.id.Provider || subject.String != tt.id.Subject {
+				t.Errorf("user is linked to %s/%s, want %s/%s", authType, subject.String, tt.id.Provider, tt.id.Subject)
+			}
+		})
+	}
+
+	// The new account took a free username, verified its address and has no
+	// password
+	var (
+		username    string
+		hasPassword bool
+		isVerified  bool
+	)
+	err := db.DB.QueryRow(
+		`SELECT username, password != '', email_verified_at IS NOT NULL FROM users WHERE email = 'ada@example.com'`,
+	).Scan(&username, &hasPassword, &isVerified)
+	if err != nil {
+		t.Fatalf("load new account: %v", err)
+	}
+	if username != "ada2" || hasPassword || !isVerified {
+		t.Errorf("new account: username %q, password %t, verified %t; want ada2, false, true", username, hasPassword, isVerified)
+	}
+
+	var squatted bool
+	db.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE email = 'victim@example.com')`).Scan(&squatted)
+	if squatted {
+		t.Error("an account was created for the unverified address")
+	}
+}
+
+func TestLinkOAuth(t *testing.T) {
+	alice := createUser(t, testUser{username: "alice", email: "alice@example.com", verified: true})
+	bob := createUser(t, testUser{username: "bob", email: "bob@example.com", verified: true})
+	aliceID := oauth.Identity{Provider: "github", Subject: "alice-gh", Email: "alice@example.com", EmailVerified: true}
+
+	tests := []struct {
+		name    string
+		userID  int
+		id      oauth.Identity
+		wantErr error
+	}{
+		{"link", alice, aliceID, nil},
+		{"link again", alice, aliceID, nil},
+		{"identity linked to another user", bob, aliceID, ErrIdentityLinked},
+		{"account already linked", alice, oauth.Identity{Provider: "oidc", Subject: "alice-sso"}, ErrAccountLinked},
+		{"link other user", bob, oauth.Identity{Provider: "oidc", Subject: "bob-sso"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := LinkOAuth(tt.userID, tt.id); !errors.Is(err, tt.wantErr) {
+				t.Errorf("LinkOAuth = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if authType, subject := linkedProvider(t, alice); authType != "github" || subject.String != "alice-gh" {
+		t.Errorf("alice is linked to %s/%s, want github/alice-gh", authType, subject.String)
+	}
+	if err := UnlinkOAuth(alice); err != nil {
+		t.Fatalf("UnlinkOAuth: %v", err)
+	}
+	if authType, subject := linkedProvider(t, alice); authType != AuthTypeEmail || subject.Valid {
+		t.Errorf("after unlinking alice is linked to %s/%s", authType, subject.String)
+	}
+	if err := UnlinkOAuth(alice); !errors.Is(err, ErrNotLinked) {
+		t.Errorf("second UnlinkOAuth = %v, want ErrNotLinked", err)
+	}
+}
+
+func TestOAuthState(t *testing.T) {
+	state, verifier, err := CreateOAuthState("oidc", 7, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConsumeOAuthState("made-up"); !errors.Is(err, ErrOAuthState) {
+		t.Errorf("ConsumeOAuthState of an unknown state = %v, want ErrOAuthState", err)
+	}
+	got, err := ConsumeOAuthState(state)
+	if err != nil {
+		t.Fatalf("ConsumeOAuthState: %v", err)
+	}
+	want := OAuthState{Provider: "oidc", CodeVerifier: verifier, LinkUserID: 7, Remember: true}
+	if got != want {
+		t.Errorf("ConsumeOAuthState = %+v, want %+v", got, want)
+	}
+	if _, err := ConsumeOAuthState(state); !errors.Is(err, ErrOAuthState) {
+		t.Errorf("second ConsumeOAuthState = %v, want ErrOAuthState", err)
+	}
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"real/config"
+)
+
+// allowedOrigins are the other origins, such as a separately served
+// frontend, that may call the API with credentials. The server's own origin
+// is always allowed.
+var allowedOrigins = parseOrigins(config.String("ALLOWED_ORIGINS", ""))
+
+func parseOrigins(list string) map[string]bool {
+	origins := make(map[string]bool)
+	for _, origin := range strings.Split(list, ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			origins[origin] = true
+		}
+	}
+	return origins
+}
+
+// sameOrigin reports whether origin is the host r was sent to.
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// OriginAllowed reports whether r comes from the server's own pages or an
+// allowed origin. Requests without an Origin header come from non-browser
+// clients and are allowed, since browsers send one with every cross-origin
+// and websocket request.
+func OriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	return sameOrigin(r, origin) || allowedOrigins[origin]
+}
+
+// CORSMiddleware lets the allowed origins call the API with credentials,
+// answering their preflight requests.
+func CORSMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || sameOrigin(r, origin) || !allowedOrigins[origin] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Session-Token")
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+CSRFHeader)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
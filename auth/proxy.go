@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"real/config"
+)
+
+// trustedProxies are the reverse proxies in front of the server, as
+// addresses or CIDR ranges. Requests from them are attributed to the address
+// they report in X-Forwarded-For; anyone else could forge that header, so it
+// is ignored for them.
+var trustedProxies = parseProxies(config.String("TRUSTED_PROXIES", ""))
+
+func parseProxies(list string) []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		} else if _, network, err := net.ParseCIDR(entry); err == nil {
+			proxies = append(proxies, network)
+			continue
+		}
+		log.Printf("config: invalid address in TRUSTED_PROXIES: %q", entry)
+	}
+	return proxies
+}
+
+func trustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address the request came from, without the port. Behind
+// trusted proxies it is the last address in X-Forwarded-For that is not one
+// of them: proxies append the address they received the request from, so
+// anything before that was sent by the client and may be made up.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !trustedProxy(ip) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// Whoever added this is not to be believed, nor is anything before it
+			break
+		}
+		ip = hop
+		if !trustedProxy(hop) {
+			break
+		}
+	}
+	return ip.String()
+}
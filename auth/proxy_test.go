@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	saved := trustedProxies
+	defer func() { trustedProxies = saved }()
+	trustedProxies = parseProxies("10.0.0.1, 172.16.0.0/12, ::1, not-an-address")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"direct ignores header", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted IPv6 proxy", "[::1]:5000", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"trusted proxy without header", "10.0.0.1:5000", nil, "10.0.0.1"},
+		{"forged first hop", "10.0.0.1:5000", []string{"192.0.2.99, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of proxies", "10.0.0.1:5000", []string{"198.51.100.1, 172.16.4.2"}, "198.51.100.1"},
+		{"repeated headers", "10.0.0.1:5000", []string{"192.0.2.99", "198.51.100.1"}, "198.51.100.1"},
+		{"only proxies", "10.0.0.1:5000", []string{"172.16.4.2"}, "172.16.4.2"},
+		{"garbage hop", "10.0.0.1:5000", []string{"198.51.100.1, garbage"}, "10.0.0.1"},
+		{"no port", "203.0.113.7", nil, "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,81 +3,204 @@ package auth
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"real/db"
 )
 
-type contextKey string
+// SessionCookie is the cookie holding the session ID.
+const SessionCookie = "session_id"
 
-const userIDKey contextKey = "userID"
+var ErrNoSession = errors.New("no valid session")
 
-func SessionMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("session_id")
-		if err != nil || cookie.Value == "" {
-			// No session cookie, proceed without user context
-			next.ServeHTTP(w, r)
-			return
-		}
+// Session is a logged-in user's session. ExpiresAt moves forward while the
+// session is in use, up to AbsoluteExpiresAt.
+type Session struct {
+	ID                string
+	UserID            int
+	ExpiresAt         time.Time
+	AbsoluteExpiresAt time.Time
+	LastUsedAt        time.Time
+	Remember          bool
+	// Role is the role the session was issued for; the session ID is
+	// rotated when the user's role no longer matches it
+	Role string
+	// CSRFToken must accompany state-changing requests made with the
+	// session cookie
+	CSRFToken string
 
-		// Validate the session
-		var userID string
-		var expiresAt time.Time
-		query := `SELECT user_id, expires_at FROM sessions WHERE session_id = ?`
-		err = db.DB.QueryRow(query, cookie.Value).Scan(&userID, &expiresAt)
-		if err == sql.ErrNoRows || time.Now().After(expiresAt) {
-			// Invalid or expired session, clear the cookie
-			http.SetCookie(w, &http.Cookie{
-				Name:     "session_id",
-				Value:    "",
-				Expires:  time.Now().Add(-time.Hour),
-				Path:     "/",
-				HttpOnly: true,
-			})
-			next.ServeHTTP(w, r)
-			return
-		}
+	// fromCookie is set when the request carried the session in its cookie
+	// rather than a bearer token
+	fromCookie bool
+	// previousCSRFToken is still accepted for the request that rotated the
+	// session
+	previousCSRFToken string
+}
 
-		// Add userID to the request context
-		ctx := context.WithValue(r.Context(), userIDKey, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+type contextKey int
+
+// sessionKey holds the *Session resolved for a request, nil when there is
+// none.
+const sessionKey contextKey = iota
+
+// LookupSession loads an unexpired session by ID, along with the current role
+// of its user.
+func LookupSession(sessionID string) (s Session, userRole string, err error) {
+	s.ID = sessionID
+	if sessionID == "" {
+		return s, "", ErrNoSession
+	}
+	var absolute, lastUsed sql.NullTime
+	err = db.DB.QueryRow(`
+		SELECT s.user_id, s.expires_at, s.absolute_expires_at, s.last_used_at, s.remember, s.role, s.csrf_token, u.role
+		FROM sessions s
+		JOIN users u ON u.user_id = s.user_id
+		WHERE s.session_id = ?`, sessionID,
+	).Scan(&s.UserID, &s.ExpiresAt, &absolute, &lastUsed, &s.Remember, &s.Role, &s.CSRFToken, &userRole)
+	if err == sql.ErrNoRows {
+		return s, "", ErrNoSession
+	} else if err != nil {
+		return s, "", err
+	}
+	s.AbsoluteExpiresAt = absolute.Time
+	if !absolute.Valid {
+		s.AbsoluteExpiresAt = s.ExpiresAt
+	}
+	s.LastUsedAt = lastUsed.Time
+
+	now := time.Now()
+	if !s.ExpiresAt.After(now) || !s.AbsoluteExpiresAt.After(now) {
+		return s, "", ErrNoSession
+	}
+	return s, userRole, nil
 }
 
-func RequireAuth(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userID := r.Context().Value(userIDKey)
-		if userID == nil {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
-			return
+// sessionToken returns the session ID sent with r: the session cookie, or a
+// bearer token in the Authorization header. fromCookie tells which.
+func sessionToken(r *http.Request) (token string, fromCookie bool) {
+	if cookie, err := r.Cookie(SessionCookie); err == nil && cookie.Value != "" {
+		return cookie.Value, true
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token), false
+	}
+	return "", false
+}
+
+// CurrentSession returns the session of r. It is resolved once per request by
+// SessionMiddleware; requests that did not pass through it are looked up
+// directly.
+func CurrentSession(r *http.Request) (Session, bool) {
+	if s, resolved := r.Context().Value(sessionKey).(*Session); resolved {
+		if s == nil {
+			return Session{}, false
 		}
-		next.ServeHTTP(w, r)
-	})
+		return *s, true
+	}
+	token, fromCookie := sessionToken(r)
+	s, _, err := LookupSession(token)
+	s.fromCookie = fromCookie
+	return s, err == nil
 }
 
-func RedirectIfAuthenticated(next http.Handler) http.Handler {
+// GetCurrentUserID returns the ID of the logged-in user, or 0 when the request
+// has no valid session.
+func GetCurrentUserID(r *http.Request) int {
+	s, ok := CurrentSession(r)
+	if !ok {
+		return 0
+	}
+	return s.UserID
+}
+
+// SessionMiddleware resolves the session of every request and stores it in
+// the request context for CurrentSession. Sessions in use are renewed, and
+// get a new ID once the user's role has changed since they were issued;
+// onRotate is then told the old and new IDs. A session cookie that is no
+// longer valid is cleared.
+func SessionMiddleware(next http.Handler, onRotate func(oldID, newID string)) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userID := r.Context().Value(userIDKey)
-		if userID != nil {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return
+		var session *Session
+		if token, fromCookie := sessionToken(r); token != "" {
+			s, userRole, err := LookupSession(token)
+			switch {
+			case err == nil:
+				s.fromCookie = fromCookie
+				session = &s
+				if err := refreshSession(w, r, session, userRole, onRotate); err != nil {
+					logSessionError(err)
+				}
+			case errors.Is(err, ErrNoSession):
+				if fromCookie {
+					ClearSessionCookie(w)
+				}
+			default:
+				// Treated as logged out for this request; the cookie is kept
+				// since the session may well be valid
+				logSessionError(err)
+			}
 		}
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), sessionKey, session)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func IsAuthenticated(r *http.Request) bool {
-	_, ok := GetUserID(r)
-	return ok
-}
+// refreshSession rotates s when userRole differs from the role it was issued
+// for, and otherwise renews it, sending the client its new cookies either
+// way. Sessions from before CSRF tokens are given one.
+func refreshSession(w http.ResponseWriter, r *http.Request, s *Session, userRole string, onRotate func(oldID, newID string)) error {
+	if s.CSRFToken == "" {
+		if err := assignCSRFToken(s); err != nil {
+			return err
+		}
+	}
+	if s.fromCookie {
+		// Restore the CSRF cookie if the client lost it
+		if cookie, err := r.Cookie(CSRFCookie); err != nil || cookie.Value != s.CSRFToken {
+			setCSRFCookie(w, *s)
+		}
+	}
 
-// SetUserId sets the userID in the request context for testing purposes.
-func SetUserID(r *http.Request, userId string) *http.Request {
-	ctx := context.WithValue(r.Context(), userIDKey, userId)
-	return r.WithContext(ctx)
+	if s.Role != userRole {
+		oldID := s.ID
+		if err := rotateSession(s, userRole); err != nil {
+			return err
+		}
+		if onRotate != nil {
+			onRotate(oldID, s.ID)
+		}
+		SetSessionCookie(w, *s)
+		// Clients using bearer tokens pick the new ID up from here
+		w.Header().Set("X-Session-Token", s.ID)
+		return nil
+	}
+
+	renewed, err := renewSession(s)
+	if err != nil {
+		return err
+	}
+	if renewed && s.Remember {
+		SetSessionCookie(w, *s)
+	}
+	return nil
 }
-func UserIDKey() contextKey {
-	return userIDKey
+
+// ClearSessionCookie tells the browser to drop its session and CSRF cookies.
+func ClearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Expires:  time.Now().Add(-time.Hour),
+		Path:     "/",
+		HttpOnly: true,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:    CSRFCookie,
+		Value:   "",
+		Expires: time.Now().Add(-time.Hour),
+		Path:    "/",
+	})
 }
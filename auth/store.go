@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"real/config"
+	"real/db"
+	"real/models"
+
+	"github.com/google/uuid"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// maxUserAgentLength caps the stored User-Agent, which the client controls.
+const maxUserAgentLength = 255
+
+// renewInterval is how stale last_used_at may get before a request renews
+// the session, so that not every request writes to the database.
+const renewInterval = time.Minute
+
+// A session ends once it has not been used for the idle timeout, or at the
+// latest the absolute timeout after it started. Remember-me sessions get the
+// longer pair, and their cookie outlives the browser session.
+var (
+	idleTimeout             = config.Duration("SESSION_IDLE_TIMEOUT", 24*time.Hour)
+	absoluteTimeout         = config.Duration("SESSION_ABSOLUTE_TIMEOUT", 7*24*time.Hour)
+	rememberIdleTimeout     = config.Duration("SESSION_REMEMBER_IDLE_TIMEOUT", 30*24*time.Hour)
+	rememberAbsoluteTimeout = config.Duration("SESSION_REMEMBER_ABSOLUTE_TIMEOUT", 90*24*time.Hour)
+)
+
+func timeouts(remember bool) (idle, absolute time.Duration) {
+	if remember {
+		return rememberIdleTimeout, rememberAbsoluteTimeout
+	}
+	return idleTimeout, absoluteTimeout
+}
+
+// idleExpiry is when s ends if it is not used again after now.
+func idleExpiry(s Session, now time.Time) time.Time {
+	idle, _ := timeouts(s.Remember)
+	if expiry := now.Add(idle); expiry.Before(s.AbsoluteExpiresAt) {
+		return expiry
+	}
+	return s.AbsoluteExpiresAt
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// CreateSession starts a new session for userID on the device making r, with
+// the remember-me timeouts when remember is set. Other sessions of the user
+// are left alone.
+func CreateSession(ex execer, r *http.Request, userID int, remember bool) (Session, error) {
+	now := time.Now()
+	_, absolute := timeouts(remember)
+	csrfToken, err := newCSRFToken()
+	if err != nil {
+		return Session{}, err
+	}
+	s := Session{
+		ID:                uuid.New().String(),
+		UserID:            userID,
+		AbsoluteExpiresAt: now.Add(absolute),
+		LastUsedAt:        now,
+		Remember:          remember,
+		CSRFToken:         csrfToken,
+	}
+	s.ExpiresAt = idleExpiry(s, now)
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	result, err := ex.Exec(`
+		INSERT INTO sessions (session_id, user_id, expires_at, absolute_expires_at, remember, role,
+			csrf_token, created_at, last_used_at, user_agent, ip_address)
+		SELECT ?, user_id, ?, ?, ?, role, ?, ?, ?, ?, ? FROM users WHERE user_id = ?`,
+		s.ID, s.ExpiresAt, s.AbsoluteExpiresAt, remember, csrfToken, now, now, userAgent, clientIP(r), userID,
+	)
+	if err != nil {
+		return s, fmt.Errorf("create session: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return s, fmt.Errorf("create session: user %d not found", userID)
+	}
+	return s, nil
+}
+
+// SetSessionCookie sends the session and CSRF cookies for s. Only remember-me
+// sessions get cookies that outlive the browser session.
+func SetSessionCookie(w http.ResponseWriter, s Session) {
+	cookie := &http.Cookie{
+		Name:     SessionCookie,
+		Value:    s.ID,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if s.Remember {
+		cookie.Expires = s.ExpiresAt
+	}
+	http.SetCookie(w, cookie)
+	setCSRFCookie(w, s)
+}
+
+// PublicSessionID identifies a session to its user without revealing the
+// session ID itself, which is a credential.
+func PublicSessionID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// renewSession records that s was just used and pushes its expiry back by
+// the idle timeout, at most once per renewInterval. It reports whether s was
+// renewed.
+func renewSession(s *Session) (bool, error) {
+	now := time.Now()
+	if now.Sub(s.LastUsedAt) < renewInterval {
+		return false, nil
+	}
+	expiresAt := idleExpiry(*s, now)
+	_, err := db.DB.Exec(
+		`UPDATE sessions SET last_used_at = ?, expires_at = ? WHERE session_id = ?`,
+		now, expiresAt, s.ID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("renew session: %w", err)
+	}
+	s.LastUsedAt, s.ExpiresAt = now, expiresAt
+	return true, nil
+}
+
+// rotateSession gives s a new ID and CSRF token, issued for role, keeping
+// everything else about it. The old ID stops working.
+func rotateSession(s *Session, role string) error {
+	now := time.Now()
+	newID := uuid.New().String()
+	csrfToken, err := newCSRFToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := idleExpiry(*s, now)
+	_, err = db.DB.Exec(
+		`UPDATE sessions SET session_id = ?, role = ?, csrf_token = ?, last_used_at = ?, expires_at = ?
+		WHERE session_id = ?`,
+		newID, role, csrfToken, now, expiresAt, s.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("rotate session: %w", err)
+	}
+	s.previousCSRFToken = s.CSRFToken
+	s.ID, s.Role, s.CSRFToken, s.LastUsedAt, s.ExpiresAt = newID, role, csrfToken, now, expiresAt
+	return nil
+}
+
+// ListSessions returns the unexpired sessions of userID, most recently used
+// first, marking currentID as the current one.
+func ListSessions(userID int, currentID string) ([]models.UserSession, error) {
+	rows, err := db.DB.Query(`
+		SELECT session_id, user_agent, ip_address, created_at, last_used_at, expires_at, remember
+		FROM sessions WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	sessions := []models.UserSession{}
+	for rows.Next() {
+		var (
+			sessionID           string
+			s                   models.UserSession
+			createdAt, lastUsed sql.NullTime
+		)
+		if err := rows.Scan(&sessionID, &s.UserAgent, &s.IPAddress, &createdAt, &lastUsed, &s.ExpiresAt, &s.Remember); err != nil {
+			return nil, fmt.Errorf("scan session: %w", err)
+		}
+		if !s.ExpiresAt.After(now) {
+			continue
+		}
+		s.ID = PublicSessionID(sessionID)
+		s.CreatedAt = createdAt.Time
+		s.LastUsedAt = lastUsed.Time
+		if !lastUsed.Valid {
+			s.LastUsedAt = createdAt.Time
+		}
+		s.Current = sessionID == currentID
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions, nil
+}
+
+// userSessionIDs returns the IDs of all sessions of userID.
+func userSessionIDs(userID int) ([]string, error) {
+	rows, err := db.DB.Query(`SELECT session_id FROM sessions WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("load sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RevokeSession ends the session of userID that PublicSessionID calls
+// publicID, and returns its session ID.
+func RevokeSession(userID int, publicID string) (string, error) {
+	ids, err := userSessionIDs(userID)
+	if err != nil {
+		return "", err
+	}
+	for _, id := range ids {
+		if PublicSessionID(id) == publicID {
+			return id, DeleteSession(id)
+		}
+	}
+	return "", ErrSessionNotFound
+}
+
+// RevokeOtherSessions ends every session of userID except keepID, and returns
+// the IDs of the sessions it ended.
+func RevokeOtherSessions(userID int, keepID string) ([]string, error) {
+	ids, err := userSessionIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	revoked := []string{}
+	for _, id := range ids {
+		if id == keepID {
+			continue
+		}
+		if err := DeleteSession(id); err != nil {
+			return revoked, err
+		}
+		revoked = append(revoked, id)
+	}
+	return revoked, nil
+}
+
+// DeleteSession ends a session. Deleting one that is already gone is not an
+// error.
+func DeleteSession(sessionID string) error {
+	if _, err := db.DB.Exec(`DELETE FROM sessions WHERE session_id = ?`, sessionID); err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"real/config"
+	"real/db"
+	"real/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Reasons recorded for failed logins.
+const (
+	LoginUnknownUser = "unknown_user"
+	LoginBadPassword = "bad_password"
+	LoginBadCode     = "bad_code"
+	LoginThrottled   = "throttled"
+	// LoginPending marks an attempt reserved by ReserveLogin whose outcome
+	// is not known yet. It counts as failed, so attempts made in parallel
+	// are throttled by each other.
+	LoginPending = "pending"
+)
+
+// sqliteTimeFormat matches CURRENT_TIMESTAMP, so times can be compared with
+// the created_at column.
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// loginPolicy limits failed logins from one source within loginWindow. The
+// first free failures cost nothing; after that each one doubles the wait
+// before the next attempt, starting at loginBackoffBase, and max failures
+// lock the source out for loginLockout.
+type loginPolicy struct {
+	free, max int
+}
+
+var (
+	loginWindow      = config.Duration("LOGIN_ATTEMPT_WINDOW", time.Hour)
+	loginBackoffBase = config.Duration("LOGIN_BACKOFF_BASE", time.Second)
+	loginLockout     = config.Duration("LOGIN_LOCKOUT", 15*time.Minute)
+
+	// Per account, counted since its last successful login
+	accountPolicy = loginPolicy{free: 3, max: config.Int("LOGIN_MAX_ATTEMPTS", 10)}
+	// Per IP address, across all accounts, so one address cannot guess a
+	// little at many accounts
+	ipPolicy = loginPolicy{free: 10, max: config.Int("LOGIN_IP_MAX_ATTEMPTS", 50)}
+)
+
+// retryAfter is how long to wait after the latest of failures failed logins.
+func (p loginPolicy) retryAfter(failures int, latest, now time.Time) time.Duration {
+	if failures < p.free {
+		return 0
+	}
+	wait := loginLockout
+	// Shifting further would overflow, and be past any sane lockout anyway
+	if doublings := failures - p.free; failures < p.max && doublings < 32 {
+		if backoff := loginBackoffBase << doublings; backoff < wait {
+			wait = backoff
+		}
+	}
+	if remaining := latest.Add(wait).Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// failedLogins counts the failed logins matching condition within
+// loginWindow, and returns when the latest one was. Attempts refused for
+// being throttled are not counted, so that retrying too early does not
+// extend the wait indefinitely.
+func failedLogins(condition string, args ...interface{}) (int, time.Time, error) {
+	since := time.Now().Add(-loginWindow).UTC().Format(sqliteTimeFormat)
+	var (
+		count  int
+		latest string
+	)
+	err := db.DB.QueryRow(`
+		SELECT COUNT(*), COALESCE(MAX(created_at), '') FROM login_attempts
+		WHERE success = 0 AND reason <> '`+LoginThrottled+`' AND created_at > ? AND `+condition,
+		append([]interface{}{since}, args...)...,
+	).Scan(&count, &latest)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("count failed logins: %w", err)
+	}
+	if count == 0 {
+		return 0, time.Time{}, nil
+	}
+	latestAt, err := time.Parse(sqliteTimeFormat, latest)
+	if err != nil {
+		// mattn/go-sqlite3 returns DATETIME columns in RFC 3339
+		latestAt, err = time.Parse(time.RFC3339, latest)
+	}
+	return count, latestAt, err
+}
+
+// ReserveLogin records a login attempt for the account and from the IP
+// address of r before the password or code is checked, and reports how long
+// it must wait, 0 when it may go ahead. userID is 0 for an identifier that
+// matches no account. Only the attempts reserved before this one are
+// counted, so a burst of parallel attempts cannot all pass the same check.
+// An attempt that must wait is recorded as throttled; one that goes ahead
+// must be settled with FinishLogin or CancelLogin.
+func ReserveLogin(r *http.Request, userID int, identifier string) (attemptID int64, wait time.Duration, err error) {
+	attemptID, err = insertLoginAttempt(r, userID, identifier, false, LoginPending)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var (
+		accountFailures int
+		accountLatest   time.Time
+	)
+	if userID > 0 {
+		accountFailures, accountLatest, err = failedLogins(`attempt_id < ? AND user_id = ? AND attempt_id > COALESCE(
+			(SELECT MAX(attempt_id) FROM login_attempts WHERE user_id = ? AND success = 1), 0)`, attemptID, userID, userID)
+	} else {
+		accountFailures, accountLatest, err = failedLogins(`attempt_id < ? AND user_id IS NULL AND identifier = ?`,
+			attemptID, normalizeIdentifier(identifier))
+	}
+	if err != nil {
+		return attemptID, 0, err
+	}
+	ipFailures, ipLatest, err := failedLogins(`attempt_id < ? AND ip_address = ?`, attemptID, clientIP(r))
+	if err != nil {
+		return attemptID, 0, err
+	}
+
+	now := time.Now()
+	wait = accountPolicy.retryAfter(accountFailures, accountLatest, now)
+	if ipWait := ipPolicy.retryAfter(ipFailures, ipLatest, now); ipWait > wait {
+		wait = ipWait
+	}
+	if wait > 0 {
+		if err := FinishLogin(attemptID, false, LoginThrottled); err != nil {
+			return attemptID, 0, err
+		}
+	}
+	return attemptID, wait, nil
+}
+
+// FinishLogin records the outcome of an attempt reserved by ReserveLogin.
+// reason says why a failed attempt failed and is empty for successful ones.
+func FinishLogin(attemptID int64, success bool, reason string) error {
+	_, err := db.DB.Exec(
+		`UPDATE login_attempts SET success = ?, reason = ? WHERE attempt_id = ?`, success, reason, attemptID,
+	)
+	if err != nil {
+		return fmt.Errorf("record login: %w", err)
+	}
+	return nil
+}
+
+// CancelLogin drops an attempt reserved by ReserveLogin that neither failed
+// nor succeeded, such as a password that still needs a two-factor code, or
+// one cut short by a server error.
+func CancelLogin(attemptID int64) error {
+	if _, err := db.DB.Exec(`DELETE FROM login_attempts WHERE attempt_id = ?`, attemptID); err != nil {
+		return fmt.Errorf("cancel login: %w", err)
+	}
+	return nil
+}
+
+// RecordLogin adds a login attempt to the audit log. reason says why a
+// failed attempt failed and is empty for successful ones.
+func RecordLogin(r *http.Request, userID int, identifier string, success bool, reason string) error {
+	_, err := insertLoginAttempt(r, userID, identifier, success, reason)
+	return err
+}
+
+func insertLoginAttempt(r *http.Request, userID int, identifier string, success bool, reason string) (int64, error) {
+	var user interface{}
+	if userID > 0 {
+		user = userID
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	result, err := db.DB.Exec(`
+		INSERT INTO login_attempts (identifier, user_id, ip_address, user_agent, success, reason)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		normalizeIdentifier(identifier), user, clientIP(r), userAgent, success, reason,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("record login: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+func normalizeIdentifier(identifier string) string {
+	identifier = strings.ToLower(strings.TrimSpace(identifier))
+	if len(identifier) > 255 {
+		identifier = identifier[:255]
+	}
+	return identifier
+}
+
+// FailedLogins returns the latest failed logins, newest first, optionally
+// only those for one user.
+func FailedLogins(userID, limit int) ([]models.LoginAttempt, error) {
+	query := `
+		SELECT la.attempt_id, la.identifier, la.user_id, COALESCE(u.username, ''), la.ip_address,
+			la.user_agent, la.reason, la.created_at
+		FROM login_attempts la
+		LEFT JOIN users u ON u.user_id = la.user_id
+		WHERE la.success = 0`
+	args := []interface{}{}
+	if userID > 0 {
+		query += ` AND la.user_id = ?`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY la.attempt_id DESC LIMIT ?`
+
+	rows, err := db.DB.Query(query, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("load failed logins: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []models.LoginAttempt{}
+	for rows.Next() {
+		var a models.LoginAttempt
+		if err := rows.Scan(&a.ID, &a.Identifier, &a.UserID, &a.Username, &a.IPAddress,
+			&a.UserAgent, &a.Reason, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan failed login: %w", err)
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// CompareUnknownUser spends as long as checking a password would, so that
+// responses do not reveal whether an account exists.
+func CompareUnknownUser(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
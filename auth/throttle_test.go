@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginPolicyRetryAfter(t *testing.T) {
+	savedBase, savedLockout := loginBackoffBase, loginLockout
+	defer func() { loginBackoffBase, loginLockout = savedBase, savedLockout }()
+	loginBackoffBase, loginLockout = time.Second, 15*time.Minute
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	policy := loginPolicy{free: 3, max: 10}
+	tests := []struct {
+		name     string
+		policy   loginPolicy
+		failures int
+		ago      time.Duration
+		want     time.Duration
+	}{
+		{"no failures", policy, 0, 0, 0},
+		{"last free failure", policy, 2, 0, 0},
+		{"first paid failure", policy, 3, 0, time.Second},
+		{"doubles", policy, 4, 0, 2 * time.Second},
+		{"doubles again", policy, 5, 0, 4 * time.Second},
+		{"just below max", policy, 9, 0, 64 * time.Second},
+		{"partly waited", policy, 3, 400 * time.Millisecond, 600 * time.Millisecond},
+		{"backoff over", policy, 4, 5 * time.Second, 0},
+		{"locked out at max", policy, 10, 0, 15 * time.Minute},
+		{"lockout partly waited", policy, 12, 14 * time.Minute, time.Minute},
+		{"lockout over", policy, 12, 16 * time.Minute, 0},
+		{"backoff capped at lockout", loginPolicy{free: 3, max: 100}, 20, 0, 15 * time.Minute},
+		{"no overflow", loginPolicy{free: 3, max: 1000}, 90, 0, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.retryAfter(tt.failures, now.Add(-tt.ago), now); got != tt.want {
+				t.Errorf("retryAfter(%d, %v ago) = %v, want %v", tt.failures, tt.ago, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"real/config"
+	"real/db"
+)
+
+// Purposes of emailed tokens. A token only works for the purpose it was
+// issued for.
+const (
+	TokenPasswordReset     = "password_reset"
+	TokenEmailVerification = "email_verification"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid or expired token")
+	ErrTokenTooSoon = errors.New("a token was issued moments ago")
+)
+
+// tokenCooldown is how long after issuing a token for a user another one for
+// the same purpose is refused, so that nobody can flood an inbox.
+const tokenCooldown = time.Minute
+
+var (
+	passwordResetTTL     = config.Duration("PASSWORD_RESET_TTL", time.Hour)
+	emailVerificationTTL = config.Duration("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+)
+
+func tokenTTL(purpose string) time.Duration {
+	if purpose == TokenPasswordReset {
+		return passwordResetTTL
+	}
+	return emailVerificationTTL
+}
+
+// hashToken is how emailed tokens are stored, so that the database alone
+// does not give anyone a working link.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueToken returns a new single-use token for purpose, to be emailed to
+// userID at email. Earlier unused tokens of userID for the same purpose stop
+// working, so only the latest email's link does. Within tokenCooldown of the
+// last one it returns ErrTokenTooSoon instead.
+func IssueToken(userID int, purpose, email string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	var recent bool
+	if err := tx.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM user_tokens WHERE user_id = ? AND purpose = ? AND created_at > ?)`,
+		userID, purpose, now.Add(-tokenCooldown),
+	).Scan(&recent); err != nil {
+		return "", fmt.Errorf("check recent tokens: %w", err)
+	}
+	if recent {
+		return "", ErrTokenTooSoon
+	}
+	if _, err := tx.Exec(
+		`DELETE FROM user_tokens WHERE user_id = ? AND purpose = ? AND used_at IS NULL`, userID, purpose,
+	); err != nil {
+		return "", fmt.Errorf("delete old tokens: %w", err)
+	}
+	_, err = tx.Exec(
+		`INSERT INTO user_tokens (token_hash, user_id, purpose, email, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		hashToken(token), userID, purpose, email, now.Add(tokenTTL(purpose)), now,
+	)
+	if err != nil {
+		return "", fmt.Errorf("store token: %w", err)
+	}
+	return token, tx.Commit()
+}
+
+// ConsumeToken uses up a token issued for purpose, and returns the user and
+// email address it was issued for. Tokens that are unknown, expired, already
+// used or for another purpose return ErrInvalidToken.
+func ConsumeToken(token, purpose string) (userID int, email string, err error) {
+	hash := hashToken(token)
+	now := time.Now()
+	result, err := db.DB.Exec(`
+		UPDATE user_tokens SET used_at = ?
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?`,
+		now, hash, purpose, now,
+	)
+	if err != nil {
+		return 0, "", fmt.Errorf("use token: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, "", ErrInvalidToken
+	}
+	err = db.DB.QueryRow(`SELECT user_id, email FROM user_tokens WHERE token_hash = ?`, hash).Scan(&userID, &email)
+	if err != nil {
+		return 0, "", fmt.Errorf("load token: %w", err)
+	}
+	return userID, email, nil
+}
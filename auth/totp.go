@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"real/config"
+)
+
+// TOTP parameters, those of RFC 6238 and every common authenticator app.
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	// totpSkew is how many periods a code may be off by either way, to
+	// allow for clock drift and codes entered just as they change
+	totpSkew = 1
+	// totpSecretSize is the size of secrets in bytes, as RFC 4226
+	// recommends
+	totpSecretSize = 20
+)
+
+// totpIssuer names the site in authenticator apps.
+var totpIssuer = config.String("TOTP_ISSUER", "Forum")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random base32 encoded secret.
+func newTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate totp secret: %w", err)
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpURI is the otpauth URI that authenticator apps enroll secret from,
+// usually shown as a QR code.
+func totpURI(secret, account string) string {
+	label := url.PathEscape(totpIssuer + ":" + account)
+	query := url.Values{
+		"secret": {secret},
+		"issuer": {totpIssuer},
+		"digits": {fmt.Sprint(totpDigits)},
+		"period": {fmt.Sprint(int(totpPeriod.Seconds()))},
+	}
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// totpCode is the code for secret in time step step.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("decode totp secret: %w", err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// matchTOTP returns the time step code is valid for around now, and whether
+// there is one. Steps up to and including after are not accepted, so that a
+// code cannot be used twice.
+func matchTOTP(secret, code string, now time.Time, after int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / int64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= after {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,335 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"real/config"
+	"real/db"
+)
+
+var (
+	ErrTwoFactorEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	ErrNoEnrollment        = errors.New("no two-factor enrollment in progress")
+	ErrInvalidCode         = errors.New("invalid code")
+	ErrPendingLogin        = errors.New("login expired, please log in again")
+)
+
+const (
+	// recoveryCodeCount is how many recovery codes a user gets at a time;
+	// each can be used once instead of a TOTP code
+	recoveryCodeCount = 10
+	// pendingLoginAttempts is how many codes may be tried for one pending
+	// login before the password has to be entered again
+	pendingLoginAttempts = 5
+)
+
+// pendingLoginTimeout is how long a user has to enter their code after their
+// password.
+var pendingLoginTimeout = config.Duration("TWO_FACTOR_LOGIN_TIMEOUT", 5*time.Minute)
+
+// TwoFactorEnabled reports whether userID has to enter a code to log in.
+func TwoFactorEnabled(userID int) (bool, error) {
+	var enabled bool
+	err := db.DB.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM user_totp WHERE user_id = ? AND enabled_at IS NOT NULL)`, userID,
+	).Scan(&enabled)
+	if err != nil {
+		return false, fmt.Errorf("check two-factor: %w", err)
+	}
+	return enabled, nil
+}
+
+// BeginTOTPEnrollment gives userID a new TOTP secret, which takes effect once
+// ConfirmTOTPEnrollment is passed a code generated from it. It returns the
+// secret and the otpauth URI for authenticator apps, labelled with account.
+// Starting over replaces a secret that was never confirmed.
+func BeginTOTPEnrollment(userID int, account string) (secret, uri string, err error) {
+	enabled, err := TwoFactorEnabled(userID)
+	if err != nil {
+		return "", "", err
+	}
+	if enabled {
+		return "", "", ErrTwoFactorEnabled
+	}
+	secret, err = newTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	_, err = db.DB.Exec(`
+		INSERT INTO user_totp (user_id, secret) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, enabled_at = NULL, last_step = 0`,
+		userID, secret,
+	)
+	if err != nil {
+		return "", "", fmt.Errorf("begin totp enrollment: %w", err)
+	}
+	return secret, totpURI(secret, account), nil
+}
+
+// ConfirmTOTPEnrollment turns on two-factor authentication for userID when
+// code matches the secret from BeginTOTPEnrollment, and returns the user's
+// recovery codes. They are only stored hashed, so this is the one time they
+// can be shown.
+func ConfirmTOTPEnrollment(userID int, code string) ([]string, error) {
+	var (
+		secret  string
+		enabled sql.NullTime
+	)
+	err := db.DB.QueryRow(`SELECT secret, enabled_at FROM user_totp WHERE user_id = ?`, userID).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoEnrollment
+	} else if err != nil {
+		return nil, fmt.Errorf("load totp enrollment: %w", err)
+	}
+	if enabled.Valid {
+		return nil, ErrTwoFactorEnabled
+	}
+	step, ok := matchTOTP(secret, code, time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(
+		`UPDATE user_totp SET enabled_at = ?, last_step = ? WHERE user_id = ?`, time.Now(), step, userID,
+	); err != nil {
+		return nil, fmt.Errorf("enable totp: %w", err)
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of userID, used or not,
+// with new ones.
+func RegenerateRecoveryCodes(userID int) ([]string, error) {
+	enabled, err := TwoFactorEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// DisableTwoFactor turns two-factor authentication off for userID, dropping
+// its secret and recovery codes.
+func DisableTwoFactor(userID int) error {
+	enabled, err := TwoFactorEnabled(userID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrTwoFactorNotEnabled
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, query := range []string{
+		`DELETE FROM user_totp WHERE user_id = ?`,
+		`DELETE FROM recovery_codes WHERE user_id = ?`,
+		`DELETE FROM pending_logins WHERE user_id = ?`,
+	} {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return fmt.Errorf("disable two-factor: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, fmt.Errorf("delete recovery codes: %w", err)
+	}
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("generate recovery code: %w", err)
+		}
+		// 10 characters, 50 bits, shown as xxxxx-xxxxx
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		if _, err := tx.Exec(
+			`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hashRecoveryCode(code),
+		); err != nil {
+			return nil, fmt.Errorf("store recovery code: %w", err)
+		}
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case, dashes and spaces.
+// The codes are random enough that a fast hash is fine.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreatePendingLogin records that userID got their password right, and
+// returns a token that completes the login along with a code within
+// pendingLoginTimeout.
+func CreatePendingLogin(userID int, remember bool) (token string, expiresAt time.Time, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, fmt.Errorf("generate pending login token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now()
+	expiresAt = now.Add(pendingLoginTimeout)
+	if _, err := db.DB.Exec(`DELETE FROM pending_logins WHERE expires_at < ?`, now); err != nil {
+		return "", time.Time{}, fmt.Errorf("delete expired pending logins: %w", err)
+	}
+	_, err = db.DB.Exec(
+		`INSERT INTO pending_logins (token, user_id, remember, expires_at) VALUES (?, ?, ?, ?)`,
+		token, userID, remember, expiresAt,
+	)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("create pending login: %w", err)
+	}
+	return token, expiresAt, nil
+}
+
+// PendingLoginUser returns the user a pending login is for, or
+// ErrPendingLogin when it has expired or used up its attempts.
+func PendingLoginUser(token string) (int, error) {
+	var (
+		userID, attempts int
+		expiresAt        time.Time
+	)
+	err := db.DB.QueryRow(
+		`SELECT user_id, attempts, expires_at FROM pending_logins WHERE token = ?`, token,
+	).Scan(&userID, &attempts, &expiresAt)
+	if err == sql.ErrNoRows {
+		return 0, ErrPendingLogin
+	} else if err != nil {
+		return 0, fmt.Errorf("load pending login: %w", err)
+	}
+	if attempts >= pendingLoginAttempts || !expiresAt.After(time.Now()) {
+		return 0, ErrPendingLogin
+	}
+	return userID, nil
+}
+
+// CompletePendingLogin checks code, a TOTP code or an unused recovery code,
+// for the pending login token. On success the pending login is used up and
+// its user and remember-me choice returned; a wrong code returns
+// ErrInvalidCode. Every code tried counts against the pending login's
+// attempts before it is checked, so parallel requests cannot try more than
+// pendingLoginAttempts codes between them.
+func CompletePendingLogin(token, code string) (userID int, remember bool, err error) {
+	result, err := db.DB.Exec(
+		`UPDATE pending_logins SET attempts = attempts + 1 WHERE token = ? AND attempts < ? AND expires_at > ?`,
+		token, pendingLoginAttempts, time.Now(),
+	)
+	if err != nil {
+		return 0, false, fmt.Errorf("count pending login attempt: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, false, ErrPendingLogin
+	}
+	err = db.DB.QueryRow(`SELECT user_id, remember FROM pending_logins WHERE token = ?`, token).Scan(&userID, &remember)
+	if err == sql.ErrNoRows {
+		return 0, false, ErrPendingLogin
+	} else if err != nil {
+		return 0, false, fmt.Errorf("load pending login: %w", err)
+	}
+
+	ok, err := checkSecondFactor(userID, code)
+	if err != nil {
+		return 0, false, err
+	}
+	if !ok {
+		return 0, false, ErrInvalidCode
+	}
+	// Only the request that deletes the pending login gets to use it
+	result, err = db.DB.Exec(`DELETE FROM pending_logins WHERE token = ?`, token)
+	if err != nil {
+		return 0, false, fmt.Errorf("delete pending login: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, false, ErrPendingLogin
+	}
+	return userID, remember, nil
+}
+
+// checkSecondFactor reports whether code is a current TOTP code of userID
+// that was not used before, or one of its unused recovery codes, and uses it
+// up.
+func checkSecondFactor(userID int, code string) (bool, error) {
+	var (
+		secret   string
+		lastStep int64
+	)
+	err := db.DB.QueryRow(
+		`SELECT secret, last_step FROM user_totp WHERE user_id = ? AND enabled_at IS NOT NULL`, userID,
+	).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return false, ErrTwoFactorNotEnabled
+	} else if err != nil {
+		return false, fmt.Errorf("load totp secret: %w", err)
+	}
+
+	if step, ok := matchTOTP(secret, code, time.Now(), lastStep); ok {
+		// Only the first request to move last_step forward may use the code
+		result, err := db.DB.Exec(
+			`UPDATE user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?`, step, userID, step,
+		)
+		if err != nil {
+			return false, fmt.Errorf("record totp use: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		return n > 0, nil
+	}
+
+	result, err := db.DB.Exec(
+		`UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		time.Now(), userID, hashRecoveryCode(code),
+	)
+	if err != nil {
+		return false, fmt.Errorf("use recovery code: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// RemainingRecoveryCodes counts the unused recovery codes of userID.
+func RemainingRecoveryCodes(userID int) (int, error) {
+	var n int
+	err := db.DB.QueryRow(
+		`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID,
+	).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("count recovery codes: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings are read from environment variables so deployments can tune them
+// without a rebuild. Invalid values are logged and the default is used.
+
+// String returns the environment variable key, or def when it is unset.
+func String(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok && strings.TrimSpace(v) != "" {
+		return strings.TrimSpace(v)
+	}
+	return def
+}
+
+// Duration parses key with time.ParseDuration (e.g. "15m", "24h").
+func Duration(key string, def time.Duration) time.Duration {
+	v := String(key, "")
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("config: invalid duration for %s=%q, using %v", key, v, def)
+		return def
+	}
+	return d
+}
+
+// Int parses key as a base-10 integer.
+func Int(key string, def int) int {
+	v := String(key, "")
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("config: invalid integer for %s=%q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// Bool parses key with strconv.ParseBool ("true", "1", "false", ...).
+func Bool(key string, def bool) bool {
+	v := String(key, "")
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("config: invalid boolean for %s=%q, using %t", key, v, def)
+		return def
+	}
+	return b
+}
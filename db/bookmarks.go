@@ -0,0 +1,386 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"real/models"
+)
+
+var (
+	ErrCollectionNotFound = errors.New("collection not found")
+	ErrCollectionExists   = errors.New("a collection with this name already exists")
+	ErrBookmarkNotFound   = errors.New("bookmark not found")
+	ErrBookmarkTarget     = errors.New("bookmark target not found")
+	ErrBookmarkOrder      = errors.New("bookmark does not belong to this collection")
+)
+
+// bookmarkTargetQueries check that a bookmark target exists.
+var bookmarkTargetQueries = map[string]string{
+	models.ReactionTargetPost:    `SELECT EXISTS(SELECT 1 FROM posts WHERE post_id = ?)`,
+	models.ReactionTargetComment: `SELECT EXISTS(SELECT 1 FROM comments WHERE comment_id = ? AND deleted_at IS NULL)`,
+}
+
+// GetBookmarkCollections lists userID's collections in display order.
+func GetBookmarkCollections(userID int) ([]models.BookmarkCollection, error) {
+	rows, err := DB.Query(`
+		SELECT c.collection_id, c.name, c.position, c.created_at,
+		       (SELECT COUNT(*) FROM bookmarks b WHERE b.collection_id = c.collection_id)
+		FROM bookmark_collections c
+		WHERE c.user_id = ?
+		ORDER BY c.position, c.collection_id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("load collections: %w", err)
+	}
+	defer rows.Close()
+
+	collections := []models.BookmarkCollection{}
+	for rows.Next() {
+		var c models.BookmarkCollection
+		if err := rows.Scan(&c.CollectionID, &c.Name, &c.Position, &c.CreatedAt, &c.ItemCount); err != nil {
+			return nil, fmt.Errorf("scan collection: %w", err)
+		}
+		collections = append(collections, c)
+	}
+	return collections, rows.Err()
+}
+
+// CreateBookmarkCollection adds a collection at the end of userID's list.
+func CreateBookmarkCollection(userID int, name string) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := createCollection(tx, userID, name)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func createCollection(tx *sql.Tx, userID int, name string) (int, error) {
+	result, err := tx.Exec(`
+		INSERT INTO bookmark_collections (user_id, name, position)
+		VALUES (?, ?, (SELECT COALESCE(MAX(position), -1) + 1 FROM bookmark_collections WHERE user_id = ?))`,
+		userID, name, userID,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return 0, ErrCollectionExists
+		}
+		return 0, fmt.Errorf("insert collection: %w", err)
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// RenameBookmarkCollection renames one of userID's collections.
+func RenameBookmarkCollection(userID, collectionID int, name string) error {
+	result, err := DB.Exec(
+		`UPDATE bookmark_collections SET name = ? WHERE collection_id = ? AND user_id = ?`,
+		name, collectionID, userID,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return ErrCollectionExists
+		}
+		return fmt.Errorf("rename collection: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrCollectionNotFound
+	}
+	return nil
+}
+
+// DeleteBookmarkCollection removes a collection and everything saved in it.
+func DeleteBookmarkCollection(userID, collectionID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`DELETE FROM bookmark_collections WHERE collection_id = ? AND user_id = ?`,
+		collectionID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete collection: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrCollectionNotFound
+	}
+	if _, err := tx.Exec(`DELETE FROM bookmarks WHERE collection_id = ?`, collectionID); err != nil {
+		return fmt.Errorf("delete bookmarks: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ReorderBookmarkCollections puts userID's collections in the given order.
+// Collections left out keep their relative order after the listed ones.
+func ReorderBookmarkCollections(userID int, collectionIDs []int) error {
+	return reorder(
+		`SELECT collection_id FROM bookmark_collections WHERE user_id = ? ORDER BY position, collection_id`,
+		`UPDATE bookmark_collections SET position = ? WHERE collection_id = ?`,
+		[]interface{}{userID}, collectionIDs, ErrCollectionNotFound,
+	)
+}
+
+// AddBookmark saves a post or comment to one of userID's collections, or to
+// the default collection when collectionID is 0. Saving something already in
+// the collection returns the existing bookmark.
+func AddBookmark(userID, collectionID int, targetType string, targetID int) (int, error) {
+	existsQuery, ok := bookmarkTargetQueries[targetType]
+	if !ok {
+		return 0, ErrBookmarkTarget
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(existsQuery, targetID).Scan(&exists); err != nil {
+		return 0, fmt.Errorf("check target: %w", err)
+	}
+	if !exists {
+		return 0, ErrBookmarkTarget
+	}
+
+	if collectionID == 0 {
+		collectionID, err = defaultCollection(tx, userID)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		var owned bool
+		if err := tx.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM bookmark_collections WHERE collection_id = ? AND user_id = ?)`,
+			collectionID, userID,
+		).Scan(&owned); err != nil {
+			return 0, err
+		}
+		if !owned {
+			return 0, ErrCollectionNotFound
+		}
+	}
+
+	var bookmarkID int
+	err = tx.QueryRow(
+		`SELECT bookmark_id FROM bookmarks WHERE collection_id = ? AND target_type = ? AND target_id = ?`,
+		collectionID, targetType, targetID,
+	).Scan(&bookmarkID)
+	if err == nil {
+		return bookmarkID, nil
+	} else if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("load bookmark: %w", err)
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO bookmarks (collection_id, user_id, target_type, target_id, position)
+		VALUES (?, ?, ?, ?, (SELECT COALESCE(MAX(position), -1) + 1 FROM bookmarks WHERE collection_id = ?))`,
+		collectionID, userID, targetType, targetID, collectionID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("insert bookmark: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// defaultCollection returns userID's default collection, creating it on first
+// use.
+func defaultCollection(tx *sql.Tx, userID int) (int, error) {
+	var id int
+	err := tx.QueryRow(
+		`SELECT collection_id FROM bookmark_collections WHERE user_id = ? AND name = ?`,
+		userID, models.DefaultBookmarkCollection,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return createCollection(tx, userID, models.DefaultBookmarkCollection)
+	}
+	return id, err
+}
+
+// RemoveBookmark deletes one of userID's bookmarks.
+func RemoveBookmark(userID, bookmarkID int) error {
+	result, err := DB.Exec(`DELETE FROM bookmarks WHERE bookmark_id = ? AND user_id = ?`, bookmarkID, userID)
+	if err != nil {
+		return fmt.Errorf("delete bookmark: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrBookmarkNotFound
+	}
+	return nil
+}
+
+// RemoveBookmarkTarget unsaves a post or comment from every one of userID's
+// collections.
+func RemoveBookmarkTarget(userID int, targetType string, targetID int) error {
+	result, err := DB.Exec(
+		`DELETE FROM bookmarks WHERE user_id = ? AND target_type = ? AND target_id = ?`,
+		userID, targetType, targetID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete bookmark: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrBookmarkNotFound
+	}
+	return nil
+}
+
+// GetBookmarks lists the items in one of userID's collections in order, with
+// enough of each post or comment to display it.
+func GetBookmarks(userID, collectionID int) ([]models.Bookmark, error) {
+	var owned bool
+	if err := DB.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM bookmark_collections WHERE collection_id = ? AND user_id = ?)`,
+		collectionID, userID,
+	).Scan(&owned); err != nil {
+		return nil, err
+	}
+	if !owned {
+		return nil, ErrCollectionNotFound
+	}
+
+	rows, err := DB.Query(`
+		SELECT b.bookmark_id, b.collection_id, b.target_type, b.target_id, b.position, b.created_at,
+		       COALESCE(p.post_id, c.post_id, 0),
+		       COALESCE(p.title, cp.title, ''),
+		       CASE WHEN b.target_type = 'post' THEN COALESCE(p.content, '')
+		            WHEN c.deleted_at IS NULL THEN COALESCE(c.content, '')
+		            ELSE '' END,
+		       COALESCE(pu.username, cu.username, ''),
+		       (p.post_id IS NULL AND (c.comment_id IS NULL OR c.deleted_at IS NOT NULL))
+		FROM bookmarks b
+		LEFT JOIN posts p ON b.target_type = 'post' AND p.post_id = b.target_id
+		LEFT JOIN users pu ON pu.user_id = p.user_id
+		LEFT JOIN comments c ON b.target_type = 'comment' AND c.comment_id = b.target_id
+		LEFT JOIN users cu ON cu.user_id = c.user_id
+		LEFT JOIN posts cp ON cp.post_id = c.post_id
+		WHERE b.collection_id = ?
+		ORDER BY b.position, b.bookmark_id`, collectionID)
+	if err != nil {
+		return nil, fmt.Errorf("load bookmarks: %w", err)
+	}
+	defer rows.Close()
+
+	bookmarks := []models.Bookmark{}
+	for rows.Next() {
+		var b models.Bookmark
+		if err := rows.Scan(
+			&b.BookmarkID, &b.CollectionID, &b.TargetType, &b.TargetID, &b.Position, &b.CreatedAt,
+			&b.PostID, &b.Title, &b.Excerpt, &b.Author, &b.Deleted,
+		); err != nil {
+			return nil, fmt.Errorf("scan bookmark: %w", err)
+		}
+		b.Excerpt = excerpt(b.Excerpt, 200)
+		bookmarks = append(bookmarks, b)
+	}
+	return bookmarks, rows.Err()
+}
+
+// ReorderBookmarks puts the bookmarks of a collection in the given order.
+// Bookmarks left out keep their relative order after the listed ones.
+func ReorderBookmarks(userID, collectionID int, bookmarkIDs []int) error {
+	var owned bool
+	if err := DB.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM bookmark_collections WHERE collection_id = ? AND user_id = ?)`,
+		collectionID, userID,
+	).Scan(&owned); err != nil {
+		return err
+	}
+	if !owned {
+		return ErrCollectionNotFound
+	}
+
+	return reorder(
+		`SELECT bookmark_id FROM bookmarks WHERE collection_id = ? ORDER BY position, bookmark_id`,
+		`UPDATE bookmarks SET position = ? WHERE bookmark_id = ?`,
+		[]interface{}{collectionID}, bookmarkIDs, ErrBookmarkOrder,
+	)
+}
+
+// reorder rewrites the positions of the rows listed by selectQuery so that
+// ids come first, in order, followed by the remaining rows. It returns
+// errForeign if ids contains a row selectQuery does not list.
+func reorder(selectQuery, updateQuery string, args []interface{}, ids []int, errForeign error) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := reorderTx(tx, selectQuery, updateQuery, args, ids, errForeign); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// reorderTx is reorder inside an existing transaction.
+func reorderTx(tx *sql.Tx, selectQuery, updateQuery string, args []interface{}, ids []int, errForeign error) error {
+	rows, err := tx.Query(selectQuery, args...)
+	if err != nil {
+		return err
+	}
+	var current []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		current = append(current, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	known := make(map[int]bool, len(current))
+	for _, id := range current {
+		known[id] = true
+	}
+	placed := make(map[int]bool, len(ids))
+	order := make([]int, 0, len(current))
+	for _, id := range ids {
+		if !known[id] {
+			return errForeign
+		}
+		if !placed[id] {
+			placed[id] = true
+			order = append(order, id)
+		}
+	}
+	for _, id := range current {
+		if !placed[id] {
+			order = append(order, id)
+		}
+	}
+
+	for position, id := range order {
+		if _, err := tx.Exec(updateQuery, position, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// excerpt shortens s to at most n runes.
+func excerpt(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
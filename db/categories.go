@@ -0,0 +1,256 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"real/models"
+)
+
+var (
+	ErrCategoryNotFound = errors.New("category not found")
+	ErrCategoryExists   = errors.New("a category with this name already exists")
+	ErrCategoryParent   = errors.New("parent must be an existing category outside this one")
+	ErrCategoryInUse    = errors.New("category has posts or subcategories; archive it instead")
+	ErrCategoryArchived = errors.New("category is archived")
+)
+
+const categoryColumns = `c.category_id, c.name, COALESCE(c.description, ''), c.parent_id, c.color, c.icon, c.position, c.archived`
+
+// scanCategory reads a row selected with categoryColumns, followed by dest.
+func scanCategory(row interface{ Scan(...interface{}) error }, dest ...interface{}) (models.Category, error) {
+	var c models.Category
+	var parentID sql.NullInt64
+	err := row.Scan(append([]interface{}{
+		&c.CategoryID, &c.Name, &c.Description, &parentID, &c.Color, &c.Icon, &c.Position, &c.Archived,
+	}, dest...)...)
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		c.ParentID = &id
+	}
+	return c, err
+}
+
+// GetAllCategories lists categories in display order with their post counts.
+// Archived categories are left out unless includeArchived is set.
+func GetAllCategories(includeArchived bool) ([]models.Category, error) {
+	query := `
+		WITH RECURSIVE tree(ancestor_id, category_id) AS (
+			SELECT category_id, category_id FROM categories
+			UNION ALL
+			SELECT tree.ancestor_id, c.category_id FROM categories c JOIN tree ON c.parent_id = tree.category_id
+		)
+		SELECT ` + categoryColumns + `,
+			(SELECT COUNT(*) FROM post_categories WHERE category_id = c.category_id),
+			(SELECT COUNT(DISTINCT pc.post_id) FROM tree
+				JOIN post_categories pc ON pc.category_id = tree.category_id
+				WHERE tree.ancestor_id = c.category_id)
+		FROM categories c`
+	if !includeArchived {
+		query += ` WHERE NOT c.archived`
+	}
+	query += ` ORDER BY c.position, c.name`
+
+	rows, err := DB.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("load categories: %w", err)
+	}
+	defer rows.Close()
+
+	categories := []models.Category{}
+	for rows.Next() {
+		var postCount, totalPostCount int
+		c, err := scanCategory(rows, &postCount, &totalPostCount)
+		if err != nil {
+			return nil, fmt.Errorf("scan category: %w", err)
+		}
+		c.PostCount, c.TotalPostCount = postCount, totalPostCount
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+// GetCategory loads one category, without post counts.
+func GetCategory(categoryID int) (models.Category, error) {
+	c, err := scanCategory(DB.QueryRow(`SELECT `+categoryColumns+` FROM categories c WHERE c.category_id = ?`, categoryID))
+	if err == sql.ErrNoRows {
+		return c, ErrCategoryNotFound
+	}
+	return c, err
+}
+
+// CreateCategory adds a category after the existing ones and returns its ID.
+func CreateCategory(c models.Category) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := checkCategoryName(tx, 0, c.Name); err != nil {
+		return 0, err
+	}
+	if err := checkCategoryParent(tx, 0, c.ParentID); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(`
+		INSERT INTO categories (name, description, parent_id, color, icon, archived, position)
+		VALUES (?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM categories))`,
+		c.Name, c.Description, c.ParentID, c.Color, c.Icon, c.Archived,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return 0, ErrCategoryExists
+		}
+		return 0, fmt.Errorf("insert category: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// UpdateCategory saves every field of c except its position.
+func UpdateCategory(c models.Category) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkCategoryName(tx, c.CategoryID, c.Name); err != nil {
+		return err
+	}
+	if err := checkCategoryParent(tx, c.CategoryID, c.ParentID); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`
+		UPDATE categories
+		SET name = ?, description = ?, parent_id = ?, color = ?, icon = ?, archived = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE category_id = ?`,
+		c.Name, c.Description, c.ParentID, c.Color, c.Icon, c.Archived, c.CategoryID,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return ErrCategoryExists
+		}
+		return fmt.Errorf("update category: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrCategoryNotFound
+	}
+	return tx.Commit()
+}
+
+// checkCategoryName returns ErrCategoryExists if a category other than
+// categoryID already has name, ignoring case.
+func checkCategoryName(tx *sql.Tx, categoryID int, name string) error {
+	var taken bool
+	err := tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM categories WHERE name = ? COLLATE NOCASE AND category_id != ?)`,
+		name, categoryID,
+	).Scan(&taken)
+	if err != nil {
+		return fmt.Errorf("check category name: %w", err)
+	}
+	if taken {
+		return ErrCategoryExists
+	}
+	return nil
+}
+
+// checkCategoryParent returns ErrCategoryParent unless parentID is nil or an
+// existing category that is neither categoryID nor nested under it.
+func checkCategoryParent(tx *sql.Tx, categoryID int, parentID *int) error {
+	if parentID == nil {
+		return nil
+	}
+	var valid bool
+	err := tx.QueryRow(`
+		WITH RECURSIVE subtree(category_id) AS (
+			SELECT ?
+			UNION
+			SELECT c.category_id FROM categories c JOIN subtree ON c.parent_id = subtree.category_id
+		)
+		SELECT EXISTS (SELECT 1 FROM categories WHERE category_id = ?)
+			AND ? NOT IN (SELECT category_id FROM subtree)`,
+		categoryID, *parentID, *parentID,
+	).Scan(&valid)
+	if err != nil {
+		return fmt.Errorf("check parent category: %w", err)
+	}
+	if !valid {
+		return ErrCategoryParent
+	}
+	return nil
+}
+
+// DeleteCategory removes a category that has no posts and no subcategories,
+// along with its reaction settings and follows.
+func DeleteCategory(categoryID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists, inUse bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM categories WHERE category_id = ?1),
+			EXISTS (SELECT 1 FROM post_categories WHERE category_id = ?1)
+			OR EXISTS (SELECT 1 FROM categories WHERE parent_id = ?1)`,
+		categoryID,
+	).Scan(&exists, &inUse)
+	if err != nil {
+		return fmt.Errorf("check category: %w", err)
+	}
+	if !exists {
+		return ErrCategoryNotFound
+	}
+	if inUse {
+		return ErrCategoryInUse
+	}
+
+	for _, query := range []string{
+		`DELETE FROM category_reaction_types WHERE category_id = ?`,
+		`DELETE FROM category_follows WHERE category_id = ?`,
+		`DELETE FROM categories WHERE category_id = ?`,
+	} {
+		if _, err := tx.Exec(query, categoryID); err != nil {
+			return fmt.Errorf("delete category: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ReorderCategories puts categories in the given order. Subcategories are
+// shown in the same relative order under their parent.
+func ReorderCategories(categoryIDs []int) error {
+	return reorder(
+		`SELECT category_id FROM categories ORDER BY position, name`,
+		`UPDATE categories SET position = ? WHERE category_id = ?`,
+		nil, categoryIDs, ErrCategoryNotFound,
+	)
+}
+
+// CheckCategoryIDs returns ErrCategoryNotFound or ErrCategoryArchived, naming
+// the category, unless every ID is a category that takes new posts.
+func CheckCategoryIDs(categoryIDs []int) error {
+	for _, id := range categoryIDs {
+		var name string
+		var archived bool
+		err := DB.QueryRow(`SELECT name, archived FROM categories WHERE category_id = ?`, id).Scan(&name, &archived)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %d", ErrCategoryNotFound, id)
+		} else if err != nil {
+			return err
+		}
+		if archived {
+			return fmt.Errorf("%w: %s", ErrCategoryArchived, name)
+		}
+	}
+	return nil
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"time"
 
@@ -27,8 +26,8 @@ func Init(dbPath string) error {
 		return fmt.Errorf("failed to create tables: %v", err)
 	}
 
-	if err = createCategories(); err != nil {
-		return fmt.Errorf("failed to create categories: %v", err)
+	if err = migrate(); err != nil {
+		return fmt.Errorf("failed to migrate database: %v", err)
 	}
 
 	// Start session cleanup scheduler
@@ -56,27 +55,6 @@ func createTables() error {
 	return nil
 }
 
-func createCategories() error {
-	categories := []struct {
-		Name, Description string
-	}{
-		{"Technology", "Posts related to the latest technology and trends"},
-		{"Health", "Discussions about health, fitness, and well-being"},
-		{"Education", "Topics about learning and education"},
-		{"Entertainment", "Movies, music, games, and all things fun"},
-		{"Lifestyle", "Fashion, home decor, and daily living tips"},
-		{"Travel", "Exploring the world, sharing travel experiences"},
-	}
-
-	for _, c := range categories {
-		_, err := DB.Exec(`INSERT OR IGNORE INTO categories (name, description) VALUES (?, ?)`, c.Name, c.Description)
-		if err != nil {
-			return fmt.Errorf("error inserting category '%s': '%v'", c.Name, err)
-		}
-	}
-	return nil
-}
-
 func CleanupExpiredSessions() error {
 	query := `DELETE FROM sessions WHERE expires_at < ?`
 	_, err := DB.Exec(query, time.Now())
@@ -105,55 +83,65 @@ func GetUser(userID int) ([]string, error) {
 	return []string{username, bio, profilePicture}, nil
 }
 
-func GetAllCategories() ([]models.Category, error) {
-	rows, err := DB.Query("SELECT category_id, name FROM categories")
+// GetUserRole returns the role of a user, defaulting to models.RoleUser.
+func GetUserRole(userID int) (string, error) {
+	var role string
+	err := DB.QueryRow(`SELECT COALESCE(role, ?) FROM users WHERE user_id = ?`, models.RoleUser, userID).Scan(&role)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	defer rows.Close()
+	return role, nil
+}
 
-	var categories []models.Category
-	for rows.Next() {
-		var c models.Category
-		if err := rows.Scan(&c.CategoryID, &c.Name); err != nil {
-			return nil, err
+// IsModerator reports whether the user may moderate content. Admins are
+// implicitly moderators.
+func IsModerator(userID int) bool {
+	if userID == 0 {
+		return false
+	}
+	role, err := GetUserRole(userID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error fetching role for user %d: %v", userID, err)
 		}
-		categories = append(categories, c)
+		return false
 	}
-
-	return categories, nil
+	return role == models.RoleModerator || role == models.RoleAdmin
 }
 
-// GetCurrentUserIDFromSession checks for a valid session cookie and returns the user ID.
-func GetCurrentUserIDFromSession(r *http.Request) (int, error) {
-    cookie, err := r.Cookie("session_id")
-    if err != nil {
-        log.Printf("Error retrieving session_token cookie: %v", err)
-        return 0, err
-    }
-
-	sessionID := cookie.Value
-
-	var userID int
-	var expiresAt time.Time
-	err = DB.QueryRow("SELECT user_id, expires_at FROM sessions WHERE session_id = ?", sessionID).Scan(&userID, &expiresAt)
+// IsAdmin reports whether the user may change site configuration.
+func IsAdmin(userID int) bool {
+	if userID == 0 {
+		return false
+	}
+	role, err := GetUserRole(userID)
 	if err != nil {
-		log.Printf("Error querying session: %v", err)
-		return 0, err
+		if err != sql.ErrNoRows {
+			log.Printf("Error fetching role for user %d: %v", userID, err)
+		}
+		return false
 	}
+	return role == models.RoleAdmin
+}
 
-    if expiresAt.Before(time.Now()) {
-        log.Printf("Session expired: %v", expiresAt)
-        return 0, fmt.Errorf("session expired")
-    }
+// EmailVerified reports whether the user has confirmed their email address.
+func EmailVerified(userID int) (bool, error) {
+	var verified bool
+	err := DB.QueryRow(`SELECT email_verified_at IS NOT NULL FROM users WHERE user_id = ?`, userID).Scan(&verified)
+	return verified, err
+}
 
-    return userID, nil
-	if expiresAt.Before(time.Now()) {
-		log.Printf("Session expired: %v", expiresAt)
-		return 0, fmt.Errorf("session expired")
+// MarkEmailVerified records that the user confirmed email, provided it is
+// still their address. It reports whether it was.
+func MarkEmailVerified(userID int, email string) (bool, error) {
+	result, err := DB.Exec(`
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, ?)
+		WHERE user_id = ? AND email = ?`, time.Now(), userID, email)
+	if err != nil {
+		return false, err
 	}
-
-	return userID, nil
+	n, _ := result.RowsAffected()
+	return n > 0, nil
 }
 
 // UpdateUserStatus updates the is_online status in the database.
@@ -0,0 +1,239 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"real/models"
+)
+
+var ErrDraftNotFound = errors.New("draft not found")
+
+const draftColumns = `draft_id, user_id, title, content, categories, tags, images, poll,
+	publish_at, publish_error, created_at, updated_at`
+
+// SaveDraft creates a draft, or updates d.DraftID when it belongs to d.UserID,
+// and returns its ID. The schedule of an existing draft is left untouched, and
+// so are its images unless d has some.
+func SaveDraft(d models.PostDraft) (int, error) {
+	var images, poll interface{}
+	if len(d.Images) > 0 {
+		encoded, err := json.Marshal(d.Images)
+		if err != nil {
+			return 0, err
+		}
+		images = string(encoded)
+	}
+	if len(d.Poll) > 0 {
+		poll = string(d.Poll)
+	}
+	categories := joinCategoryIDs(d.CategoryIDs)
+	tags := joinTags(d.Tags)
+
+	if d.DraftID == 0 {
+		var publishAt interface{}
+		if d.PublishAt != nil {
+			publishAt = d.PublishAt.UTC()
+		}
+		result, err := DB.Exec(`
+			INSERT INTO post_drafts (user_id, title, content, categories, tags, images, poll, publish_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			d.UserID, d.Title, d.Content, categories, tags, images, poll, publishAt,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("insert draft: %w", err)
+		}
+		id, err := result.LastInsertId()
+		return int(id), err
+	}
+
+	result, err := DB.Exec(`
+		UPDATE post_drafts
+		SET title = ?, content = ?, categories = ?, tags = ?, images = COALESCE(?, images), poll = ?,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE draft_id = ? AND user_id = ?`,
+		d.Title, d.Content, categories, tags, images, poll, d.DraftID, d.UserID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("update draft: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, ErrDraftNotFound
+	}
+	return d.DraftID, nil
+}
+
+// GetDraft loads one of userID's drafts.
+func GetDraft(draftID, userID int) (models.PostDraft, error) {
+	d, err := scanDraft(DB.QueryRow(
+		`SELECT `+draftColumns+` FROM post_drafts WHERE draft_id = ? AND user_id = ?`,
+		draftID, userID,
+	))
+	if err == sql.ErrNoRows {
+		return d, ErrDraftNotFound
+	}
+	return d, err
+}
+
+// ListDrafts returns userID's drafts, most recently edited first.
+func ListDrafts(userID int) ([]models.PostDraft, error) {
+	return queryDrafts(
+		`SELECT `+draftColumns+` FROM post_drafts WHERE user_id = ? ORDER BY updated_at DESC, draft_id DESC`,
+		userID,
+	)
+}
+
+// DueDrafts returns the scheduled drafts whose publish time is at or before
+// now, oldest first.
+func DueDrafts(now time.Time) ([]models.PostDraft, error) {
+	return queryDrafts(
+		`SELECT `+draftColumns+` FROM post_drafts
+		 WHERE publish_at IS NOT NULL AND publish_at <= ?
+		 ORDER BY publish_at, draft_id`,
+		now.UTC(),
+	)
+}
+
+// DeleteDraft removes one of userID's drafts.
+func DeleteDraft(draftID, userID int) error {
+	result, err := DB.Exec(`DELETE FROM post_drafts WHERE draft_id = ? AND user_id = ?`, draftID, userID)
+	if err != nil {
+		return fmt.Errorf("delete draft: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrDraftNotFound
+	}
+	return nil
+}
+
+// ScheduleDraft sets the time a draft is published at. A nil publishAt turns
+// it back into a plain draft. Any earlier publishing error is cleared.
+func ScheduleDraft(draftID, userID int, publishAt *time.Time) error {
+	var at interface{}
+	if publishAt != nil {
+		at = publishAt.UTC()
+	}
+	result, err := DB.Exec(`
+		UPDATE post_drafts
+		SET publish_at = ?, publish_error = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE draft_id = ? AND user_id = ?`,
+		at, draftID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("schedule draft: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrDraftNotFound
+	}
+	return nil
+}
+
+// MarkDraftFailed unschedules a draft that could not be published and records
+// why, so its author can fix it and schedule it again.
+func MarkDraftFailed(draftID int, reason string) error {
+	_, err := DB.Exec(
+		`UPDATE post_drafts SET publish_at = NULL, publish_error = ? WHERE draft_id = ?`,
+		reason, draftID,
+	)
+	return err
+}
+
+// PublishDraft creates the post p from a draft and deletes the draft in the
+// same transaction. It returns ErrDraftNotFound if the draft was already
+// published or deleted, so a draft never yields two posts.
+func PublishDraft(draftID int, p models.NewPost) (postID, pollID int64, err error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM post_drafts WHERE draft_id = ? AND user_id = ?`, draftID, p.UserID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("delete draft: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, 0, ErrDraftNotFound
+	}
+
+	postID, pollID, err = createPost(tx, p)
+	if err != nil {
+		return 0, 0, err
+	}
+	return postID, pollID, tx.Commit()
+}
+
+func queryDrafts(query string, args ...interface{}) ([]models.PostDraft, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("load drafts: %w", err)
+	}
+	defer rows.Close()
+
+	drafts := []models.PostDraft{}
+	for rows.Next() {
+		d, err := scanDraft(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan draft: %w", err)
+		}
+		drafts = append(drafts, d)
+	}
+	return drafts, rows.Err()
+}
+
+func scanDraft(row interface{ Scan(...interface{}) error }) (models.PostDraft, error) {
+	var d models.PostDraft
+	var categories, tags string
+	var images, poll, publishError sql.NullString
+	var publishAt sql.NullTime
+	err := row.Scan(
+		&d.DraftID, &d.UserID, &d.Title, &d.Content, &categories, &tags, &images, &poll,
+		&publishAt, &publishError, &d.CreatedAt, &d.UpdatedAt,
+	)
+	if err != nil {
+		return d, err
+	}
+
+	d.CategoryIDs = splitCategoryIDs(categories)
+	d.Tags = splitTags(tags)
+	d.Images = []models.PostImage{}
+	if images.Valid && images.String != "" {
+		if err := json.Unmarshal([]byte(images.String), &d.Images); err != nil {
+			return d, fmt.Errorf("decode draft images: %w", err)
+		}
+	}
+	if poll.Valid && poll.String != "" {
+		d.Poll = []byte(poll.String)
+	}
+	d.PublishError = publishError.String
+	d.Status = models.DraftStatusDraft
+	if publishAt.Valid {
+		t := publishAt.Time
+		d.PublishAt = &t
+		d.Status = models.DraftStatusScheduled
+	}
+	return d, nil
+}
+
+func joinCategoryIDs(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+func splitCategoryIDs(s string) []int {
+	ids := []int{}
+	for _, part := range strings.Split(s, ",") {
+		if id, err := strconv.Atoi(part); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"real/models"
+)
+
+var (
+	ErrFollowSelf   = errors.New("you cannot follow yourself")
+	ErrFollowTarget = errors.New("follow target not found")
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// FollowUser makes followerID follow followedID. Following twice is a no-op.
+func FollowUser(followerID, followedID int) error {
+	if followerID == followedID {
+		return ErrFollowSelf
+	}
+	var exists bool
+	if err := DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE user_id = ?)`, followedID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrFollowTarget
+	}
+	_, err := DB.Exec(
+		`INSERT OR IGNORE INTO user_follows (follower_id, followed_id) VALUES (?, ?)`,
+		followerID, followedID,
+	)
+	return err
+}
+
+// UnfollowUser stops followerID following followedID.
+func UnfollowUser(followerID, followedID int) error {
+	_, err := DB.Exec(`DELETE FROM user_follows WHERE follower_id = ? AND followed_id = ?`, followerID, followedID)
+	return err
+}
+
+// FollowCategory adds a category to userID's feed. Following twice is a no-op.
+func FollowCategory(userID, categoryID int) error {
+	var exists bool
+	if err := DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM categories WHERE category_id = ?)`, categoryID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrFollowTarget
+	}
+	_, err := DB.Exec(
+		`INSERT OR IGNORE INTO category_follows (user_id, category_id) VALUES (?, ?)`,
+		userID, categoryID,
+	)
+	return err
+}
+
+// UnfollowCategory removes a category from userID's feed.
+func UnfollowCategory(userID, categoryID int) error {
+	_, err := DB.Exec(`DELETE FROM category_follows WHERE user_id = ? AND category_id = ?`, userID, categoryID)
+	return err
+}
+
+// GetFollowers lists the users following userID, most recent first.
+func GetFollowers(userID int) ([]models.FollowedUser, error) {
+	return queryFollowedUsers(`
+		SELECT u.user_id, u.username, f.created_at
+		FROM user_follows f
+		JOIN users u ON u.user_id = f.follower_id
+		WHERE f.followed_id = ?
+		ORDER BY f.created_at DESC`, userID)
+}
+
+// GetFollowedUsers lists the users userID follows, most recent first.
+func GetFollowedUsers(userID int) ([]models.FollowedUser, error) {
+	return queryFollowedUsers(`
+		SELECT u.user_id, u.username, f.created_at
+		FROM user_follows f
+		JOIN users u ON u.user_id = f.followed_id
+		WHERE f.follower_id = ?
+		ORDER BY f.created_at DESC`, userID)
+}
+
+// GetFollowedCategories lists the categories userID follows.
+func GetFollowedCategories(userID int) ([]models.Category, error) {
+	rows, err := DB.Query(`
+		SELECT c.category_id, c.name, COALESCE(c.description, '')
+		FROM category_follows f
+		JOIN categories c ON c.category_id = f.category_id
+		WHERE f.user_id = ?
+		ORDER BY c.name`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("load followed categories: %w", err)
+	}
+	defer rows.Close()
+
+	categories := []models.Category{}
+	for rows.Next() {
+		var c models.Category
+		if err := rows.Scan(&c.CategoryID, &c.Name, &c.Description); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+func queryFollowedUsers(query string, userID int) ([]models.FollowedUser, error) {
+	rows, err := DB.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("load follows: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.FollowedUser{}
+	for rows.Next() {
+		var u models.FollowedUser
+		if err := rows.Scan(&u.UserID, &u.Username, &u.FollowedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// GetUserProfile returns a user's public profile with their follower and
+// following counts. IsFollowing is set when viewerID follows them.
+func GetUserProfile(userID, viewerID int) (models.UserProfile, error) {
+	var p models.UserProfile
+	err := DB.QueryRow(`
+		SELECT u.user_id, u.username, COALESCE(u.bio, ''), COALESCE(u.profile_picture, ''), u.created_at,
+		       (SELECT COUNT(*) FROM posts WHERE user_id = u.user_id),
+		       (SELECT COUNT(*) FROM user_follows WHERE followed_id = u.user_id),
+		       (SELECT COUNT(*) FROM user_follows WHERE follower_id = u.user_id),
+		       EXISTS (SELECT 1 FROM user_follows WHERE follower_id = ? AND followed_id = u.user_id)
+		FROM users u
+		WHERE u.user_id = ?`, viewerID, userID,
+	).Scan(&p.UserID, &p.Username, &p.Bio, &p.ProfilePicture, &p.CreatedAt,
+		&p.PostCount, &p.FollowerCount, &p.FollowingCount, &p.IsFollowing)
+	if err == sql.ErrNoRows {
+		return p, ErrUserNotFound
+	}
+	return p, err
+}
+
+// GetUserIDByUsername looks a user up by name.
+func GetUserIDByUsername(username string) (int, error) {
+	var userID int
+	err := DB.QueryRow(`SELECT user_id FROM users WHERE username = ?`, username).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrUserNotFound
+	}
+	return userID, err
+}
@@ -0,0 +1,222 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"real/models"
+)
+
+// SaveImage records a processed upload and its variants, returning its ID.
+// When an image with the same content hash was recorded in the meantime (by
+// a concurrent upload of the same file) that one is kept and its ID returned.
+func SaveImage(img models.Image) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID, contentHash interface{}
+	if img.UserID != 0 {
+		userID = img.UserID
+	}
+	if img.ContentHash != "" {
+		contentHash = img.ContentHash
+	}
+	result, err := tx.Exec(`
+		INSERT INTO images (user_id, url, content_hash, content_type, width, height, size_bytes, last_used_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT DO NOTHING`,
+		userID, img.URL, contentHash, img.ContentType, img.Width, img.Height, img.SizeBytes,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("insert image: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var imageID int
+		err := tx.QueryRow(`SELECT image_id FROM images WHERE url = ? OR content_hash = ?`, img.URL, contentHash).Scan(&imageID)
+		return imageID, err
+	}
+	imageID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, v := range img.Variants {
+		if _, err := tx.Exec(
+			`INSERT INTO image_variants (image_id, variant, url, width, height) VALUES (?, ?, ?, ?, ?)`,
+			imageID, v.Name, v.URL, v.Width, v.Height,
+		); err != nil {
+			return 0, fmt.Errorf("insert image variant: %w", err)
+		}
+	}
+	return int(imageID), tx.Commit()
+}
+
+// ReuseImage returns the key of the image already stored for contentHash, or
+// "" when there is none. Reusing an image marks it as used, so the cleanup
+// job leaves it alone until the upload is attached to a post.
+func ReuseImage(contentHash string) (string, error) {
+	result, err := DB.Exec(`UPDATE images SET last_used_at = CURRENT_TIMESTAMP WHERE content_hash = ?`, contentHash)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", nil
+	}
+	var url string
+	err = DB.QueryRow(`SELECT url FROM images WHERE content_hash = ?`, contentHash).Scan(&url)
+	return url, err
+}
+
+// GetImageVariants returns the variants of the images with the given URLs,
+// smallest first, keyed by image URL. Images uploaded before processing was
+// introduced have no entry.
+func GetImageVariants(urls []string) (map[string][]models.ImageVariant, error) {
+	variants := make(map[string][]models.ImageVariant)
+	if len(urls) == 0 {
+		return variants, nil
+	}
+
+	args := make([]interface{}, len(urls))
+	placeholders := ""
+	for i, url := range urls {
+		if i > 0 {
+			placeholders += ", "
+		}
+		placeholders += "?"
+		args[i] = url
+	}
+
+	rows, err := DB.Query(`
+		SELECT i.url, v.variant, v.url, v.width, v.height
+		FROM images i
+		JOIN image_variants v ON v.image_id = i.image_id
+		WHERE i.url IN (`+placeholders+`)
+		ORDER BY i.image_id, v.width`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("load image variants: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var imageURL string
+		var v models.ImageVariant
+		if err := rows.Scan(&imageURL, &v.Name, &v.URL, &v.Width, &v.Height); err != nil {
+			return nil, fmt.Errorf("scan image variant: %w", err)
+		}
+		variants[imageURL] = append(variants[imageURL], v)
+	}
+	return variants, rows.Err()
+}
+
+// ImageRecorded reports whether url is a processed image.
+func ImageRecorded(url string) (bool, error) {
+	var exists bool
+	err := DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM images WHERE url = ?)`, url).Scan(&exists)
+	return exists, err
+}
+
+// orphanCondition matches images nothing uses: no gallery or draft, and no
+// private message linking to any of their sizes. Its placeholder takes the
+// cutoff as a Unix time; images used after it are left alone, which covers
+// uploads that are not attached to their post yet.
+const orphanCondition = `
+	i.ref_count <= 0
+	AND i.last_used_at < datetime(?, 'unixepoch')
+	AND NOT EXISTS (
+		SELECT 1 FROM image_variants v
+		JOIN private_messages m ON instr(m.content, v.url) > 0
+		WHERE v.image_id = i.image_id)`
+
+// OrphanImages returns the images nothing has used since cutoff, with their
+// variants.
+func OrphanImages(cutoff time.Time) ([]models.Image, error) {
+	rows, err := DB.Query(`
+		SELECT i.image_id, i.url, v.variant, v.url
+		FROM images i
+		LEFT JOIN image_variants v ON v.image_id = i.image_id
+		WHERE `+orphanCondition+`
+		ORDER BY i.image_id`, cutoff.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("load orphan images: %w", err)
+	}
+	defer rows.Close()
+
+	var images []models.Image
+	for rows.Next() {
+		var imageID int
+		var url string
+		var variant, variantURL sql.NullString
+		if err := rows.Scan(&imageID, &url, &variant, &variantURL); err != nil {
+			return nil, fmt.Errorf("scan orphan image: %w", err)
+		}
+		if len(images) == 0 || images[len(images)-1].ImageID != imageID {
+			images = append(images, models.Image{ImageID: imageID, URL: url})
+		}
+		if variant.Valid {
+			last := &images[len(images)-1]
+			last.Variants = append(last.Variants, models.ImageVariant{Name: variant.String, URL: variantURL.String})
+		}
+	}
+	return images, rows.Err()
+}
+
+// DeleteOrphanImage removes img's records and then, through removeFiles, its
+// stored files, provided it is still unused since cutoff. The records are
+// committed first so the database is not locked while files are removed;
+// files that fail to be removed are no longer referenced and are picked up
+// by the stray-file sweep (see MediaReferenced). It reports whether img's
+// records were deleted.
+func DeleteOrphanImage(img models.Image, cutoff time.Time, removeFiles func(keys []string) error) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM images WHERE image_id = ? AND image_id IN (
+		SELECT i.image_id FROM images i WHERE `+orphanCondition+`)`, img.ImageID, cutoff.Unix())
+	if err != nil {
+		return false, fmt.Errorf("delete image: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec(`DELETE FROM image_variants WHERE image_id = ?`, img.ImageID); err != nil {
+		return false, fmt.Errorf("delete image variants: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	keys := []string{img.URL}
+	for _, v := range img.Variants {
+		if v.URL != img.URL {
+			keys = append(keys, v.URL)
+		}
+	}
+	return true, removeFiles(keys)
+}
+
+// MediaReferenced reports whether anything points at the stored file key:
+// an image record, a post or draft, or a private message linking to it.
+// Files that are not referenced are left over from failed uploads or from
+// before images were recorded.
+func MediaReferenced(key string) (bool, error) {
+	var referenced bool
+	err := DB.QueryRow(`SELECT
+		EXISTS (SELECT 1 FROM images WHERE url = ?1)
+		OR EXISTS (SELECT 1 FROM image_variants WHERE url = ?1)
+		OR EXISTS (SELECT 1 FROM post_images WHERE image_url = ?1)
+		OR EXISTS (SELECT 1 FROM posts WHERE imgurl = ?1)
+		OR EXISTS (SELECT 1 FROM post_drafts d WHERE EXISTS (
+			SELECT 1 FROM json_each(d.images) WHERE json_extract(value, '$.url') = ?1))
+		OR EXISTS (SELECT 1 FROM private_messages WHERE instr(content, ?1) > 0)`,
+		key,
+	).Scan(&referenced)
+	return referenced, err
+}
@@ -0,0 +1,463 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// migration is a one-off schema or data change applied on top of schema.sql.
+// schema.sql only uses CREATE TABLE IF NOT EXISTS, so databases created by an
+// older version never pick up new columns or indexes without these.
+type migration struct {
+	name string
+	up   func(tx *sql.Tx) error
+}
+
+// migrations are applied in order and recorded in schema_migrations so each
+// one runs exactly once per database.
+var migrations = []migration{
+	{"0001_threaded_comments", migrateThreadedComments},
+	{"0002_comment_moderation", migrateCommentModeration},
+	{"0003_unified_reactions", migrateUnifiedReactions},
+	{"0004_reaction_types", migrateReactionTypes},
+	{"0005_post_images", migratePostImages},
+	{"0006_media_keys", migrateMediaKeys},
+	{"0007_image_refcounts", migrateImageRefcounts},
+	{"0008_category_admin", migrateCategoryAdmin},
+	{"0009_draft_tags", migrateDraftTags},
+	{"0010_post_moderation", migratePostModeration},
+	{"0011_session_devices", migrateSessionDevices},
+	{"0012_session_expiry", migrateSessionExpiry},
+	{"0013_session_csrf", migrateSessionCSRF},
+	{"0014_email_verification", migrateEmailVerification},
+	{"0015_user_role_check", migrateUserRoleCheck},
+}
+
+func migrate() error {
+	if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		name TEXT PRIMARY KEY,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	for _, m := range migrations {
+		var applied bool
+		err := DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE name = ?)`, m.name).Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("failed to check migration %s: %v", m.name, err)
+		}
+		if applied {
+			continue
+		}
+
+		tx, err := DB.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %s: %v", m.name, err)
+		}
+		if err := m.up(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s failed: %v", m.name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (name) VALUES (?)`, m.name); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %s: %v", m.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %s: %v", m.name, err)
+		}
+		log.Printf("Applied migration %s", m.name)
+	}
+
+	return nil
+}
+
+// columnExists reports whether table already has the named column.
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// tableExists reports whether the database has a table with the given name.
+func tableExists(tx *sql.Tx, table string) (bool, error) {
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)`, table).Scan(&exists)
+	return exists, err
+}
+
+// addColumn adds column to table unless schema.sql already created it.
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	exists, err := columnExists(tx, table, column)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
+
+func migrateThreadedComments(tx *sql.Tx) error {
+	if err := addColumn(tx, "comments", "parent_comment_id", "INTEGER REFERENCES comments(comment_id) ON DELETE CASCADE"); err != nil {
+		return err
+	}
+	if err := addColumn(tx, "comments", "depth", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_comments_post_parent ON comments(post_id, parent_comment_id)`)
+	return err
+}
+
+func migrateCommentModeration(tx *sql.Tx) error {
+	columns := []struct{ table, column, definition string }{
+		{"users", "role", "TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin'))"},
+		{"comments", "edited_at", "DATETIME"},
+		{"comments", "deleted_at", "DATETIME"},
+		{"comments", "deleted_by", "INTEGER REFERENCES users(user_id)"},
+	}
+	for _, c := range columns {
+		if err := addColumn(tx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateUnifiedReactions moves post reactions from likes and comment
+// reactions from comment_reactions into the reactions table, then drops the
+// old tables. comment_reactions is copied first because it is what the
+// comment endpoints actually used; likes never had a uniqueness constraint,
+// so when a user has several rows for the same target the most recent wins.
+func migrateUnifiedReactions(tx *sql.Tx) error {
+	copies := []struct{ table, query string }{
+		{"comment_reactions", `
+			INSERT OR IGNORE INTO reactions (user_id, target_type, target_id, reaction_type, created_at)
+			SELECT user_id, 'comment', comment_id, reaction_type, created_at
+			FROM comment_reactions`},
+		{"likes", `
+			INSERT OR IGNORE INTO reactions (user_id, target_type, target_id, reaction_type, created_at)
+			SELECT user_id,
+			       CASE WHEN post_id IS NOT NULL THEN 'post' ELSE 'comment' END,
+			       COALESCE(post_id, comment_id),
+			       like_type,
+			       created_at
+			FROM likes
+			ORDER BY created_at DESC, like_id DESC`},
+	}
+
+	for _, c := range copies {
+		exists, err := tableExists(tx, c.table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if _, err := tx.Exec(c.query); err != nil {
+			return fmt.Errorf("copy %s: %v", c.table, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`DROP TABLE %s`, c.table)); err != nil {
+			return fmt.Errorf("drop %s: %v", c.table, err)
+		}
+	}
+	return nil
+}
+
+// migrateReactionTypes seeds the reaction set. Like and dislike stay the
+// default so existing categories behave as before; the rest are available
+// for admins to enable per category.
+func migrateReactionTypes(tx *sql.Tx) error {
+	types := []struct {
+		code, emoji, label string
+		isDefault          bool
+	}{
+		{"like", "👍", "Like", true},
+		{"dislike", "👎", "Dislike", true},
+		{"love", "❤️", "Love", false},
+		{"laugh", "😂", "Laugh", false},
+		{"celebrate", "🎉", "Celebrate", false},
+		{"thinking", "🤔", "Thinking", false},
+	}
+	for i, t := range types {
+		if _, err := tx.Exec(
+			`INSERT OR IGNORE INTO reaction_types (code, emoji, label, sort_order, is_default) VALUES (?, ?, ?, ?, ?)`,
+			t.code, t.emoji, t.label, i, t.isDefault,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migratePostImages turns the single image of existing posts and drafts into
+// the first entry of their gallery.
+func migratePostImages(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		INSERT INTO post_images (post_id, image_url, position)
+		SELECT post_id, imgurl, 0 FROM posts
+		WHERE imgurl IS NOT NULL AND imgurl != ''
+		AND post_id NOT IN (SELECT post_id FROM post_images)`); err != nil {
+		return err
+	}
+
+	if err := addColumn(tx, "post_drafts", "images", "TEXT"); err != nil {
+		return err
+	}
+	hasImgURL, err := columnExists(tx, "post_drafts", "imgurl")
+	if err != nil || !hasImgURL {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE post_drafts SET images = json_array(json_object('url', imgurl))
+		WHERE imgurl IS NOT NULL AND imgurl != '' AND images IS NULL`)
+	return err
+}
+
+// legacyMediaPrefix is how uploads were referenced before they were stored by
+// key: the path the static file server exposed them under.
+const legacyMediaPrefix = "/static/images/"
+
+// migrateMediaKeys turns stored upload URLs into media storage keys, e.g.
+// "/static/images/posts/a.jpg" into "posts/a.jpg".
+func migrateMediaKeys(tx *sql.Tx) error {
+	columns := [][2]string{
+		{"posts", "imgurl"},
+		{"images", "url"},
+		{"image_variants", "url"},
+		{"post_images", "image_url"},
+	}
+	for _, c := range columns {
+		if _, err := tx.Exec(fmt.Sprintf(
+			`UPDATE %[1]s SET %[2]s = substr(%[2]s, ?) WHERE %[2]s LIKE ?`, c[0], c[1]),
+			len(legacyMediaPrefix)+1, legacyMediaPrefix+"%",
+		); err != nil {
+			return err
+		}
+	}
+
+	// Drafts keep their images as JSON objects with a "url" field
+	_, err := tx.Exec(
+		`UPDATE post_drafts SET images = replace(images, ?, '"') WHERE images LIKE ?`,
+		`"`+legacyMediaPrefix, `%"`+legacyMediaPrefix+`%`,
+	)
+	return err
+}
+
+// imageRefTriggers keep images.ref_count equal to the number of gallery
+// entries plus the number of drafts using each image.
+var imageRefTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS post_images_ref_insert AFTER INSERT ON post_images BEGIN
+		UPDATE images SET ref_count = ref_count + 1 WHERE url = NEW.image_url;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS post_images_ref_delete AFTER DELETE ON post_images BEGIN
+		UPDATE images SET ref_count = ref_count - 1 WHERE url = OLD.image_url;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS post_images_ref_update AFTER UPDATE OF image_url ON post_images BEGIN
+		UPDATE images SET ref_count = ref_count - 1 WHERE url = OLD.image_url;
+		UPDATE images SET ref_count = ref_count + 1 WHERE url = NEW.image_url;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS post_drafts_ref_insert AFTER INSERT ON post_drafts BEGIN
+		UPDATE images SET ref_count = ref_count + 1
+		WHERE url IN (SELECT json_extract(value, '$.url') FROM json_each(NEW.images));
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS post_drafts_ref_delete AFTER DELETE ON post_drafts BEGIN
+		UPDATE images SET ref_count = ref_count - 1
+		WHERE url IN (SELECT json_extract(value, '$.url') FROM json_each(OLD.images));
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS post_drafts_ref_update AFTER UPDATE OF images ON post_drafts BEGIN
+		UPDATE images SET ref_count = ref_count - 1
+		WHERE url IN (SELECT json_extract(value, '$.url') FROM json_each(OLD.images));
+		UPDATE images SET ref_count = ref_count + 1
+		WHERE url IN (SELECT json_extract(value, '$.url') FROM json_each(NEW.images));
+	END`,
+}
+
+// migrateImageRefcounts adds content hashes and reference counts to images.
+// Images uploaded before this have no hash, since the original files are
+// gone, so they are not deduplicated; their counts are computed from the
+// galleries and drafts that use them.
+func migrateImageRefcounts(tx *sql.Tx) error {
+	columns := []struct{ column, definition string }{
+		{"content_hash", "TEXT"},
+		{"ref_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"last_used_at", "DATETIME"},
+	}
+	for _, c := range columns {
+		if err := addColumn(tx, "images", c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_images_content_hash ON images(content_hash)`); err != nil {
+		return err
+	}
+	for _, trigger := range imageRefTriggers {
+		if _, err := tx.Exec(trigger); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(`
+		UPDATE images SET
+			last_used_at = COALESCE(last_used_at, created_at),
+			ref_count = (SELECT COUNT(*) FROM post_images WHERE image_url = images.url)
+				+ (SELECT COUNT(*) FROM post_drafts d WHERE EXISTS (
+					SELECT 1 FROM json_each(d.images) WHERE json_extract(value, '$.url') = images.url))`)
+	return err
+}
+
+// defaultCategories are created once, with the database. Admins manage them
+// afterwards.
+var defaultCategories = []struct{ name, description string }{
+	{"Technology", "Posts related to the latest technology and trends"},
+	{"Health", "Discussions about health, fitness, and well-being"},
+	{"Education", "Topics about learning and education"},
+	{"Entertainment", "Movies, music, games, and all things fun"},
+	{"Lifestyle", "Fashion, home decor, and daily living tips"},
+	{"Travel", "Exploring the world, sharing travel experiences"},
+}
+
+// migrateCategoryAdmin adds the columns admins manage and seeds the default
+// categories, which used to be re-inserted on every start. Existing
+// categories keep their order of creation.
+func migrateCategoryAdmin(tx *sql.Tx) error {
+	columns := []struct{ column, definition string }{
+		{"parent_id", "INTEGER REFERENCES categories(category_id)"},
+		{"color", "TEXT NOT NULL DEFAULT ''"},
+		{"icon", "TEXT NOT NULL DEFAULT ''"},
+		{"position", "INTEGER NOT NULL DEFAULT 0"},
+		{"archived", "BOOLEAN NOT NULL DEFAULT 0"},
+	}
+	for _, c := range columns {
+		if err := addColumn(tx, "categories", c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_categories_parent ON categories(parent_id, position)`); err != nil {
+		return err
+	}
+
+	for _, c := range defaultCategories {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO categories (name, description) VALUES (?, ?)`, c.name, c.description); err != nil {
+			return fmt.Errorf("insert category %q: %v", c.name, err)
+		}
+	}
+	_, err := tx.Exec(`UPDATE categories SET position = category_id WHERE position = 0`)
+	return err
+}
+
+func migrateDraftTags(tx *sql.Tx) error {
+	return addColumn(tx, "post_drafts", "tags", "TEXT NOT NULL DEFAULT ''")
+}
+
+func migratePostModeration(tx *sql.Tx) error {
+	columns := []struct{ column, definition string }{
+		{"locked_at", "DATETIME"},
+		{"locked_by", "INTEGER"},
+		{"announced_at", "DATETIME"},
+	}
+	for _, c := range columns {
+		if err := addColumn(tx, "posts", c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_posts_announced ON posts(announced_at)`)
+	return err
+}
+
+func migrateSessionDevices(tx *sql.Tx) error {
+	columns := []struct{ column, definition string }{
+		{"created_at", "DATETIME"},
+		{"last_used_at", "DATETIME"},
+		{"user_agent", "TEXT NOT NULL DEFAULT ''"},
+		{"ip_address", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, c := range columns {
+		if err := addColumn(tx, "sessions", c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id)`)
+	return err
+}
+
+func migrateSessionExpiry(tx *sql.Tx) error {
+	columns := []struct{ column, definition string }{
+		{"absolute_expires_at", "DATETIME"},
+		{"remember", "INTEGER NOT NULL DEFAULT 0"},
+		{"role", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, c := range columns {
+		if err := addColumn(tx, "sessions", c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	// Existing sessions keep their fixed expiry, and are taken to have been
+	// issued for the role their user has now so they are not all rotated
+	_, err := tx.Exec(`
+		UPDATE sessions SET
+			absolute_expires_at = COALESCE(absolute_expires_at, expires_at),
+			role = COALESCE((SELECT role FROM users WHERE users.user_id = sessions.user_id), '')
+		WHERE role = ''`)
+	return err
+}
+
+// Existing sessions are given a CSRF token the next time they are used.
+func migrateSessionCSRF(tx *sql.Tx) error {
+	return addColumn(tx, "sessions", "csrf_token", "TEXT NOT NULL DEFAULT ''")
+}
+
+// Existing users start out unverified, and can ask for a verification email.
+func migrateEmailVerification(tx *sql.Tx) error {
+	return addColumn(tx, "users", "email_verified_at", "DATETIME")
+}
+
+// userRoleTriggers refuse unknown roles. Databases that got users.role from
+// migrateCommentModeration before it declared the CHECK of schema.sql cannot
+// have it added without rebuilding the table, so it is enforced with these.
+var userRoleTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS users_role_check_insert BEFORE INSERT ON users
+	WHEN NEW.role NOT IN ('user', 'moderator', 'admin') BEGIN
+		SELECT RAISE(ABORT, 'CHECK constraint failed: role');
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS users_role_check_update BEFORE UPDATE OF role ON users
+	WHEN NEW.role NOT IN ('user', 'moderator', 'admin') BEGIN
+		SELECT RAISE(ABORT, 'CHECK constraint failed: role');
+	END`,
+}
+
+// migrateUserRoleCheck resets roles that are not valid to 'user' and then
+// refuses them from now on.
+func migrateUserRoleCheck(tx *sql.Tx) error {
+	if _, err := tx.Exec(`UPDATE users SET role = 'user' WHERE role NOT IN ('user', 'moderator', 'admin')`); err != nil {
+		return err
+	}
+	for _, trigger := range userRoleTriggers {
+		if _, err := tx.Exec(trigger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"real/models"
+)
+
+var (
+	ErrPollNotFound     = errors.New("poll not found")
+	ErrPollClosed       = errors.New("poll is closed")
+	ErrPollInvalidVote  = errors.New("option does not belong to this poll")
+	ErrPollSingleChoice = errors.New("this poll only allows one choice")
+)
+
+// CreatePoll stores a poll for a post inside the post's transaction.
+func CreatePoll(tx *sql.Tx, postID int64, poll models.PollInput) (int64, error) {
+	var closesAt interface{}
+	if poll.ClosesAt != nil {
+		closesAt = poll.ClosesAt.UTC()
+	}
+
+	result, err := tx.Exec(
+		`INSERT INTO polls (post_id, question, multiple_choice, anonymous, closes_at) VALUES (?, ?, ?, ?, ?)`,
+		postID, poll.Question, poll.MultipleChoice, poll.Anonymous, closesAt,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("insert poll: %w", err)
+	}
+	pollID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, text := range poll.Options {
+		if _, err := tx.Exec(
+			`INSERT INTO poll_options (poll_id, text, position) VALUES (?, ?, ?)`,
+			pollID, text, i,
+		); err != nil {
+			return 0, fmt.Errorf("insert poll option: %w", err)
+		}
+	}
+	return pollID, nil
+}
+
+// GetPollIDForPost returns the poll attached to a post.
+func GetPollIDForPost(postID int) (int, error) {
+	var pollID int
+	err := DB.QueryRow(`SELECT poll_id FROM polls WHERE post_id = ?`, postID).Scan(&pollID)
+	if err == sql.ErrNoRows {
+		return 0, ErrPollNotFound
+	}
+	return pollID, err
+}
+
+// GetPoll loads a poll with its current results. viewerID's own votes are
+// included when viewerID is non-zero.
+func GetPoll(pollID, viewerID int) (models.Poll, error) {
+	var poll models.Poll
+	var closesAt sql.NullTime
+	err := DB.QueryRow(`
+		SELECT poll_id, post_id, question, multiple_choice, anonymous, closes_at
+		FROM polls WHERE poll_id = ?`, pollID,
+	).Scan(&poll.PollID, &poll.PostID, &poll.Question, &poll.MultipleChoice, &poll.Anonymous, &closesAt)
+	if err == sql.ErrNoRows {
+		return poll, ErrPollNotFound
+	} else if err != nil {
+		return poll, fmt.Errorf("load poll: %w", err)
+	}
+	if closesAt.Valid {
+		t := closesAt.Time
+		poll.ClosesAt = &t
+		poll.Closed = !time.Now().Before(t)
+	}
+
+	rows, err := DB.Query(`
+		SELECT o.option_id, o.text, COUNT(v.user_id)
+		FROM poll_options o
+		LEFT JOIN poll_votes v ON v.option_id = o.option_id
+		WHERE o.poll_id = ?
+		GROUP BY o.option_id
+		ORDER BY o.position`, pollID)
+	if err != nil {
+		return poll, fmt.Errorf("load poll options: %w", err)
+	}
+	defer rows.Close()
+
+	index := make(map[int]int)
+	poll.Options = []models.PollOption{}
+	for rows.Next() {
+		var opt models.PollOption
+		if err := rows.Scan(&opt.OptionID, &opt.Text, &opt.Votes); err != nil {
+			return poll, fmt.Errorf("scan poll option: %w", err)
+		}
+		index[opt.OptionID] = len(poll.Options)
+		poll.Options = append(poll.Options, opt)
+	}
+	if err := rows.Err(); err != nil {
+		return poll, err
+	}
+	rows.Close()
+
+	if err := DB.QueryRow(
+		`SELECT COUNT(DISTINCT user_id) FROM poll_votes WHERE poll_id = ?`, pollID,
+	).Scan(&poll.TotalVoters); err != nil {
+		return poll, fmt.Errorf("count voters: %w", err)
+	}
+
+	if !poll.Anonymous {
+		voters, err := DB.Query(`
+			SELECT v.option_id, u.username
+			FROM poll_votes v
+			JOIN users u ON u.user_id = v.user_id
+			WHERE v.poll_id = ?
+			ORDER BY v.created_at`, pollID)
+		if err != nil {
+			return poll, fmt.Errorf("load voters: %w", err)
+		}
+		defer voters.Close()
+		for voters.Next() {
+			var optionID int
+			var username string
+			if err := voters.Scan(&optionID, &username); err != nil {
+				return poll, fmt.Errorf("scan voter: %w", err)
+			}
+			if i, ok := index[optionID]; ok {
+				poll.Options[i].Voters = append(poll.Options[i].Voters, username)
+			}
+		}
+		if err := voters.Err(); err != nil {
+			return poll, err
+		}
+	}
+
+	if viewerID != 0 {
+		mine, err := DB.Query(`SELECT option_id FROM poll_votes WHERE poll_id = ? AND user_id = ?`, pollID, viewerID)
+		if err != nil {
+			return poll, fmt.Errorf("load votes: %w", err)
+		}
+		defer mine.Close()
+		for mine.Next() {
+			var optionID int
+			if err := mine.Scan(&optionID); err != nil {
+				return poll, err
+			}
+			poll.MyVotes = append(poll.MyVotes, optionID)
+		}
+		if err := mine.Err(); err != nil {
+			return poll, err
+		}
+	}
+
+	return poll, nil
+}
+
+// VotePoll replaces userID's votes on a poll with optionIDs. An empty list
+// withdraws the vote.
+func VotePoll(pollID, userID int, optionIDs []int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var multipleChoice bool
+	var closesAt sql.NullTime
+	err = tx.QueryRow(
+		`SELECT multiple_choice, closes_at FROM polls WHERE poll_id = ?`, pollID,
+	).Scan(&multipleChoice, &closesAt)
+	if err == sql.ErrNoRows {
+		return ErrPollNotFound
+	} else if err != nil {
+		return fmt.Errorf("load poll: %w", err)
+	}
+	if closesAt.Valid && !time.Now().Before(closesAt.Time) {
+		return ErrPollClosed
+	}
+	if !multipleChoice && len(optionIDs) > 1 {
+		return ErrPollSingleChoice
+	}
+
+	if _, err := tx.Exec(`DELETE FROM poll_votes WHERE poll_id = ? AND user_id = ?`, pollID, userID); err != nil {
+		return fmt.Errorf("clear votes: %w", err)
+	}
+
+	for _, optionID := range optionIDs {
+		var belongs bool
+		if err := tx.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM poll_options WHERE option_id = ? AND poll_id = ?)`, optionID, pollID,
+		).Scan(&belongs); err != nil {
+			return err
+		}
+		if !belongs {
+			return ErrPollInvalidVote
+		}
+		if _, err := tx.Exec(
+			`INSERT OR IGNORE INTO poll_votes (poll_id, option_id, user_id) VALUES (?, ?, ?)`,
+			pollID, optionID, userID,
+		); err != nil {
+			return fmt.Errorf("insert vote: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"real/models"
+)
+
+// MaxPostImages is the largest gallery a post can have.
+const MaxPostImages = 10
+
+var (
+	ErrPostNotFound      = errors.New("post not found")
+	ErrPostImageNotFound = errors.New("image not found on this post")
+	ErrTooManyImages     = fmt.Errorf("a post can have at most %d images", MaxPostImages)
+)
+
+// GetPostAuthorID returns the user who wrote a post.
+func GetPostAuthorID(postID int) (int, error) {
+	var userID int
+	err := DB.QueryRow(`SELECT user_id FROM posts WHERE post_id = ?`, postID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrPostNotFound
+	}
+	return userID, err
+}
+
+// insertPostImages appends images to a post's gallery.
+func insertPostImages(tx *sql.Tx, postID int64, images []models.PostImage) error {
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM post_images WHERE post_id = ?`, postID).Scan(&count); err != nil {
+		return err
+	}
+	if count+len(images) > MaxPostImages {
+		return ErrTooManyImages
+	}
+
+	for _, img := range images {
+		if _, err := tx.Exec(`
+			INSERT INTO post_images (post_id, image_url, position, alt_text, caption)
+			VALUES (?, ?, (SELECT COALESCE(MAX(position), -1) + 1 FROM post_images WHERE post_id = ?), ?, ?)`,
+			postID, img.URL, postID, img.AltText, img.Caption,
+		); err != nil {
+			return fmt.Errorf("insert post image: %w", err)
+		}
+	}
+	return nil
+}
+
+// syncCoverImage points posts.imgurl at the first image of the gallery.
+func syncCoverImage(tx *sql.Tx, postID int) error {
+	_, err := tx.Exec(`
+		UPDATE posts SET imgurl = (
+			SELECT image_url FROM post_images WHERE post_id = ? ORDER BY position, post_image_id LIMIT 1
+		) WHERE post_id = ?`, postID, postID)
+	return err
+}
+
+// AddPostImages appends images to an existing post.
+func AddPostImages(postID int, images []models.PostImage) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertPostImages(tx, int64(postID), images); err != nil {
+		return err
+	}
+	if err := syncCoverImage(tx, postID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdatePostImage changes the alt text and caption of a gallery image.
+func UpdatePostImage(postID, postImageID int, altText, caption string) error {
+	result, err := DB.Exec(
+		`UPDATE post_images SET alt_text = ?, caption = ? WHERE post_image_id = ? AND post_id = ?`,
+		altText, caption, postImageID, postID,
+	)
+	if err != nil {
+		return fmt.Errorf("update post image: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrPostImageNotFound
+	}
+	return nil
+}
+
+// RemovePostImage takes an image out of a post's gallery.
+func RemovePostImage(postID, postImageID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM post_images WHERE post_image_id = ? AND post_id = ?`, postImageID, postID)
+	if err != nil {
+		return fmt.Errorf("delete post image: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrPostImageNotFound
+	}
+	if err := syncCoverImage(tx, postID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReorderPostImages puts a post's gallery in the given order. Images left
+// out keep their relative order after the listed ones.
+func ReorderPostImages(postID int, postImageIDs []int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := reorderTx(tx,
+		`SELECT post_image_id FROM post_images WHERE post_id = ? ORDER BY position, post_image_id`,
+		`UPDATE post_images SET position = ? WHERE post_image_id = ?`,
+		[]interface{}{postID}, postImageIDs, ErrPostImageNotFound,
+	); err != nil {
+		return err
+	}
+	if err := syncCoverImage(tx, postID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetPostImages returns the galleries of the given posts, in order, keyed by
+// post ID.
+func GetPostImages(postIDs []int) (map[int][]models.PostImage, error) {
+	images := make(map[int][]models.PostImage)
+	if len(postIDs) == 0 {
+		return images, nil
+	}
+
+	args := make([]interface{}, len(postIDs))
+	placeholders := ""
+	for i, id := range postIDs {
+		if i > 0 {
+			placeholders += ", "
+		}
+		placeholders += "?"
+		args[i] = id
+	}
+
+	rows, err := DB.Query(`
+		SELECT post_id, post_image_id, image_url, position, alt_text, caption
+		FROM post_images
+		WHERE post_id IN (`+placeholders+`)
+		ORDER BY post_id, position, post_image_id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("load post images: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID int
+		var img models.PostImage
+		if err := rows.Scan(&postID, &img.PostImageID, &img.URL, &img.Position, &img.AltText, &img.Caption); err != nil {
+			return nil, fmt.Errorf("scan post image: %w", err)
+		}
+		images[postID] = append(images[postID], img)
+	}
+	return images, rows.Err()
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"real/models"
+)
+
+var (
+	ErrPostLocked  = errors.New("this post is locked")
+	ErrPinCategory = errors.New("post is not in this category")
+)
+
+// IsPostLocked reports whether a post takes no new comments.
+func IsPostLocked(postID int) (bool, error) {
+	var locked bool
+	err := DB.QueryRow(`SELECT locked_at IS NOT NULL FROM posts WHERE post_id = ?`, postID).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return false, ErrPostNotFound
+	}
+	return locked, err
+}
+
+// SetPostLocked locks or unlocks a post. Locking an already locked post keeps
+// the original time and moderator.
+func SetPostLocked(postID int, locked bool, moderatorID int) error {
+	query := `UPDATE posts SET locked_at = NULL, locked_by = NULL WHERE post_id = ?`
+	args := []interface{}{postID}
+	if locked {
+		query = `UPDATE posts SET locked_at = COALESCE(locked_at, CURRENT_TIMESTAMP),
+			locked_by = COALESCE(locked_by, ?) WHERE post_id = ?`
+		args = []interface{}{moderatorID, postID}
+	}
+	result, err := DB.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("lock post: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrPostNotFound
+	}
+	return nil
+}
+
+// PinPost pins a post to the top of a category, or of the global list for
+// models.GlobalPin. The post must be in the category or one of its
+// subcategories, since those are the posts the category lists.
+func PinPost(postID, categoryID, moderatorID int) error {
+	if _, err := GetPostAuthorID(postID); err != nil {
+		return err
+	}
+	if categoryID != models.GlobalPin {
+		var inCategory bool
+		err := DB.QueryRow(`
+			WITH RECURSIVE subtree(category_id) AS (
+				SELECT category_id FROM categories WHERE category_id = ?
+				UNION
+				SELECT c.category_id FROM categories c JOIN subtree ON c.parent_id = subtree.category_id
+			)
+			SELECT EXISTS (SELECT 1 FROM post_categories
+				WHERE post_id = ? AND category_id IN (SELECT category_id FROM subtree))`,
+			categoryID, postID,
+		).Scan(&inCategory)
+		if err != nil {
+			return fmt.Errorf("check pin category: %w", err)
+		}
+		if !inCategory {
+			return ErrPinCategory
+		}
+	}
+
+	_, err := DB.Exec(`
+		INSERT INTO post_pins (post_id, category_id, pinned_by) VALUES (?, ?, ?)
+		ON CONFLICT (post_id, category_id) DO NOTHING`,
+		postID, categoryID, moderatorID,
+	)
+	if err != nil {
+		return fmt.Errorf("pin post: %w", err)
+	}
+	return nil
+}
+
+// UnpinPost removes a pin. Removing a pin that does not exist is not an error.
+func UnpinPost(postID, categoryID int) error {
+	if _, err := GetPostAuthorID(postID); err != nil {
+		return err
+	}
+	if _, err := DB.Exec(`DELETE FROM post_pins WHERE post_id = ? AND category_id = ?`, postID, categoryID); err != nil {
+		return fmt.Errorf("unpin post: %w", err)
+	}
+	return nil
+}
+
+// SetPostAnnouncement marks or unmarks a post as an announcement. It reports
+// whether the post just became one, which is when users should be notified.
+func SetPostAnnouncement(postID int, announce bool) (announced bool, err error) {
+	if _, err := GetPostAuthorID(postID); err != nil {
+		return false, err
+	}
+	if !announce {
+		if _, err := DB.Exec(`UPDATE posts SET announced_at = NULL WHERE post_id = ?`, postID); err != nil {
+			return false, fmt.Errorf("unannounce post: %w", err)
+		}
+		_, err := DB.Exec(`DELETE FROM announcement_reads WHERE post_id = ?`, postID)
+		return false, err
+	}
+
+	result, err := DB.Exec(
+		`UPDATE posts SET announced_at = CURRENT_TIMESTAMP WHERE post_id = ? AND announced_at IS NULL`,
+		postID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("announce post: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// GetAnnouncements returns the latest announcements, newest first, with
+// whether userID has dismissed each one. Only unread ones are returned when
+// unreadOnly is set.
+func GetAnnouncements(userID int, unreadOnly bool, limit int) ([]models.Announcement, error) {
+	query := `
+		SELECT p.post_id, p.user_id, u.username, p.title, p.announced_at,
+			EXISTS (SELECT 1 FROM announcement_reads WHERE user_id = ? AND post_id = p.post_id) AS read
+		FROM posts p
+		JOIN users u ON u.user_id = p.user_id
+		WHERE p.announced_at IS NOT NULL`
+	if unreadOnly {
+		query += ` AND NOT EXISTS (SELECT 1 FROM announcement_reads WHERE user_id = ? AND post_id = p.post_id)`
+	}
+	query += ` ORDER BY p.announced_at DESC, p.post_id DESC LIMIT ?`
+
+	args := []interface{}{userID}
+	if unreadOnly {
+		args = append(args, userID)
+	}
+	rows, err := DB.Query(query, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("load announcements: %w", err)
+	}
+	defer rows.Close()
+
+	announcements := []models.Announcement{}
+	for rows.Next() {
+		var a models.Announcement
+		if err := rows.Scan(&a.PostID, &a.UserID, &a.Username, &a.Title, &a.AnnouncedAt, &a.Read); err != nil {
+			return nil, fmt.Errorf("scan announcement: %w", err)
+		}
+		announcements = append(announcements, a)
+	}
+	return announcements, rows.Err()
+}
+
+// MarkAnnouncementRead dismisses an announcement for userID.
+func MarkAnnouncementRead(userID, postID int) error {
+	var announced bool
+	err := DB.QueryRow(`SELECT announced_at IS NOT NULL FROM posts WHERE post_id = ?`, postID).Scan(&announced)
+	if err == sql.ErrNoRows || (err == nil && !announced) {
+		return ErrPostNotFound
+	} else if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`INSERT OR IGNORE INTO announcement_reads (user_id, post_id) VALUES (?, ?)`, userID, postID)
+	return err
+}
+
+// CategoryIDByName looks up a category by its exact name.
+func CategoryIDByName(name string) (int, error) {
+	var id int
+	err := DB.QueryRow(`SELECT category_id FROM categories WHERE name = ?`, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrCategoryNotFound
+	}
+	return id, err
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"real/models"
+)
+
+// CreatePost inserts a post together with its images, categories, tags and
+// optional poll.
+func CreatePost(p models.NewPost) (postID, pollID int64, err error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	postID, pollID, err = createPost(tx, p)
+	if err != nil {
+		return 0, 0, err
+	}
+	return postID, pollID, tx.Commit()
+}
+
+func createPost(tx *sql.Tx, p models.NewPost) (postID, pollID int64, err error) {
+	var imgURL interface{}
+	if len(p.Images) > 0 {
+		imgURL = p.Images[0].URL
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO posts (user_id, title, content, imgurl) VALUES (?, ?, ?, ?)",
+		p.UserID, p.Title, p.Content, imgURL,
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("insert post: %w", err)
+	}
+	postID, err = result.LastInsertId()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := insertPostImages(tx, postID, p.Images); err != nil {
+		return 0, 0, err
+	}
+
+	for _, categoryID := range p.CategoryIDs {
+		if _, err := tx.Exec(
+			"INSERT INTO post_categories (post_id, category_id) VALUES (?, ?)",
+			postID, categoryID,
+		); err != nil {
+			return 0, 0, fmt.Errorf("insert post category: %w", err)
+		}
+	}
+
+	if err := insertPostTags(tx, postID, p.Tags); err != nil {
+		return 0, 0, err
+	}
+
+	if p.Poll != nil {
+		pollID, err = CreatePoll(tx, postID, *p.Poll)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return postID, pollID, nil
+}
+
+// GetPostSummary returns the fields announced to clients when a post is
+// published.
+func GetPostSummary(postID int64) (models.PostSummary, error) {
+	var s models.PostSummary
+	var categories sql.NullString
+	err := DB.QueryRow(`
+		SELECT p.post_id, p.user_id, u.username, p.title, p.created_at,
+		       (SELECT GROUP_CONCAT(c.name) FROM post_categories pc
+		        JOIN categories c ON c.category_id = pc.category_id
+		        WHERE pc.post_id = p.post_id)
+		FROM posts p
+		JOIN users u ON u.user_id = p.user_id
+		WHERE p.post_id = ?`, postID,
+	).Scan(&s.PostID, &s.UserID, &s.Username, &s.Title, &s.CreatedAt, &categories)
+	s.Categories = categories.String
+	return s, err
+}
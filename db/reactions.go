@@ -0,0 +1,349 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"real/models"
+)
+
+var (
+	ErrReactionTarget = errors.New("reaction target not found")
+	ErrReactionType   = errors.New("invalid reaction type")
+)
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// reactionTargetQueries look up the post a reaction target belongs to, which
+// decides the reaction types allowed on it. Deleted comments match nothing.
+var reactionTargetQueries = map[string]string{
+	models.ReactionTargetPost:    `SELECT post_id FROM posts WHERE post_id = ?`,
+	models.ReactionTargetComment: `SELECT post_id FROM comments WHERE comment_id = ? AND deleted_at IS NULL`,
+}
+
+// ToggleReaction records userID's reaction on a post or comment. Sending the
+// reaction the user already has removes it; sending a different one replaces
+// it. The returned summary reflects the state after the change.
+func ToggleReaction(userID int, targetType string, targetID int, reactionType string) (models.ReactionSummary, error) {
+	summary := models.ReactionSummary{TargetType: targetType, TargetID: targetID}
+
+	postQuery, ok := reactionTargetQueries[targetType]
+	if !ok {
+		return summary, ErrReactionTarget
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return summary, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var postID int
+	err = tx.QueryRow(postQuery, targetID).Scan(&postID)
+	if err == sql.ErrNoRows {
+		return summary, ErrReactionTarget
+	} else if err != nil {
+		return summary, fmt.Errorf("check target: %w", err)
+	}
+
+	var existing string
+	err = tx.QueryRow(
+		`SELECT reaction_type FROM reactions WHERE user_id = ? AND target_type = ? AND target_id = ?`,
+		userID, targetType, targetID,
+	).Scan(&existing)
+	if err != nil && err != sql.ErrNoRows {
+		return summary, fmt.Errorf("load reaction: %w", err)
+	}
+
+	// Removing a reaction is always allowed, even if its type has since been
+	// disabled; adding or switching must use a type enabled for the post.
+	if existing != reactionType {
+		allowed, err := allowedReactionTypes(tx, postID)
+		if err != nil {
+			return summary, err
+		}
+		if !containsReactionType(allowed, reactionType) {
+			return summary, ErrReactionType
+		}
+	}
+
+	switch {
+	case existing == "":
+		_, err = tx.Exec(
+			`INSERT INTO reactions (user_id, target_type, target_id, reaction_type) VALUES (?, ?, ?, ?)`,
+			userID, targetType, targetID, reactionType,
+		)
+	case existing == reactionType:
+		_, err = tx.Exec(
+			`DELETE FROM reactions WHERE user_id = ? AND target_type = ? AND target_id = ?`,
+			userID, targetType, targetID,
+		)
+	default:
+		_, err = tx.Exec(
+			`UPDATE reactions SET reaction_type = ?, created_at = CURRENT_TIMESTAMP
+			 WHERE user_id = ? AND target_type = ? AND target_id = ?`,
+			reactionType, userID, targetType, targetID,
+		)
+	}
+	if err != nil {
+		return summary, fmt.Errorf("update reaction: %w", err)
+	}
+
+	summary, err = reactionSummary(tx, userID, targetType, targetID)
+	if err != nil {
+		return summary, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return summary, fmt.Errorf("commit reaction: %w", err)
+	}
+	return summary, nil
+}
+
+func reactionSummary(tx *sql.Tx, userID int, targetType string, targetID int) (models.ReactionSummary, error) {
+	summary := models.ReactionSummary{TargetType: targetType, TargetID: targetID}
+
+	counts, err := ReactionCounts(tx, targetType, []int{targetID})
+	if err != nil {
+		return summary, err
+	}
+	summary.Counts = counts[targetID]
+	if summary.Counts == nil {
+		summary.Counts = map[string]int{}
+	}
+	summary.Likes = summary.Counts[models.ReactionLike]
+	summary.Dislikes = summary.Counts[models.ReactionDislike]
+
+	err = tx.QueryRow(
+		`SELECT reaction_type FROM reactions WHERE user_id = ? AND target_type = ? AND target_id = ?`,
+		userID, targetType, targetID,
+	).Scan(&summary.UserReaction)
+	if err != nil && err != sql.ErrNoRows {
+		return summary, fmt.Errorf("load reaction: %w", err)
+	}
+	return summary, nil
+}
+
+// ReactionCounts returns the number of reactions of each type on the given
+// targets, keyed by target ID. Targets without reactions are absent.
+func ReactionCounts(q queryer, targetType string, targetIDs []int) (map[int]map[string]int, error) {
+	counts := make(map[int]map[string]int)
+	if len(targetIDs) == 0 {
+		return counts, nil
+	}
+
+	args := []interface{}{targetType}
+	placeholders := ""
+	for i, id := range targetIDs {
+		if i > 0 {
+			placeholders += ", "
+		}
+		placeholders += "?"
+		args = append(args, id)
+	}
+
+	rows, err := q.Query(`
+		SELECT target_id, reaction_type, COUNT(*)
+		FROM reactions
+		WHERE target_type = ? AND target_id IN (`+placeholders+`)
+		GROUP BY target_id, reaction_type`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("count reactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var targetID, n int
+		var reactionType string
+		if err := rows.Scan(&targetID, &reactionType, &n); err != nil {
+			return nil, fmt.Errorf("scan reaction count: %w", err)
+		}
+		if counts[targetID] == nil {
+			counts[targetID] = make(map[string]int)
+		}
+		counts[targetID][reactionType] = n
+	}
+	return counts, rows.Err()
+}
+
+// GetReactionTypes lists the configured reaction types in display order.
+// Inactive types are only included when includeInactive is set.
+func GetReactionTypes(includeInactive bool) ([]models.ReactionType, error) {
+	query := `SELECT code, emoji, label, sort_order, is_default, active FROM reaction_types`
+	if !includeInactive {
+		query += ` WHERE active = 1`
+	}
+	query += ` ORDER BY sort_order, code`
+	return scanReactionTypes(DB, query)
+}
+
+// GetCategoryReactionTypes returns the reaction types enabled for a category:
+// the ones configured for it, or the default set when it has none.
+func GetCategoryReactionTypes(categoryID int) ([]models.ReactionType, error) {
+	return scanReactionTypes(DB, `
+		SELECT code, emoji, label, sort_order, is_default, active
+		FROM reaction_types rt
+		WHERE rt.active = 1 AND (
+			rt.code IN (SELECT reaction_type FROM category_reaction_types WHERE category_id = ?)
+			OR (rt.is_default = 1 AND NOT EXISTS (SELECT 1 FROM category_reaction_types WHERE category_id = ?))
+		)
+		ORDER BY rt.sort_order, rt.code`, categoryID, categoryID)
+}
+
+// GetPostReactionTypes returns the reaction codes allowed on each of the
+// given posts and their comments, keyed by post ID.
+func GetPostReactionTypes(postIDs []int) (map[int][]string, error) {
+	return postReactionTypes(DB, postIDs)
+}
+
+// allowedReactionTypes returns the reaction codes allowed on one post.
+func allowedReactionTypes(q queryer, postID int) ([]string, error) {
+	types, err := postReactionTypes(q, []int{postID})
+	if err != nil {
+		return nil, err
+	}
+	return types[postID], nil
+}
+
+// postReactionTypes returns, for each post, the union of the sets of every
+// category it is in. A category without its own configuration, or a post
+// without any category, uses the default set. Posts that do not exist are
+// absent.
+func postReactionTypes(q queryer, postIDs []int) (map[int][]string, error) {
+	types := make(map[int][]string)
+	if len(postIDs) == 0 {
+		return types, nil
+	}
+
+	args := make([]interface{}, 0, len(postIDs))
+	placeholders := ""
+	for i, id := range postIDs {
+		if i > 0 {
+			placeholders += ", "
+		}
+		placeholders += "?"
+		args = append(args, id)
+	}
+
+	rows, err := q.Query(`
+		SELECT p.post_id, rt.code
+		FROM posts p
+		JOIN reaction_types rt ON rt.active = 1 AND (
+			EXISTS (
+				SELECT 1 FROM post_categories pc
+				JOIN category_reaction_types crt ON crt.category_id = pc.category_id
+				WHERE pc.post_id = p.post_id AND crt.reaction_type = rt.code
+			)
+			OR (rt.is_default = 1 AND (
+				NOT EXISTS (SELECT 1 FROM post_categories WHERE post_id = p.post_id)
+				OR EXISTS (
+					SELECT 1 FROM post_categories pc
+					WHERE pc.post_id = p.post_id
+					AND NOT EXISTS (SELECT 1 FROM category_reaction_types crt WHERE crt.category_id = pc.category_id)
+				)
+			))
+		)
+		WHERE p.post_id IN (`+placeholders+`)
+		ORDER BY p.post_id, rt.sort_order, rt.code`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("load allowed reactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID int
+		var code string
+		if err := rows.Scan(&postID, &code); err != nil {
+			return nil, err
+		}
+		types[postID] = append(types[postID], code)
+	}
+	return types, rows.Err()
+}
+
+// SaveReactionType creates a reaction type or updates the one with the same
+// code.
+func SaveReactionType(rt models.ReactionType) error {
+	_, err := DB.Exec(`
+		INSERT INTO reaction_types (code, emoji, label, sort_order, is_default, active)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(code) DO UPDATE SET
+			emoji = excluded.emoji,
+			label = excluded.label,
+			sort_order = excluded.sort_order,
+			is_default = excluded.is_default,
+			active = excluded.active`,
+		rt.Code, rt.Emoji, rt.Label, rt.SortOrder, rt.IsDefault, rt.Active,
+	)
+	return err
+}
+
+// SetCategoryReactionTypes replaces the reaction types enabled for a
+// category. An empty list puts the category back on the default set.
+func SetCategoryReactionTypes(categoryID int, codes []string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM categories WHERE category_id = ?)`, categoryID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec(`DELETE FROM category_reaction_types WHERE category_id = ?`, categoryID); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		var known bool
+		if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM reaction_types WHERE code = ?)`, code).Scan(&known); err != nil {
+			return err
+		}
+		if !known {
+			return fmt.Errorf("%w: %s", ErrReactionType, code)
+		}
+		if _, err := tx.Exec(
+			`INSERT OR IGNORE INTO category_reaction_types (category_id, reaction_type) VALUES (?, ?)`,
+			categoryID, code,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func scanReactionTypes(q queryer, query string, args ...interface{}) ([]models.ReactionType, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := []models.ReactionType{}
+	for rows.Next() {
+		var rt models.ReactionType
+		if err := rows.Scan(&rt.Code, &rt.Emoji, &rt.Label, &rt.SortOrder, &rt.IsDefault, &rt.Active); err != nil {
+			return nil, err
+		}
+		types = append(types, rt)
+	}
+	return types, rows.Err()
+}
+
+func containsReactionType(codes []string, code string) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
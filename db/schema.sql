@@ -6,6 +6,9 @@ CREATE TABLE IF NOT EXISTS users (
     password TEXT NOT NULL,
     auth_type TEXT NOT NULL DEFAULT 'email',
     provider_id TEXT,
+    -- Set once the user follows the link emailed to their address
+    email_verified_at DATETIME,
+    role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin')),
     profile_picture TEXT,
     bio TEXT,
     age INTEGER,
@@ -16,22 +19,33 @@ CREATE TABLE IF NOT EXISTS users (
     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 );
 
--- Categories table
+-- Categories table. Categories nest under parent_id and are listed by
+-- position among their siblings; archived ones keep their posts but take no
+-- new ones.
 CREATE TABLE IF NOT EXISTS categories (
     category_id INTEGER PRIMARY KEY AUTOINCREMENT,
     name TEXT NOT NULL UNIQUE,
     description TEXT,
+    parent_id INTEGER REFERENCES categories(category_id),
+    color TEXT NOT NULL DEFAULT '',
+    icon TEXT NOT NULL DEFAULT '',
+    position INTEGER NOT NULL DEFAULT 0,
+    archived BOOLEAN NOT NULL DEFAULT 0,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 );
 
--- Posts table
+-- Posts table. Locked posts take no new comments; announcements are pushed
+-- to every user when announced_at is first set.
 CREATE TABLE IF NOT EXISTS posts (
     post_id INTEGER PRIMARY KEY AUTOINCREMENT,
     user_id INTEGER NOT NULL,
     title TEXT NOT NULL,
     content TEXT NOT NULL,
     imgurl TEXT,
+    locked_at DATETIME,
+    locked_by INTEGER,
+    announced_at DATETIME,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
@@ -51,39 +65,160 @@ CREATE TABLE IF NOT EXISTS comments (
     comment_id INTEGER PRIMARY KEY AUTOINCREMENT,
     post_id INTEGER NOT NULL,
     user_id INTEGER NOT NULL,
+    parent_comment_id INTEGER,
+    depth INTEGER NOT NULL DEFAULT 0,
     content TEXT NOT NULL,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    edited_at DATETIME,
+    deleted_at DATETIME,
+    deleted_by INTEGER,
     FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE,
-    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (parent_comment_id) REFERENCES comments(comment_id) ON DELETE CASCADE,
+    FOREIGN KEY (deleted_by) REFERENCES users(user_id)
 );
 
--- Likes table (for posts and comments)
-CREATE TABLE IF NOT EXISTS likes (
-    like_id INTEGER PRIMARY KEY AUTOINCREMENT,
+-- Reactions table (for posts and comments). Each user has at most one
+-- reaction on a target; reacting with another type replaces it
+CREATE TABLE IF NOT EXISTS reactions (
     user_id INTEGER NOT NULL,
-    post_id INTEGER,
-    comment_id INTEGER,
+    target_type TEXT NOT NULL CHECK (target_type IN ('post', 'comment')),
+    target_id INTEGER NOT NULL,
+    reaction_type TEXT NOT NULL,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    like_type TEXT NOT NULL CHECK (like_type IN ('like', 'dislike')),
-    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
-    FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE,
-    FOREIGN KEY (comment_id) REFERENCES comments(comment_id) ON DELETE CASCADE,
-    CONSTRAINT check_post_or_comment CHECK (
-        (post_id IS NOT NULL AND comment_id IS NULL) OR 
-        (post_id IS NULL AND comment_id IS NOT NULL)
-    )
+    PRIMARY KEY (user_id, target_type, target_id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_reactions_target ON reactions(target_type, target_id);
+
+-- Reaction types (admin-configurable emoji set)
+CREATE TABLE IF NOT EXISTS reaction_types (
+    code TEXT PRIMARY KEY,
+    emoji TEXT NOT NULL,
+    label TEXT NOT NULL,
+    sort_order INTEGER NOT NULL DEFAULT 0,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    active BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+-- Reaction types enabled per category (none = default set)
+CREATE TABLE IF NOT EXISTS category_reaction_types (
+    category_id INTEGER NOT NULL,
+    reaction_type TEXT NOT NULL,
+    PRIMARY KEY (category_id, reaction_type),
+    FOREIGN KEY (category_id) REFERENCES categories(category_id) ON DELETE CASCADE,
+    FOREIGN KEY (reaction_type) REFERENCES reaction_types(code) ON DELETE CASCADE
 );
 
 -- Sessions table
 CREATE TABLE IF NOT EXISTS sessions (
     session_id TEXT PRIMARY KEY,
     user_id INTEGER NOT NULL,
+    -- Slides forward while the session is used, up to absolute_expires_at
     expires_at DATETIME NOT NULL,
+    absolute_expires_at DATETIME,
+    remember INTEGER NOT NULL DEFAULT 0,
+    -- The role the session was issued for; its ID is rotated when that changes
+    role TEXT NOT NULL DEFAULT '',
+    -- Must be sent back with state-changing requests made with the cookie
+    csrf_token TEXT NOT NULL DEFAULT '',
+    -- The device the session was started on, for the user's session list
+    created_at DATETIME,
+    last_used_at DATETIME,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
     FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
     
 );
 
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+
+-- Every login attempt, for throttling and the audit log of failed logins.
+-- user_id is NULL when the identifier matched no account
+CREATE TABLE IF NOT EXISTS login_attempts (
+    attempt_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    identifier TEXT NOT NULL,
+    user_id INTEGER,
+    ip_address TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    success INTEGER NOT NULL DEFAULT 0,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_user ON login_attempts(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_identifier ON login_attempts(identifier, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts(ip_address, created_at);
+
+-- TOTP two-factor authentication. A secret only takes effect once enabled_at
+-- is set, after the user has entered a code generated from it; last_step is
+-- the time step of the latest code used, so codes cannot be replayed
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INTEGER PRIMARY KEY,
+    secret TEXT NOT NULL,
+    enabled_at DATETIME,
+    last_step INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- Single-use codes that stand in for a TOTP code, stored hashed
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    code_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id);
+
+-- Logins whose password was right but whose second factor is still to come
+CREATE TABLE IF NOT EXISTS pending_logins (
+    token TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    remember INTEGER NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- Single-use tokens emailed for password resets and email verification,
+-- stored hashed. email is the address the token was sent to
+CREATE TABLE IF NOT EXISTS user_tokens (
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    purpose TEXT NOT NULL CHECK (purpose IN ('password_reset', 'email_verification')),
+    email TEXT NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user ON user_tokens(user_id, purpose);
+
+-- Accounts logged in through a third-party provider have auth_type set to the
+-- provider's name and provider_id to the user's ID there
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_provider ON users(auth_type, provider_id) WHERE provider_id IS NOT NULL;
+
+-- Logins in progress at a third-party provider, by the hash of the state
+-- parameter sent to it. link_user_id is set when a logged-in user is linking
+-- the provider to their account rather than logging in
+CREATE TABLE IF NOT EXISTS oauth_states (
+    state_hash TEXT PRIMARY KEY,
+    provider TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    link_user_id INTEGER,
+    remember INTEGER NOT NULL DEFAULT 0,
+    expires_at DATETIME NOT NULL,
+    FOREIGN KEY (link_user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
 -- Private messages table
 CREATE TABLE IF NOT EXISTS private_messages (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -105,13 +240,193 @@ CREATE TABLE IF NOT EXISTS user_status (
     FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
 );
 
--- Comment reactions table 
-CREATE TABLE IF NOT EXISTS comment_reactions (
+-- Polls attached to posts
+CREATE TABLE IF NOT EXISTS polls (
+    poll_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id INTEGER NOT NULL UNIQUE,
+    question TEXT NOT NULL,
+    multiple_choice BOOLEAN NOT NULL DEFAULT FALSE,
+    anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+    closes_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS poll_options (
+    option_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    poll_id INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    FOREIGN KEY (poll_id) REFERENCES polls(poll_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS poll_votes (
+    poll_id INTEGER NOT NULL,
+    option_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (option_id, user_id),
+    FOREIGN KEY (poll_id) REFERENCES polls(poll_id) ON DELETE CASCADE,
+    FOREIGN KEY (option_id) REFERENCES poll_options(option_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_poll_votes_poll_user ON poll_votes(poll_id, user_id);
+
+-- Unpublished posts. Drafts with a publish_at are published by the background
+-- publisher once that time has passed and are deleted when published.
+CREATE TABLE IF NOT EXISTS post_drafts (
+    draft_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    title TEXT NOT NULL DEFAULT '',
+    content TEXT NOT NULL DEFAULT '',
+    categories TEXT NOT NULL DEFAULT '',
+    tags TEXT NOT NULL DEFAULT '',
+    images TEXT,
+    poll TEXT,
+    publish_at DATETIME,
+    publish_error TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_drafts_user ON post_drafts(user_id, updated_at);
+CREATE INDEX IF NOT EXISTS idx_post_drafts_publish_at ON post_drafts(publish_at);
+
+-- Named lists of saved posts and comments, ordered by position
+CREATE TABLE IF NOT EXISTS bookmark_collections (
+    collection_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, name),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS bookmarks (
+    bookmark_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    collection_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    target_type TEXT NOT NULL CHECK (target_type IN ('post', 'comment')),
+    target_id INTEGER NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (collection_id, target_type, target_id),
+    FOREIGN KEY (collection_id) REFERENCES bookmark_collections(collection_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_bookmarks_user_target ON bookmarks(user_id, target_type, target_id);
+
+-- Users and categories a user follows, for the personalised feed
+CREATE TABLE IF NOT EXISTS user_follows (
+    follower_id INTEGER NOT NULL,
+    followed_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (follower_id, followed_id),
+    FOREIGN KEY (follower_id) REFERENCES users(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (followed_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_follows_followed ON user_follows(followed_id);
+
+CREATE TABLE IF NOT EXISTS category_follows (
     user_id INTEGER NOT NULL,
-    comment_id INTEGER NOT NULL,
-    reaction_type TEXT NOT NULL CHECK (reaction_type IN ('like', 'dislike')),
+    category_id INTEGER NOT NULL,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    PRIMARY KEY (user_id, comment_id),
+    PRIMARY KEY (user_id, category_id),
     FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
-    FOREIGN KEY (comment_id) REFERENCES comments(comment_id) ON DELETE CASCADE
-);
\ No newline at end of file
+    FOREIGN KEY (category_id) REFERENCES categories(category_id) ON DELETE CASCADE
+);
+
+-- Processed uploads. url is the media storage key of the "full" variant,
+-- which is what posts.imgurl holds; every generated size is listed in
+-- image_variants. URLs are only made from keys when a response is built.
+-- Uploads are stored once per content_hash (the SHA-256 of the uploaded
+-- file); ref_count is kept up to date by triggers on post_images and
+-- post_drafts, and images left at zero are removed by the media cleanup job.
+CREATE TABLE IF NOT EXISTS images (
+    image_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    url TEXT NOT NULL UNIQUE,
+    content_hash TEXT,
+    content_type TEXT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    size_bytes INTEGER NOT NULL,
+    ref_count INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    last_used_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS image_variants (
+    image_id INTEGER NOT NULL,
+    variant TEXT NOT NULL,
+    url TEXT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    PRIMARY KEY (image_id, variant),
+    FOREIGN KEY (image_id) REFERENCES images(image_id) ON DELETE CASCADE
+);
+
+-- Ordered images of a post. posts.imgurl mirrors the first one so older
+-- clients still show a cover image.
+CREATE TABLE IF NOT EXISTS post_images (
+    post_image_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id INTEGER NOT NULL,
+    image_url TEXT NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    alt_text TEXT NOT NULL DEFAULT '',
+    caption TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_images_post ON post_images(post_id, position);
+
+-- Free-form labels on posts. Names are stored normalised: lower case, with
+-- words joined by dashes. post_tags.created_at is when the post was tagged,
+-- which trending tags are counted by.
+CREATE TABLE IF NOT EXISTS tags (
+    tag_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS post_tags (
+    post_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (post_id, tag_id),
+    FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tags(tag_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_tags_tag ON post_tags(tag_id, created_at);
+
+-- Posts moderators pinned to the top of a category, or of the global post
+-- list when category_id is 0.
+CREATE TABLE IF NOT EXISTS post_pins (
+    post_id INTEGER NOT NULL,
+    category_id INTEGER NOT NULL DEFAULT 0,
+    pinned_by INTEGER,
+    pinned_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (post_id, category_id),
+    FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE,
+    FOREIGN KEY (pinned_by) REFERENCES users(user_id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_pins_category ON post_pins(category_id, pinned_at);
+
+-- Announcements a user has dismissed
+CREATE TABLE IF NOT EXISTS announcement_reads (
+    user_id INTEGER NOT NULL,
+    post_id INTEGER NOT NULL,
+    read_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, post_id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE
+);
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"real/models"
+)
+
+var ErrTagNotFound = errors.New("tag not found")
+
+// sqliteTimeFormat matches CURRENT_TIMESTAMP, so times can be compared with
+// the created_at columns as text.
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// insertPostTags tags a post, creating tags that are new. Names must already
+// be normalised.
+func insertPostTags(tx *sql.Tx, postID int64, tags []string) error {
+	for _, name := range tags {
+		if _, err := tx.Exec(`INSERT INTO tags (name) VALUES (?) ON CONFLICT(name) DO NOTHING`, name); err != nil {
+			return fmt.Errorf("insert tag %q: %w", name, err)
+		}
+		if _, err := tx.Exec(
+			`INSERT OR IGNORE INTO post_tags (post_id, tag_id) SELECT ?, tag_id FROM tags WHERE name = ?`,
+			postID, name,
+		); err != nil {
+			return fmt.Errorf("tag post: %w", err)
+		}
+	}
+	return nil
+}
+
+// SearchTags returns up to limit tags starting with prefix, most used first.
+// Tags no post uses any more are left out.
+func SearchTags(prefix string, limit int) ([]models.Tag, error) {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	return queryTags(`
+		SELECT t.name, COUNT(*) AS post_count
+		FROM tags t
+		JOIN post_tags pt ON pt.tag_id = t.tag_id
+		WHERE t.name LIKE ? ESCAPE '\'
+		GROUP BY t.tag_id
+		ORDER BY post_count DESC, t.name
+		LIMIT ?`,
+		escaped+"%", limit,
+	)
+}
+
+// GetTag loads a tag with the number of posts using it.
+func GetTag(name string) (models.Tag, error) {
+	var t models.Tag
+	err := DB.QueryRow(`
+		SELECT t.name, (SELECT COUNT(*) FROM post_tags WHERE tag_id = t.tag_id)
+		FROM tags t WHERE t.name = ?`, name,
+	).Scan(&t.Name, &t.PostCount)
+	if err == sql.ErrNoRows {
+		return t, ErrTagNotFound
+	}
+	return t, err
+}
+
+// RelatedTags returns up to limit other tags found on posts tagged name,
+// with PostCount set to the number of posts they share with it.
+func RelatedTags(name string, limit int) ([]models.Tag, error) {
+	return queryTags(`
+		SELECT t.name, COUNT(*) AS shared
+		FROM tags base
+		JOIN post_tags bpt ON bpt.tag_id = base.tag_id
+		JOIN post_tags pt ON pt.post_id = bpt.post_id AND pt.tag_id != base.tag_id
+		JOIN tags t ON t.tag_id = pt.tag_id
+		WHERE base.name = ?
+		GROUP BY t.tag_id
+		ORDER BY shared DESC, t.name
+		LIMIT ?`,
+		name, limit,
+	)
+}
+
+// TrendingTags ranks the tags used in the period before now by how many posts
+// they were added to, and also counts the period of the same length before
+// that, so clients can tell rising tags from steady ones.
+func TrendingTags(now time.Time, period time.Duration, limit int) ([]models.TrendingTag, error) {
+	since := now.Add(-period).UTC().Format(sqliteTimeFormat)
+	before := now.Add(-2 * period).UTC().Format(sqliteTimeFormat)
+
+	rows, err := DB.Query(`
+		SELECT t.name,
+			SUM(pt.created_at >= ?1) AS recent,
+			SUM(pt.created_at >= ?2 AND pt.created_at < ?1) AS previous,
+			COUNT(*)
+		FROM tags t
+		JOIN post_tags pt ON pt.tag_id = t.tag_id
+		GROUP BY t.tag_id
+		HAVING recent > 0
+		ORDER BY recent DESC, recent - previous DESC, t.name
+		LIMIT ?3`,
+		since, before, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("load trending tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []models.TrendingTag{}
+	for rows.Next() {
+		var t models.TrendingTag
+		if err := rows.Scan(&t.Name, &t.RecentCount, &t.PreviousCount, &t.PostCount); err != nil {
+			return nil, fmt.Errorf("scan trending tag: %w", err)
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+func queryTags(query string, args ...interface{}) ([]models.Tag, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("load tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []models.Tag{}
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.Name, &t.PostCount); err != nil {
+			return nil, fmt.Errorf("scan tag: %w", err)
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+func joinTags(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+func splitTags(s string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(s, ",") {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
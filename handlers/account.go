@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"real/auth"
+	"real/config"
+	"real/db"
+	"real/mailer"
+
+	rt_hub "real/websocket"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// appBaseURL is where the site is reached, for links in emails
+	appBaseURL = strings.TrimRight(config.String("APP_BASE_URL", "http://localhost:9002"), "/")
+	// requireVerifiedEmail keeps users from posting and commenting until
+	// they have confirmed their email address
+	requireVerifiedEmail = config.Bool("REQUIRE_VERIFIED_EMAIL", false)
+)
+
+// requireCanPost writes a 403 and returns false when userID may not post yet
+// because their email address is unverified.
+func requireCanPost(w http.ResponseWriter, userID int) bool {
+	if !requireVerifiedEmail {
+		return true
+	}
+	verified, err := db.EmailVerified(userID)
+	if err != nil {
+		log.Printf("Error checking email verification of %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return false
+	}
+	if !verified {
+		WriteJSON(w, http.StatusForbidden, map[string]string{"error": "Please verify your email address before posting"})
+		return false
+	}
+	return true
+}
+
+// sendVerificationEmail emails userID a link that confirms email is theirs.
+func sendVerificationEmail(userID int, username, email string) error {
+	token, err := auth.IssueToken(userID, auth.TokenEmailVerification, email)
+	if err != nil {
+		return err
+	}
+	link := appBaseURL + "/email/verify?token=" + url.QueryEscape(token)
+	return mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: "Hi " + username + ",\n\n" +
+			"Please confirm your email address by opening this link:\n\n" + link + "\n\n" +
+			"If you did not create an account, you can ignore this email.\n",
+	})
+}
+
+// sendPasswordResetEmail emails userID a link to choose a new password.
+func sendPasswordResetEmail(userID int, username, email string) error {
+	token, err := auth.IssueToken(userID, auth.TokenPasswordReset, email)
+	if err != nil {
+		return err
+	}
+	link := appBaseURL + "/?reset_token=" + url.QueryEscape(token)
+	return mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: "Hi " + username + ",\n\n" +
+			"Someone asked to reset the password of your account. To choose a new one, open this link:\n\n" +
+			link + "\n\n" +
+			"The link works once and expires soon. If you did not ask for this, you can ignore this email; " +
+			"your password stays as it is.\n",
+	})
+}
+
+// ForgotPasswordHandler emails a password reset link to the account with the
+// given email address. It answers the same whether or not there is one, so
+// that it cannot be used to find out who has an account.
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Email is required"})
+		return
+	}
+
+	var (
+		userID          int
+		username, email string
+	)
+	err := db.DB.QueryRow(
+		`SELECT user_id, username, email FROM users WHERE email = ?`, strings.TrimSpace(req.Email),
+	).Scan(&userID, &username, &email)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		log.Printf("Error looking up user for password reset: %v", err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		return
+	default:
+		// Sent in the background, so that how long the response takes does
+		// not give away that the account exists either
+		go func() {
+			err := sendPasswordResetEmail(userID, username, email)
+			if err != nil && !errors.Is(err, auth.ErrTokenTooSoon) {
+				log.Printf("Error sending password reset email to %d: %v", userID, err)
+			}
+		}()
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "If an account uses that address, a link to reset its password is on its way",
+	})
+}
+
+// ResetPasswordHandler sets a new password given a token from a password
+// reset email. Every session of the user is ended, closing their realtime
+// connections, since whoever held them may have known the old password.
+func ResetPasswordHandler(hub *rt_hub.Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	var req struct {
+		Token           string `json:"token"`
+		Password        string `json:"password"`
+		ConfirmPassword string `json:"confirm_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+	if errs := validatePassword(req.Password, req.ConfirmPassword); len(errs) > 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": errs})
+		return
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("Password hashing error: %v", err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	userID, email, err := auth.ConsumeToken(req.Token, auth.TokenPasswordReset)
+	if errors.Is(err, auth.ErrInvalidToken) {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "This reset link is invalid or has expired"})
+		return
+	} else if err != nil {
+		log.Printf("Error using password reset token: %v", err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	// An account whose address was unverified may have been claimed through
+	// a provider by someone else; whoever reads the address's mail owns it,
+	// so the provider is unlinked
+	_, err = db.DB.Exec(`
+		UPDATE users SET password = ?,
+			auth_type = CASE WHEN email_verified_at IS NULL THEN ? ELSE auth_type END,
+			provider_id = CASE WHEN email_verified_at IS NULL THEN NULL ELSE provider_id END
+		WHERE user_id = ?`, string(hashedPassword), auth.AuthTypeEmail, userID)
+	if err != nil {
+		log.Printf("Error resetting password of %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		return
+	}
+	// The link reached the user's inbox, which confirms the address
+	if _, err := db.MarkEmailVerified(userID, email); err != nil {
+		log.Printf("Error marking email of %d verified: %v", userID, err)
+	}
+
+	revoked, err := auth.RevokeOtherSessions(userID, "")
+	hub.CloseSessions(revoked...)
+	if err != nil {
+		log.Printf("Error ending sessions of %d after password reset: %v", userID, err)
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true, "message": "Your password has been reset, please log in"})
+}
+
+// VerifyEmailHandler is the link in verification emails. It confirms the
+// address the email was sent to, provided it is still the user's, and sends
+// the browser on to the site with ?email_verified=true or false.
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	verified := false
+	userID, email, err := auth.ConsumeToken(r.URL.Query().Get("token"), auth.TokenEmailVerification)
+	if err == nil {
+		verified, err = db.MarkEmailVerified(userID, email)
+	}
+	if err != nil && !errors.Is(err, auth.ErrInvalidToken) {
+		log.Printf("Error verifying email: %v", err)
+	}
+	http.Redirect(w, r, "/?email_verified="+strconv.FormatBool(verified), http.StatusSeeOther)
+}
+
+// ResendVerificationHandler emails the session user a new verification link.
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var (
+		username, email string
+		verified        bool
+	)
+	err := db.DB.QueryRow(
+		`SELECT username, email, email_verified_at IS NOT NULL FROM users WHERE user_id = ?`, userID,
+	).Scan(&username, &email, &verified)
+	if err != nil {
+		log.Printf("Error loading user %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	if verified {
+		WriteJSON(w, http.StatusConflict, map[string]string{"error": "Your email address is already verified"})
+		return
+	}
+
+	err = sendVerificationEmail(userID, username, email)
+	if errors.Is(err, auth.ErrTokenTooSoon) {
+		WriteJSON(w, http.StatusTooManyRequests, map[string]string{"error": "A verification email was just sent, please wait a minute"})
+		return
+	} else if err != nil {
+		log.Printf("Error sending verification email to %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to send email"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"real/auth"
+	"real/db"
+	"real/models"
+)
+
+var reactionCodePattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// requireAdmin writes a 401 or 403 response and returns false unless the
+// request comes from a logged-in admin.
+func requireAdmin(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return 0, false
+	}
+	if !db.IsAdmin(userID) {
+		WriteJSON(w, http.StatusForbidden, map[string]string{"error": "Admin access required"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// requireModerator writes a 401 or 403 response and returns false unless the
+// request comes from a logged-in moderator or admin.
+func requireModerator(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return 0, false
+	}
+	if !db.IsModerator(userID) {
+		WriteJSON(w, http.StatusForbidden, map[string]string{"error": "Moderator access required"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// requireUser returns the session user, writing a 401 when there is none.
+func requireUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID := auth.GetCurrentUserID(r)
+	if userID == 0 {
+		WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// ReactionTypesHandler lists reaction types (GET) or lets an admin create or
+// update one (POST). Admins can pass ?all=true to include inactive types.
+func ReactionTypesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		includeInactive := false
+		if r.URL.Query().Get("all") == "true" {
+			if _, ok := requireAdmin(w, r); !ok {
+				return
+			}
+			includeInactive = true
+		}
+
+		types, err := db.GetReactionTypes(includeInactive)
+		if err != nil {
+			log.Printf("Error fetching reaction types: %v", err)
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to fetch reaction types"})
+			return
+		}
+		WriteJSON(w, http.StatusOK, types)
+
+	case http.MethodPost:
+		if _, ok := requireAdmin(w, r); !ok {
+			return
+		}
+
+		rt := models.ReactionType{Active: true}
+		if err := json.NewDecoder(r.Body).Decode(&rt); err != nil {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+			return
+		}
+		rt.Code = strings.ToLower(strings.TrimSpace(rt.Code))
+		rt.Emoji = strings.TrimSpace(rt.Emoji)
+		rt.Label = strings.TrimSpace(rt.Label)
+
+		errs := make(map[string]string)
+		if !reactionCodePattern.MatchString(rt.Code) {
+			errs["code"] = "Code must be 1-32 lowercase letters, digits or underscores"
+		}
+		if rt.Emoji == "" {
+			errs["emoji"] = "Emoji is required"
+		}
+		if rt.Label == "" {
+			errs["label"] = "Label is required"
+		}
+		if len(errs) > 0 {
+			WriteJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": errs})
+			return
+		}
+
+		if err := db.SaveReactionType(rt); err != nil {
+			log.Printf("Error saving reaction type %q: %v", rt.Code, err)
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save reaction type"})
+			return
+		}
+		WriteJSON(w, http.StatusOK, rt)
+
+	default:
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// CategoryReactionTypesHandler returns the reaction types enabled for a
+// category (GET ?category_id=) or lets an admin replace them (POST). Posting
+// an empty list puts the category back on the default set.
+func CategoryReactionTypesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		categoryID, err := strconv.Atoi(r.URL.Query().Get("category_id"))
+		if err != nil || categoryID <= 0 {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid category_id"})
+			return
+		}
+
+		types, err := db.GetCategoryReactionTypes(categoryID)
+		if err != nil {
+			log.Printf("Error fetching reaction types for category %d: %v", categoryID, err)
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to fetch reaction types"})
+			return
+		}
+		WriteJSON(w, http.StatusOK, types)
+
+	case http.MethodPost:
+		if _, ok := requireAdmin(w, r); !ok {
+			return
+		}
+
+		var req struct {
+			CategoryID    int      `json:"category_id"`
+			ReactionTypes []string `json:"reaction_types"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CategoryID <= 0 {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+			return
+		}
+
+		err := db.SetCategoryReactionTypes(req.CategoryID, req.ReactionTypes)
+		switch {
+		case err == sql.ErrNoRows:
+			WriteJSON(w, http.StatusNotFound, map[string]string{"error": "Category not found"})
+			return
+		case errors.Is(err, db.ErrReactionType):
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		case err != nil:
+			log.Printf("Error setting reaction types for category %d: %v", req.CategoryID, err)
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update category"})
+			return
+		}
+
+		types, err := db.GetCategoryReactionTypes(req.CategoryID)
+		if err != nil {
+			log.Printf("Error fetching reaction types for category %d: %v", req.CategoryID, err)
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to fetch reaction types"})
+			return
+		}
+		WriteJSON(w, http.StatusOK, types)
+
+	default:
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// maxLoginAttempts caps how many failed logins LoginAttemptsHandler returns.
+const maxLoginAttempts = 200
+
+// LoginAttemptsHandler lets an admin review recent failed logins, newest
+// first, optionally for one user (?user_id=) and up to ?limit= of them.
+func LoginAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > maxLoginAttempts {
+		limit = maxLoginAttempts
+	}
+
+	attempts, err := auth.FailedLogins(userID, limit)
+	if err != nil {
+		log.Printf("Error fetching failed logins: %v", err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, attempts)
+}
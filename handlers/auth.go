@@ -4,49 +4,48 @@ import (
 	"database/sql"
 	"encoding/json"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
 
+	"real/auth"
 	"real/db"
 
-	"github.com/google/uuid"
+	rt_hub "real/websocket"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/logout" {
-		return
-	}
+// LogoutHandler ends the current session: it is deleted, its cookie cleared
+// and its realtime connection closed, which marks the user offline unless
+// they are still connected elsewhere. Logging out without a session succeeds,
+// so a client can always reset its state.
+func LogoutHandler(hub *rt_hub.Hub, w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
 		return
 	}
 
-	cookie, err := r.Cookie("session_id")
-	if err == nil && cookie.Value != "" {
-		// Get user ID before deleting session to update status
-		var userID int
-		err = db.DB.QueryRow("SELECT user_id FROM sessions WHERE session_id = ?", cookie.Value).Scan(&userID)
-		if err == nil {
-			// Update user status to offline
-			db.UpdateUserStatus(userID, false)
-		}
-
-		_, err = db.DB.Exec(`DELETE FROM sessions WHERE session_id = ?`, cookie.Value)
-		if err != nil {
-			log.Printf("Error deleting session: %v", err)
-		}
+	auth.ClearSessionCookie(w)
+	session, ok := auth.CurrentSession(r)
+	if !ok {
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+		return
 	}
 
-	http.SetCookie(w, &http.Cookie{Name: "session_id", Value: "", Expires: time.Now().Add(-time.Hour), Path: "/", HttpOnly: true})
-	http.Redirect(w, r, "/login", http.StatusSeeOther)
+	if err := auth.DeleteSession(session.ID); err != nil {
+		log.Printf("Error deleting session: %v", err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		return
+	}
+	hub.LogOut(session.UserID, session.ID)
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true})
 }
 
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	// Set content type first
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8081")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -60,6 +59,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var loginData struct {
 		Identifier string `json:"identifier"`
 		Password   string `json:"password"`
+		RememberMe bool   `json:"remember_me"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&loginData); err != nil {
@@ -96,25 +96,45 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
         WHERE username = ? OR email = ?`,
 		loginData.Identifier, loginData.Identifier,
 	).Scan(&userID, &username, &email, &passwordHash)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Database error: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Internal server error",
+		})
+		return
+	}
+	known := err == nil
+
+	// Refuse to check the password at all while the account or the address
+	// is backing off or locked out. The attempt is reserved first, so that
+	// parallel attempts count against each other.
+	attemptID, wait, err := auth.ReserveLogin(r, userID, loginData.Identifier)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"success": false,
-				"message": "Invalid credentials",
-			})
-		} else {
-			log.Printf("Database error: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{
-				"message": "Internal server error",
-			})
-		}
+		log.Printf("Error checking login attempts: %v", err)
+		cancelLogin(attemptID)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Internal server error",
+		})
+		return
+	}
+	if wait > 0 {
+		writeLoginThrottled(w, wait)
 		return
 	}
 
-	// Compare password hashes
-	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(loginData.Password)); err != nil {
+	// Unknown identifiers still go through bcrypt, so that how long the
+	// response takes does not tell whether the account exists
+	reason := ""
+	if !known {
+		auth.CompareUnknownUser(loginData.Password)
+		userID, reason = 0, auth.LoginUnknownUser
+	} else if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(loginData.Password)) != nil {
+		reason = auth.LoginBadPassword
+	}
+	if reason != "" {
+		finishLogin(attemptID, false, reason)
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": false,
@@ -122,27 +142,51 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	// Convert userID to string for session
-
-	// Create new session
-	sessionID := uuid.New().String()
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
 
-	// Delete any existing sessions
-	if _, err := db.DB.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
-		log.Printf("Error deleting existing sessions: %v", err)
+	// With two-factor authentication on, the password only earns a pending
+	// login, which LoginTwoFactorHandler completes given a code. The login
+	// is not recorded as successful until then, so that failed codes keep
+	// counting towards the account's throttling.
+	twoFactor, err := auth.TwoFactorEnabled(userID)
+	if err != nil || twoFactor {
+		cancelLogin(attemptID)
+	}
+	if err != nil {
+		log.Printf("Error checking two-factor authentication: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
 			"message": "Internal server error",
 		})
 		return
 	}
+	if twoFactor {
+		token, expiresAt, err := auth.CreatePendingLogin(userID, loginData.RememberMe)
+		if err != nil {
+			log.Printf("Error creating pending login: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"message": "Internal server error",
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":             false,
+			"two_factor_required": true,
+			"pending_token":       token,
+			"expires_at":          expiresAt,
+		})
+		return
+	}
+
+	finishLogin(attemptID, true, "")
+	startSession(w, r, userID, username, email, loginData.RememberMe)
+}
 
-	// Insert new session
-	if _, err := db.DB.Exec(
-		`INSERT INTO sessions (session_id, user_id, expires_at) VALUES (?, ?, ?)`,
-		sessionID, userID, expiresAt,
-	); err != nil {
+// startSession logs userID in on the device making r and writes the
+// successful login response.
+func startSession(w http.ResponseWriter, r *http.Request, userID int, username, email string, remember bool) {
+	session, err := createLoginSession(w, r, userID, remember)
+	if err != nil {
 		log.Printf("Error creating session: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
@@ -151,25 +195,12 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    sessionID,
-		Expires:  expiresAt,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-	})
-
-	// Update user status to online
-	db.UpdateUserStatus(userID, true)
-
 	// Successful login response
 	response := map[string]interface{}{
 		"success":       true,
 		"authenticated": true,
-		"token":         sessionID,
+		"token":         session.ID,
+		"csrf_token":    session.CSRFToken,
 		"user": map[string]string{
 			"id":       strconv.Itoa(userID),
 			"username": username,
@@ -179,10 +210,62 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// createLoginSession starts a session for userID on the device making r,
+// sends its cookies and marks the user online. Sessions on the user's other
+// devices stay logged in.
+func createLoginSession(w http.ResponseWriter, r *http.Request, userID int, remember bool) (auth.Session, error) {
+	session, err := auth.CreateSession(db.DB, r, userID, remember)
+	if err != nil {
+		return session, err
+	}
+	auth.SetSessionCookie(w, session)
+
+	// Update user status to online
+	db.UpdateUserStatus(userID, true)
+	return session, nil
+}
+
+// writeLoginThrottled tells the client to wait before trying to log in again.
+func writeLoginThrottled(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     false,
+		"message":     "Too many login attempts, please try again later",
+		"retry_after": seconds,
+	})
+}
+
+// recordLogin adds a login attempt to the audit log. A failure to record it
+// is logged rather than failing the login.
+func recordLogin(r *http.Request, userID int, identifier string, success bool, reason string) {
+	if err := auth.RecordLogin(r, userID, identifier, success, reason); err != nil {
+		log.Printf("Error recording login attempt: %v", err)
+	}
+}
+
+// finishLogin records the outcome of an attempt reserved by
+// auth.ReserveLogin, logging a failure to record it like recordLogin.
+func finishLogin(attemptID int64, success bool, reason string) {
+	if err := auth.FinishLogin(attemptID, success, reason); err != nil {
+		log.Printf("Error recording login attempt: %v", err)
+	}
+}
+
+// cancelLogin drops an attempt reserved by auth.ReserveLogin that has no
+// outcome. attemptID is 0 when nothing was reserved.
+func cancelLogin(attemptID int64) {
+	if attemptID == 0 {
+		return
+	}
+	if err := auth.CancelLogin(attemptID); err != nil {
+		log.Printf("Error cancelling login attempt: %v", err)
+	}
+}
+
 func ValidateSessionHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8081")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 	if r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -193,28 +276,19 @@ func ValidateSessionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get session from cookie
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
+	session, ok := auth.CurrentSession(r)
+	if !ok {
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"valid":   false,
-			"message": "No session found",
+			"message": "Invalid session",
 		})
 		return
 	}
+	userID := session.UserID
 
-	// Validate session in database
-	var userID int
 	var username, email string
-	var expiresAt time.Time
-
-	err = db.DB.QueryRow(`
-		SELECT s.user_id, s.expires_at, u.username, u.email
-		FROM sessions s
-		JOIN users u ON s.user_id = u.user_id
-		WHERE s.session_id = ?
-	`, cookie.Value).Scan(&userID, &expiresAt, &username, &email)
+	err := db.DB.QueryRow(`SELECT username, email FROM users WHERE user_id = ?`, userID).Scan(&username, &email)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			w.WriteHeader(http.StatusUnauthorized)
@@ -233,22 +307,10 @@ func ValidateSessionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if session is expired
-	if expiresAt.Before(time.Now()) {
-		// Delete expired session
-		db.DB.Exec(`DELETE FROM sessions WHERE session_id = ?`, cookie.Value)
-
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"valid":   false,
-			"message": "Session expired",
-		})
-		return
-	}
-
 	// Session is valid
 	response := map[string]interface{}{
-		"valid": true,
+		"valid":      true,
+		"csrf_token": session.CSRFToken,
 		"user": map[string]string{
 			"id":       strconv.Itoa(userID),
 			"username": username,
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"real/db"
+	"real/models"
+)
+
+const maxCollectionNameLength = 50
+
+func writeBookmarkError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrCollectionNotFound):
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": "Collection not found"})
+	case errors.Is(err, db.ErrBookmarkNotFound):
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": "Bookmark not found"})
+	case errors.Is(err, db.ErrBookmarkTarget):
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": "The post or comment does not exist"})
+	case errors.Is(err, db.ErrCollectionExists), errors.Is(err, db.ErrBookmarkOrder):
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		log.Printf("Bookmark error: %v", err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+}
+
+// bookmarkTarget returns the post or comment a bookmark request refers to.
+func bookmarkTarget(req models.BookmarkRequest) (string, int, bool) {
+	switch {
+	case req.PostID > 0 && req.CommentID == 0:
+		return models.ReactionTargetPost, req.PostID, true
+	case req.CommentID > 0 && req.PostID == 0:
+		return models.ReactionTargetComment, req.CommentID, true
+	}
+	return "", 0, false
+}
+
+// BookmarkCollectionsHandler lists the session user's collections (GET), or
+// creates one (POST {name}) or renames one (POST {collection_id, name}).
+func BookmarkCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		collections, err := db.GetBookmarkCollections(userID)
+		if err != nil {
+			writeBookmarkError(w, err)
+			return
+		}
+		WriteJSON(w, http.StatusOK, collections)
+
+	case http.MethodPost:
+		var req struct {
+			CollectionID int    `json:"collection_id"`
+			Name         string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || utf8.RuneCountInString(req.Name) > maxCollectionNameLength {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Collection name must be 1-50 characters"})
+			return
+		}
+
+		var err error
+		if req.CollectionID == 0 {
+			req.CollectionID, err = db.CreateBookmarkCollection(userID, req.Name)
+		} else {
+			err = db.RenameBookmarkCollection(userID, req.CollectionID, req.Name)
+		}
+		if err != nil {
+			writeBookmarkError(w, err)
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"success":       true,
+			"collection_id": req.CollectionID,
+			"name":          req.Name,
+		})
+
+	default:
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// DeleteBookmarkCollectionHandler removes a collection and its bookmarks.
+func DeleteBookmarkCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		CollectionID int `json:"collection_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CollectionID <= 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	if err := db.DeleteBookmarkCollection(userID, req.CollectionID); err != nil {
+		writeBookmarkError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true, "collection_id": req.CollectionID})
+}
+
+// AddBookmarkHandler saves a post or comment to a collection, or to the
+// user's default collection when none is given.
+func AddBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.BookmarkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+	targetType, targetID, ok := bookmarkTarget(req)
+	if !ok {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Send either post_id or comment_id"})
+		return
+	}
+
+	bookmarkID, err := db.AddBookmark(userID, req.CollectionID, targetType, targetID)
+	if err != nil {
+		writeBookmarkError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success":     true,
+		"bookmark_id": bookmarkID,
+		"target_type": targetType,
+		"target_id":   targetID,
+	})
+}
+
+// RemoveBookmarkHandler removes one bookmark by bookmark_id, or unsaves a
+// post or comment from all of the user's collections.
+func RemoveBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		models.BookmarkRequest
+		BookmarkID int `json:"bookmark_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	var err error
+	if req.BookmarkID > 0 {
+		err = db.RemoveBookmark(userID, req.BookmarkID)
+	} else if targetType, targetID, ok := bookmarkTarget(req.BookmarkRequest); ok {
+		err = db.RemoveBookmarkTarget(userID, targetType, targetID)
+	} else {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Send bookmark_id, post_id or comment_id"})
+		return
+	}
+	if err != nil {
+		writeBookmarkError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// GetBookmarksHandler lists the items of one of the user's collections.
+func GetBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	collectionID, err := strconv.Atoi(r.URL.Query().Get("collection_id"))
+	if err != nil || collectionID <= 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid collection_id"})
+		return
+	}
+
+	bookmarks, err := db.GetBookmarks(userID, collectionID)
+	if err != nil {
+		writeBookmarkError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, bookmarks)
+}
+
+// ReorderBookmarksHandler reorders the bookmarks of a collection
+// ({collection_id, bookmark_ids}) or the collections themselves
+// ({collection_ids}). Items left out keep their order after the listed ones.
+func ReorderBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		CollectionID  int   `json:"collection_id"`
+		BookmarkIDs   []int `json:"bookmark_ids"`
+		CollectionIDs []int `json:"collection_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	var err error
+	switch {
+	case req.CollectionID > 0:
+		err = db.ReorderBookmarks(userID, req.CollectionID, req.BookmarkIDs)
+	case len(req.CollectionIDs) > 0:
+		err = db.ReorderBookmarkCollections(userID, req.CollectionIDs)
+	default:
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Send collection_id with bookmark_ids, or collection_ids"})
+		return
+	}
+	if err != nil {
+		writeBookmarkError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
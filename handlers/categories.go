@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"real/db"
+	"real/models"
+)
+
+const (
+	maxCategoryNameLength        = 50
+	maxCategoryDescriptionLength = 500
+	maxCategoryIconLength        = 32
+)
+
+var categoryColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+func writeCategoryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrCategoryNotFound):
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": "Category not found"})
+	case errors.Is(err, db.ErrCategoryInUse):
+		WriteJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, db.ErrCategoryExists), errors.Is(err, db.ErrCategoryParent):
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		log.Printf("Category error: %v", err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+}
+
+// validateCategory normalises c and returns the problems with it by field.
+func validateCategory(c *models.Category) map[string]string {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Description = strings.TrimSpace(c.Description)
+	c.Color = strings.ToLower(strings.TrimSpace(c.Color))
+	c.Icon = strings.TrimSpace(c.Icon)
+	if c.ParentID != nil && *c.ParentID <= 0 {
+		c.ParentID = nil
+	}
+
+	errs := make(map[string]string)
+	if c.Name == "" || utf8.RuneCountInString(c.Name) > maxCategoryNameLength {
+		errs["name"] = "Name must be 1-50 characters"
+	}
+	if utf8.RuneCountInString(c.Description) > maxCategoryDescriptionLength {
+		errs["description"] = "Description must be at most 500 characters"
+	}
+	if c.Color != "" && !categoryColorPattern.MatchString(c.Color) {
+		errs["color"] = "Color must be a hex value like #3366ff"
+	}
+	if utf8.RuneCountInString(c.Icon) > maxCategoryIconLength {
+		errs["icon"] = "Icon must be at most 32 characters"
+	}
+	return errs
+}
+
+// CategoriesHandler lists categories with their post counts (GET), or lets an
+// admin create or update one (POST). Admins can pass ?all=true to include
+// archived categories. A POST with a category_id only changes the fields it
+// sends; "parent_id": null moves the category to the top level.
+func CategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		includeArchived := false
+		if r.URL.Query().Get("all") == "true" {
+			if _, ok := requireAdmin(w, r); !ok {
+				return
+			}
+			includeArchived = true
+		}
+
+		categories, err := db.GetAllCategories(includeArchived)
+		if err != nil {
+			log.Printf("Error fetching categories: %v", err)
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to fetch categories"})
+			return
+		}
+		WriteJSON(w, http.StatusOK, categories)
+
+	case http.MethodPost:
+		if _, ok := requireAdmin(w, r); !ok {
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+			return
+		}
+		var req models.Category
+		if err := json.Unmarshal(body, &req); err != nil {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+			return
+		}
+
+		// Updates start from the stored category so omitted fields keep their value
+		category := req
+		if req.CategoryID != 0 {
+			if category, err = db.GetCategory(req.CategoryID); err != nil {
+				writeCategoryError(w, err)
+				return
+			}
+			if err := json.Unmarshal(body, &category); err != nil {
+				WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+				return
+			}
+		}
+
+		if errs := validateCategory(&category); len(errs) > 0 {
+			WriteJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": errs})
+			return
+		}
+
+		if category.CategoryID == 0 {
+			category.CategoryID, err = db.CreateCategory(category)
+		} else {
+			err = db.UpdateCategory(category)
+		}
+		if err != nil {
+			writeCategoryError(w, err)
+			return
+		}
+
+		saved, err := db.GetCategory(category.CategoryID)
+		if err != nil {
+			writeCategoryError(w, err)
+			return
+		}
+		WriteJSON(w, http.StatusOK, saved)
+
+	default:
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// DeleteCategoryHandler lets an admin remove a category. Categories with
+// posts or subcategories are refused with a 409; archive those instead.
+func DeleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	var req struct {
+		CategoryID int `json:"category_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CategoryID <= 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	if err := db.DeleteCategory(req.CategoryID); err != nil {
+		writeCategoryError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true, "category_id": req.CategoryID})
+}
+
+// ReorderCategoriesHandler lets an admin set the display order. Categories
+// left out keep their relative order after the listed ones.
+func ReorderCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	var req struct {
+		CategoryIDs []int `json:"category_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	if err := db.ReorderCategories(req.CategoryIDs); err != nil {
+		writeCategoryError(w, err)
+		return
+	}
+	categories, err := db.GetAllCategories(true)
+	if err != nil {
+		writeCategoryError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, categories)
+}
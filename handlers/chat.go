@@ -5,8 +5,8 @@ import (
 	"log"
 	"net/http"
 	"strconv"
-	"time"
 
+	"real/auth"
 	"real/db"
 
 	"github.com/gorilla/websocket"
@@ -17,35 +17,25 @@ import (
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+	// Only pages of this site and the allowed origins may open a connection
+	// with the user's cookie
+	CheckOrigin: auth.OriginAllowed,
 }
 
 func ServeWs(hub *rt_hub.Hub, w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
-
+	session, ok := auth.CurrentSession(r)
+	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	sessionID := cookie.Value
-
-    var userID int
-    var expiresAt time.Time
-    err = db.DB.QueryRow("SELECT user_id, expires_at FROM sessions WHERE session_id = ?", sessionID).Scan(&userID, &expiresAt)
-    if err != nil || expiresAt.Before(time.Now()) {
-        
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
-        return
-    }
-
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
 		return
 	}
 
-	client := &rt_hub.Client{Hub: hub, Conn: conn, Send: make(chan []byte, 256), UserID: userID}
+	client := &rt_hub.Client{Hub: hub, Conn: conn, Send: make(chan []byte, 256), UserID: session.UserID, SessionID: session.ID}
 	client.Hub.Register <- client
 
 	go client.WritePump()
@@ -54,9 +44,8 @@ func ServeWs(hub *rt_hub.Hub, w http.ResponseWriter, r *http.Request) {
 
 // HandleGetUsers returns a list of all users to chat with.
 func HandleGetUsers(w http.ResponseWriter, r *http.Request) {
-	currentUserID, err := db.GetCurrentUserIDFromSession(r)
-	if err != nil {
-
+	currentUserID := auth.GetCurrentUserID(r)
+	if currentUserID == 0 {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -78,8 +67,8 @@ func HandleGetUsers(w http.ResponseWriter, r *http.Request) {
 
 // HandleGetMessages returns historical messages between two users.
 func HandleGetMessages(w http.ResponseWriter, r *http.Request) {
-	currentUserID, err := db.GetCurrentUserIDFromSession(r)
-	if err != nil {
+	currentUserID := auth.GetCurrentUserID(r)
+	if currentUserID == 0 {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -110,8 +99,8 @@ func HandleGetMessages(w http.ResponseWriter, r *http.Request) {
 
 // HandleGetOnlineUsers returns a list of currently online users
 func HandleGetOnlineUsers(w http.ResponseWriter, r *http.Request) {
-	currentUserID, err := db.GetCurrentUserIDFromSession(r)
-	if err != nil {
+	currentUserID := auth.GetCurrentUserID(r)
+	if currentUserID == 0 {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"real/auth"
+	"real/config"
 	"real/db"
+	"real/models"
 	"strconv"
 	"time"
     "database/sql"
@@ -24,28 +27,47 @@ type Comment struct {
     Likes        int       `json:"likes"`
     Dislikes     int       `json:"dislikes"`
     UserReaction string    `json:"user_reaction"`
+    ReactionCounts map[string]int `json:"reaction_counts"`
+
+    // Threading
+    ParentCommentID *int       `json:"parent_comment_id"`
+    Depth           int        `json:"depth"`
+    ReplyCount      int        `json:"reply_count"`   // direct replies
+    TotalReplies    int        `json:"total_replies"` // all descendants
+    Collapsed       bool       `json:"collapsed"`     // replies exist but were not included
+    Replies         []*Comment `json:"replies"`
+
+    // Editing and moderation
+    Edited    bool       `json:"edited"`
+    EditedAt  *time.Time `json:"edited_at,omitempty"`
+    Deleted   bool       `json:"deleted"`
+    CanEdit   bool       `json:"can_edit"`
+    CanDelete bool       `json:"can_delete"`
 }
 
-// Comment reaction request struct
-type CommentReactionRequest struct {
-    UserID    int    `json:"user_id,string"`    
-    CommentID int    `json:"comment_id,string"` 
-    LikeType  string `json:"like_type"`
-}
-type CommentReactionRequestAlt struct {
-    UserIDStr    string `json:"user_id"`
-    CommentIDStr string `json:"comment_id"`
-    LikeType     string `json:"like_type"`
-}
+const (
+    // maxCommentDepth is the deepest level a reply may be nested at;
+    // top-level comments are depth 0.
+    maxCommentDepth = 6
+    // defaultCollapseDepth is how many levels GetCommentsHandler returns
+    // before collapsing a thread.
+    defaultCollapseDepth = 3
+)
 
-// Helper method to convert to integers
-func (r *CommentReactionRequestAlt) GetUserID() (int, error) {
-    return strconv.Atoi(r.UserIDStr)
-}
+var (
+    errParentNotFound = errors.New("parent comment not found")
+    errParentMismatch = errors.New("parent comment belongs to a different post")
+    errCommentTooDeep = errors.New("reply nesting limit reached")
+    errParentDeleted  = errors.New("cannot reply to a deleted comment")
+)
 
-func (r *CommentReactionRequestAlt) GetCommentID() (int, error) {
-    return strconv.Atoi(r.CommentIDStr)
-}
+// deletedCommentText replaces the content and author of tombstoned comments
+// so their replies stay in place.
+const deletedCommentText = "[deleted]"
+
+// commentEditWindow limits how long after posting authors may edit their
+// comments. Zero disables the limit; moderators are never limited.
+var commentEditWindow = config.Duration("COMMENT_EDIT_WINDOW", 15*time.Minute)
 
 func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "application/json")
@@ -57,12 +79,15 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
     }
 
     // Get user ID from context or session
-    userIDStr, ok := auth.GetUserID(r)
-    if !ok || userIDStr == "" {
+    userID := auth.GetCurrentUserID(r)
+    if userID == 0 {
         w.WriteHeader(http.StatusUnauthorized)
         json.NewEncoder(w).Encode(map[string]string{"error": "You must be logged in to comment"})
         return
     }
+    if !requireCanPost(w, userID) {
+        return
+    }
 
     // Parse form data
     if err := r.ParseForm(); err != nil {
@@ -86,67 +111,26 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Convert userID to int
-    userID, err := strconv.Atoi(userIDStr)
-    if err != nil {
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Invalid user session data"})
-        return
-    }
-
-    // Insert comment
-    result, err := db.DB.Exec(
-        "INSERT INTO comments (post_id, user_id, content) VALUES (?, ?, ?)", 
-        postID, userID, content,
-    )
-    if err != nil {
-        log.Printf("Database error inserting comment: %v", err)
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save comment"})
-        return
+    // Optional parent for threaded replies
+    var parentID *int
+    if parentStr := r.FormValue("parent_comment_id"); parentStr != "" {
+        pid, err := strconv.Atoi(parentStr)
+        if err != nil {
+            w.WriteHeader(http.StatusBadRequest)
+            json.NewEncoder(w).Encode(map[string]string{"error": "Invalid parent comment ID"})
+            return
+        }
+        parentID = &pid
     }
 
-    // Get the new comment ID
-    commentID, err := result.LastInsertId()
+    commentID, err := insertComment(postID, userID, parentID, content)
     if err != nil {
-        
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to get comment ID"})
+        writeCommentInsertError(w, err)
         return
     }
 
     // Retrieve the full comment data
-    var comment Comment
-    err = db.DB.QueryRow(`
-        SELECT 
-            c.comment_id, 
-            c.post_id, 
-            c.user_id, 
-            c.content, 
-            c.created_at,
-            u.username,
-            COALESCE(u.first_name, '') as first_name,
-            COALESCE(u.last_name, '') as last_name,
-            0 as likes,
-            0 as dislikes,
-            '' as user_reaction
-        FROM comments c
-        JOIN users u ON c.user_id = u.user_id
-        WHERE c.comment_id = ?
-    `, commentID).Scan(
-        &comment.CommentID,
-        &comment.PostID,
-        &comment.UserID,
-        &comment.Content,
-        &comment.CreatedAt,
-        &comment.Author,
-        &comment.FirstName,
-        &comment.LastName,
-        &comment.Likes,
-        &comment.Dislikes,
-        &comment.UserReaction,
-    )
-
+    comment, err := fetchComment(commentID, userID)
     if err != nil {
         log.Printf("Database error fetching comment: %v", err)
         w.WriteHeader(http.StatusInternalServerError)
@@ -159,7 +143,9 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(comment)
 }
 
-func CommentReactionHandler(w http.ResponseWriter, r *http.Request) {
+// ReplyCommentHandler creates a reply to an existing comment. The post is
+// taken from the parent so clients only need to send comment_id and content.
+func ReplyCommentHandler(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "application/json")
 
     if r.Method != http.MethodPost {
@@ -168,126 +154,384 @@ func CommentReactionHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Parse request body
-    var req CommentReactionRequest
-    
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    userID := auth.GetCurrentUserID(r)
+    if userID == 0 {
+        w.WriteHeader(http.StatusUnauthorized)
+        json.NewEncoder(w).Encode(map[string]string{"error": "You must be logged in to reply"})
+        return
+    }
+    if !requireCanPost(w, userID) {
+        return
+    }
+
+    if err := r.ParseForm(); err != nil {
         w.WriteHeader(http.StatusBadRequest)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request data"})
+        json.NewEncoder(w).Encode(map[string]string{"error": "Cannot parse form"})
         return
     }
 
-   
+    parentID, err := strconv.Atoi(r.FormValue("comment_id"))
+    if err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Invalid comment ID"})
+        return
+    }
 
-    // Validate input
-    if req.UserID <= 0 || req.CommentID <= 0 {
+    content := r.FormValue("content")
+    if content == "" {
         w.WriteHeader(http.StatusBadRequest)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Invalid user or comment ID"})
+        json.NewEncoder(w).Encode(map[string]string{"error": "Content cannot be empty"})
+        return
+    }
+
+    var postID int
+    err = db.DB.QueryRow("SELECT post_id FROM comments WHERE comment_id = ?", parentID).Scan(&postID)
+    if err == sql.ErrNoRows {
+        writeCommentInsertError(w, errParentNotFound)
+        return
+    } else if err != nil {
+        log.Printf("Database error fetching parent comment: %v", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save reply"})
+        return
+    }
+
+    commentID, err := insertComment(postID, userID, &parentID, content)
+    if err != nil {
+        writeCommentInsertError(w, err)
         return
     }
 
-    if req.LikeType != "like" && req.LikeType != "dislike" {
+    comment, err := fetchComment(commentID, userID)
+    if err != nil {
+        log.Printf("Database error fetching reply: %v", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Reply created but could not retrieve details"})
+        return
+    }
+
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(comment)
+}
+
+// EditCommentHandler lets the author change a comment within
+// commentEditWindow of posting. Moderators may edit any live comment.
+func EditCommentHandler(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+
+    userID, commentID, ok := parseCommentAction(w, r, "edit")
+    if !ok {
+        return
+    }
+
+    content := r.FormValue("content")
+    if content == "" {
         w.WriteHeader(http.StatusBadRequest)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Invalid reaction type"})
+        json.NewEncoder(w).Encode(map[string]string{"error": "Content cannot be empty"})
         return
     }
 
-    // Check if reaction exists
-    var existingReaction string
-    err := db.DB.QueryRow(`
-        SELECT reaction_type FROM comment_reactions 
-        WHERE user_id = ? AND comment_id = ?
-    `, req.UserID, req.CommentID).Scan(&existingReaction)
-
-    // Handle reaction based on current state
-    var query string
-    var args []interface{}
-    
+    owner, ok := loadCommentOwner(w, commentID)
+    if !ok {
+        return
+    }
+    if owner.deleted {
+        w.WriteHeader(http.StatusConflict)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Deleted comments cannot be edited"})
+        return
+    }
+
+    if !db.IsModerator(userID) {
+        if owner.userID != userID {
+            w.WriteHeader(http.StatusForbidden)
+            json.NewEncoder(w).Encode(map[string]string{"error": "You can only edit your own comments"})
+            return
+        }
+        if !withinEditWindow(owner.createdAt) {
+            w.WriteHeader(http.StatusForbidden)
+            json.NewEncoder(w).Encode(map[string]string{"error": "The edit window for this comment has expired"})
+            return
+        }
+    }
+
+    now := time.Now()
+    if _, err := db.DB.Exec(
+        "UPDATE comments SET content = ?, edited_at = ?, updated_at = ? WHERE comment_id = ?",
+        content, now, now, commentID,
+    ); err != nil {
+        log.Printf("Database error editing comment: %v", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to edit comment"})
+        return
+    }
+
+    writeCommentResponse(w, http.StatusOK, int64(commentID), userID)
+}
+
+// DeleteCommentHandler tombstones a comment: the row is kept so replies stay
+// attached, but its content is cleared and it is shown as "[deleted]".
+// Authors may delete their own comments; moderators may delete any.
+func DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+
+    userID, commentID, ok := parseCommentAction(w, r, "delete")
+    if !ok {
+        return
+    }
+
+    owner, ok := loadCommentOwner(w, commentID)
+    if !ok {
+        return
+    }
+
+    if owner.userID != userID && !db.IsModerator(userID) {
+        w.WriteHeader(http.StatusForbidden)
+        json.NewEncoder(w).Encode(map[string]string{"error": "You can only delete your own comments"})
+        return
+    }
+
+    if !owner.deleted {
+        now := time.Now()
+        if _, err := db.DB.Exec(
+            "UPDATE comments SET content = '', deleted_at = ?, deleted_by = ?, updated_at = ? WHERE comment_id = ?",
+            now, userID, now, commentID,
+        ); err != nil {
+            log.Printf("Database error deleting comment: %v", err)
+            w.WriteHeader(http.StatusInternalServerError)
+            json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete comment"})
+            return
+        }
+    }
+
+    writeCommentResponse(w, http.StatusOK, int64(commentID), userID)
+}
+
+// parseCommentAction authenticates the request and reads comment_id from the
+// form, writing the error response itself when something is missing.
+func parseCommentAction(w http.ResponseWriter, r *http.Request, action string) (userID, commentID int, ok bool) {
+    if r.Method != http.MethodPost {
+        w.WriteHeader(http.StatusMethodNotAllowed)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request method"})
+        return 0, 0, false
+    }
+
+    userID = auth.GetCurrentUserID(r)
+    if userID == 0 {
+        w.WriteHeader(http.StatusUnauthorized)
+        json.NewEncoder(w).Encode(map[string]string{"error": "You must be logged in to " + action + " comments"})
+        return 0, 0, false
+    }
+
+    if err := r.ParseForm(); err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Cannot parse form"})
+        return 0, 0, false
+    }
+
+    commentID, err := strconv.Atoi(r.FormValue("comment_id"))
+    if err != nil || commentID <= 0 {
+        w.WriteHeader(http.StatusBadRequest)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Invalid comment ID"})
+        return 0, 0, false
+    }
+
+    return userID, commentID, true
+}
+
+type commentOwner struct {
+    userID    int
+    createdAt time.Time
+    deleted   bool
+}
+
+func loadCommentOwner(w http.ResponseWriter, commentID int) (commentOwner, bool) {
+    var owner commentOwner
+    err := db.DB.QueryRow(
+        "SELECT user_id, created_at, deleted_at IS NOT NULL FROM comments WHERE comment_id = ?", commentID,
+    ).Scan(&owner.userID, &owner.createdAt, &owner.deleted)
     if err == sql.ErrNoRows {
-        // New reaction - insert
-        query = `INSERT INTO comment_reactions (user_id, comment_id, reaction_type) VALUES (?, ?, ?)`
-        args = []interface{}{req.UserID, req.CommentID, req.LikeType}
-        
-    } else if err == nil && existingReaction == req.LikeType {
-        // Same reaction - remove it (toggle off)
-        query = `DELETE FROM comment_reactions WHERE user_id = ? AND comment_id = ?`
-        args = []interface{}{req.UserID, req.CommentID}
-        
-    } else if err == nil {
-        // Different reaction - update it
-        query = `UPDATE comment_reactions SET reaction_type = ? WHERE user_id = ? AND comment_id = ?`
-        args = []interface{}{req.LikeType, req.UserID, req.CommentID}
-        
-    } else {
-        // Database error
-        log.Printf("Database error checking existing reaction: %v", err)
+        w.WriteHeader(http.StatusNotFound)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Comment not found"})
+        return owner, false
+    } else if err != nil {
+        log.Printf("Database error loading comment %d: %v", commentID, err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+        return owner, false
+    }
+    return owner, true
+}
+
+func writeCommentResponse(w http.ResponseWriter, status int, commentID int64, viewerID int) {
+    comment, err := fetchComment(commentID, viewerID)
+    if err != nil {
+        log.Printf("Database error fetching comment: %v", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Could not retrieve comment details"})
         return
     }
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(comment)
+}
 
-    // Execute the query
-    _, err = db.DB.Exec(query, args...)
+// insertComment stores a comment on postID, which must not be locked. When
+// parentID is set the parent must belong to the same post and the reply must
+// stay within maxCommentDepth.
+func insertComment(postID, userID int, parentID *int, content string) (int64, error) {
+    locked, err := db.IsPostLocked(postID)
     if err != nil {
-        log.Printf("Database error executing reaction query: %v", err)
+        return 0, err
+    }
+    if locked {
+        return 0, db.ErrPostLocked
+    }
+
+    depth := 0
+    if parentID != nil {
+        var parentPostID, parentDepth int
+        var parentDeleted bool
+        err := db.DB.QueryRow(
+            "SELECT post_id, depth, deleted_at IS NOT NULL FROM comments WHERE comment_id = ?", *parentID,
+        ).Scan(&parentPostID, &parentDepth, &parentDeleted)
+        if err == sql.ErrNoRows {
+            return 0, errParentNotFound
+        } else if err != nil {
+            return 0, err
+        }
+        if parentPostID != postID {
+            return 0, errParentMismatch
+        }
+        if parentDeleted {
+            return 0, errParentDeleted
+        }
+        if parentDepth >= maxCommentDepth {
+            return 0, errCommentTooDeep
+        }
+        depth = parentDepth + 1
+    }
+
+    result, err := db.DB.Exec(
+        "INSERT INTO comments (post_id, user_id, parent_comment_id, depth, content) VALUES (?, ?, ?, ?, ?)",
+        postID, userID, parentID, depth, content,
+    )
+    if err != nil {
+        return 0, err
+    }
+    return result.LastInsertId()
+}
+
+func writeCommentInsertError(w http.ResponseWriter, err error) {
+    switch err {
+    case errParentNotFound, db.ErrPostNotFound:
+        w.WriteHeader(http.StatusNotFound)
+    case db.ErrPostLocked:
+        w.WriteHeader(http.StatusForbidden)
+    case errParentMismatch, errCommentTooDeep, errParentDeleted:
+        w.WriteHeader(http.StatusBadRequest)
+    default:
+        log.Printf("Database error inserting comment: %v", err)
         w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to process reaction"})
+        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save comment"})
         return
     }
+    json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
 
-    // Get updated counts and user's current reaction
-    var likes, dislikes int
-    var userReaction sql.NullString
-    
-    err = db.DB.QueryRow(`
+// fetchComment loads a comment with its author details and the permissions
+// viewerID has on it.
+func fetchComment(commentID int64, viewerID int) (Comment, error) {
+    var comment Comment
+    var parentID sql.NullInt64
+    var editedAt, deletedAt sql.NullTime
+    err := db.DB.QueryRow(`
         SELECT 
-            COALESCE(SUM(CASE WHEN reaction_type = 'like' THEN 1 ELSE 0 END), 0) as likes,
-            COALESCE(SUM(CASE WHEN reaction_type = 'dislike' THEN 1 ELSE 0 END), 0) as dislikes
-        FROM comment_reactions
-        WHERE comment_id = ?
-        GROUP BY comment_id
-        UNION ALL
-        SELECT 0, 0
-        WHERE NOT EXISTS (SELECT 1 FROM comment_reactions WHERE comment_id = ?)
-        LIMIT 1
-    `, req.CommentID, req.CommentID).Scan(&likes, &dislikes)
+            c.comment_id, 
+            c.post_id, 
+            c.user_id, 
+            c.content, 
+            c.created_at,
+            u.username,
+            COALESCE(u.first_name, '') as first_name,
+            COALESCE(u.last_name, '') as last_name,
+            (SELECT COUNT(*) FROM reactions WHERE target_type = 'comment' AND target_id = c.comment_id AND reaction_type = 'like') as likes,
+            (SELECT COUNT(*) FROM reactions WHERE target_type = 'comment' AND target_id = c.comment_id AND reaction_type = 'dislike') as dislikes,
+            COALESCE((SELECT reaction_type FROM reactions WHERE target_type = 'comment' AND target_id = c.comment_id AND user_id = ?), '') as user_reaction,
+            c.parent_comment_id,
+            c.depth,
+            c.edited_at,
+            c.deleted_at
+        FROM comments c
+        JOIN users u ON c.user_id = u.user_id
+        WHERE c.comment_id = ?
+    `, viewerID, commentID).Scan(
+        &comment.CommentID,
+        &comment.PostID,
+        &comment.UserID,
+        &comment.Content,
+        &comment.CreatedAt,
+        &comment.Author,
+        &comment.FirstName,
+        &comment.LastName,
+        &comment.Likes,
+        &comment.Dislikes,
+        &comment.UserReaction,
+        &parentID,
+        &comment.Depth,
+        &editedAt,
+        &deletedAt,
+    )
+    if err != nil {
+        return comment, err
+    }
+    if parentID.Valid {
+        pid := int(parentID.Int64)
+        comment.ParentCommentID = &pid
+    }
+    applyCommentState(&comment, editedAt, deletedAt, viewerID, db.IsModerator(viewerID))
+    comment.Replies = []*Comment{}
 
+    counts, err := db.ReactionCounts(db.DB, models.ReactionTargetComment, []int{comment.CommentID})
     if err != nil {
-        
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to get reaction counts"})
+        return comment, err
+    }
+    comment.ReactionCounts = counts[comment.CommentID]
+    if comment.ReactionCounts == nil {
+        comment.ReactionCounts = map[string]int{}
+    }
+    return comment, nil
+}
+
+// applyCommentState sets the edited/deleted markers, tombstones deleted
+// comments and works out what the viewer is allowed to do with the comment.
+func applyCommentState(c *Comment, editedAt, deletedAt sql.NullTime, viewerID int, isModerator bool) {
+    if editedAt.Valid {
+        c.Edited = true
+        t := editedAt.Time
+        c.EditedAt = &t
+    }
+
+    if deletedAt.Valid {
+        c.Deleted = true
+        c.Content = deletedCommentText
+        c.Author = deletedCommentText
+        c.FirstName = ""
+        c.LastName = ""
+        c.UserID = 0
         return
     }
 
-    // Get user's current reaction
-    err = db.DB.QueryRow(`
-        SELECT reaction_type FROM comment_reactions 
-        WHERE user_id = ? AND comment_id = ?
-    `, req.UserID, req.CommentID).Scan(&userReaction)
+    isAuthor := viewerID != 0 && viewerID == c.UserID
+    c.CanDelete = isAuthor || isModerator
+    c.CanEdit = isModerator || (isAuthor && withinEditWindow(c.CreatedAt))
+}
 
-    var userReactionStr string
-    if err == sql.ErrNoRows {
-        userReactionStr = ""
-    } else if err != nil {
-        log.Printf("Error getting user reaction: %v", err)
-        userReactionStr = ""
-    } else {
-        userReactionStr = userReaction.String
-    }
-
-    // Return response
-    response := map[string]interface{}{
-        "success":      true,
-        "likes":        likes,
-        "dislikes":     dislikes,
-        "userReaction": userReactionStr,
-    }
-    
- 
-    json.NewEncoder(w).Encode(response)
+func withinEditWindow(createdAt time.Time) bool {
+    return commentEditWindow <= 0 || time.Since(createdAt) <= commentEditWindow
 }
-// In handlers/comment_handler.go, add this new function
 
+// GetCommentsHandler returns the comments of a post as a tree. Replies more
+// than collapse_depth levels below the returned root are left out and their
+// parent is marked collapsed; clients expand them by requesting parent_id.
 func GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "application/json")
 
@@ -303,7 +547,26 @@ func GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    userID, _ := db.GetCurrentUserIDFromSession(r)
+    // Optional subtree root, used to expand a collapsed thread
+    rootID := 0
+    if parentStr := r.URL.Query().Get("parent_id"); parentStr != "" {
+        rootID, err = strconv.Atoi(parentStr)
+        if err != nil || rootID <= 0 {
+            http.Error(w, `{"error": "Invalid parent_id"}`, http.StatusBadRequest)
+            return
+        }
+    }
+
+    collapseDepth := defaultCollapseDepth
+    if depthStr := r.URL.Query().Get("collapse_depth"); depthStr != "" {
+        collapseDepth, err = strconv.Atoi(depthStr)
+        if err != nil || collapseDepth < 1 {
+            http.Error(w, `{"error": "Invalid collapse_depth"}`, http.StatusBadRequest)
+            return
+        }
+    }
+
+    userID := auth.GetCurrentUserID(r)
 
    
     query := `
@@ -316,13 +579,17 @@ func GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
             u.username as author,
             COALESCE(u.first_name, '') as first_name,
             COALESCE(u.last_name, '') as last_name,
-            (SELECT COUNT(*) FROM comment_reactions WHERE comment_id = c.comment_id AND reaction_type = 'like') as likes,
-            (SELECT COUNT(*) FROM comment_reactions WHERE comment_id = c.comment_id AND reaction_type = 'dislike') as dislikes,
-            COALESCE((SELECT reaction_type FROM comment_reactions WHERE comment_id = c.comment_id AND user_id = ?), '') as user_reaction
+            (SELECT COUNT(*) FROM reactions WHERE target_type = 'comment' AND target_id = c.comment_id AND reaction_type = 'like') as likes,
+            (SELECT COUNT(*) FROM reactions WHERE target_type = 'comment' AND target_id = c.comment_id AND reaction_type = 'dislike') as dislikes,
+            COALESCE((SELECT reaction_type FROM reactions WHERE target_type = 'comment' AND target_id = c.comment_id AND user_id = ?), '') as user_reaction,
+            c.parent_comment_id,
+            c.depth,
+            c.edited_at,
+            c.deleted_at
         FROM comments c
         JOIN users u ON c.user_id = u.user_id
         WHERE c.post_id = ?
-        ORDER BY c.created_at ASC
+        ORDER BY c.created_at ASC, c.comment_id ASC
     `
 
     rows, err := db.DB.Query(query, userID, postID)
@@ -333,18 +600,28 @@ func GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
     }
     defer rows.Close()
 
-    var comments []Comment
+    isModerator := db.IsModerator(userID)
+
+    var comments []*Comment
     for rows.Next() {
-        var comment Comment
+        comment := &Comment{}
+        var parentID sql.NullInt64
+        var editedAt, deletedAt sql.NullTime
         err := rows.Scan(
             &comment.CommentID, &comment.PostID, &comment.UserID, &comment.Content, &comment.CreatedAt,
             &comment.Author, &comment.FirstName, &comment.LastName,
             &comment.Likes, &comment.Dislikes, &comment.UserReaction,
+            &parentID, &comment.Depth, &editedAt, &deletedAt,
         )
         if err != nil {
             log.Printf("Error scanning comment row: %v", err)
             continue
         }
+        if parentID.Valid {
+            pid := int(parentID.Int64)
+            comment.ParentCommentID = &pid
+        }
+        applyCommentState(comment, editedAt, deletedAt, userID, isModerator)
         comments = append(comments, comment)
     }
 
@@ -354,5 +631,73 @@ func GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    json.NewEncoder(w).Encode(comments)
-}
\ No newline at end of file
+    commentIDs := make([]int, len(comments))
+    for i, c := range comments {
+        commentIDs[i] = c.CommentID
+    }
+    counts, err := db.ReactionCounts(db.DB, models.ReactionTargetComment, commentIDs)
+    if err != nil {
+        log.Printf("Database error counting comment reactions: %v", err)
+        http.Error(w, `{"error": "Failed to fetch comments"}`, http.StatusInternalServerError)
+        return
+    }
+    for _, c := range comments {
+        c.ReactionCounts = counts[c.CommentID]
+        if c.ReactionCounts == nil {
+            c.ReactionCounts = map[string]int{}
+        }
+    }
+
+    tree, found := buildCommentTree(comments, rootID, collapseDepth)
+    if !found {
+        http.Error(w, `{"error": "Comment not found"}`, http.StatusNotFound)
+        return
+    }
+
+    json.NewEncoder(w).Encode(tree)
+}
+
+// buildCommentTree nests comments under their parents and returns the replies
+// of rootID (0 for top-level comments). Reply counts are filled in for every
+// node; children more than collapseDepth levels below the root are dropped
+// and their parent marked Collapsed. found is false when rootID is not a
+// comment of this post.
+func buildCommentTree(comments []*Comment, rootID, collapseDepth int) (roots []*Comment, found bool) {
+    children := make(map[int][]*Comment)
+    found = rootID == 0
+    for _, c := range comments {
+        parent := 0
+        if c.ParentCommentID != nil {
+            parent = *c.ParentCommentID
+        }
+        children[parent] = append(children[parent], c)
+        if c.CommentID == rootID {
+            found = true
+        }
+    }
+
+    var attach func(c *Comment, level int) int
+    attach = func(c *Comment, level int) int {
+        replies := children[c.CommentID]
+        c.ReplyCount = len(replies)
+        c.TotalReplies = len(replies)
+        for _, reply := range replies {
+            c.TotalReplies += attach(reply, level+1)
+        }
+        if len(replies) > 0 && level+1 >= collapseDepth {
+            c.Collapsed = true
+            c.Replies = []*Comment{}
+        } else if len(replies) > 0 {
+            c.Replies = replies
+        } else {
+            c.Replies = []*Comment{}
+        }
+        return c.TotalReplies
+    }
+
+    roots = children[rootID]
+    for _, c := range roots {
+        attach(c, 0)
+    }
+    return roots, found
+}
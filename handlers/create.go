@@ -8,50 +8,89 @@ import (
 	"net/http"
 	"real/auth"
 	"real/db"
-	
+	"real/models"
+	"real/storage"
+	"strconv"
+	"strings"
+
 )
 
+// postListQuery selects posts in the shape the frontend renders. Its first two
+// placeholders take the viewer's user ID, for their reaction and bookmark, and
+// the third the category whose pinned posts come first. queryPosts completes
+// it with filters and ordering.
+const postListQuery = `
+	SELECT 
+		p.post_id,
+		p.title,
+		p.content,
+		IFNULL(p.imgurl, '') as image_url, 
+		p.created_at,
+		p.user_id,
+		u.username,
+		u.first_name,
+		u.last_name,
+		GROUP_CONCAT(c.name) as categories,
+		(SELECT GROUP_CONCAT(t.name) FROM post_tags pt JOIN tags t ON t.tag_id = pt.tag_id WHERE pt.post_id = p.post_id) as tags,
+		(SELECT COUNT(*) FROM reactions WHERE target_type = 'post' AND target_id = p.post_id AND reaction_type = 'like') as like_count,
+		(SELECT COUNT(*) FROM reactions WHERE target_type = 'post' AND target_id = p.post_id AND reaction_type = 'dislike') as dislike_count,
+		COALESCE((SELECT reaction_type FROM reactions WHERE target_type = 'post' AND target_id = p.post_id AND user_id = ?), '') as user_reaction,
+		(SELECT COUNT(*) FROM comments WHERE post_id = p.post_id) as comment_count,
+		(SELECT poll_id FROM polls WHERE post_id = p.post_id) as poll_id,
+		EXISTS (SELECT 1 FROM bookmarks WHERE target_type = 'post' AND target_id = p.post_id AND user_id = ?) as saved,
+		EXISTS (SELECT 1 FROM post_pins WHERE post_id = p.post_id AND category_id = ?) as pinned,
+		p.locked_at IS NOT NULL as locked,
+		p.announced_at IS NOT NULL as announcement
+	FROM posts p
+	JOIN users u ON p.user_id = u.user_id
+	LEFT JOIN post_categories pc ON p.post_id = pc.post_id
+	LEFT JOIN categories c ON pc.category_id = c.category_id
+`
+
+// noPins is the pin category of post lists that are never reordered by pins,
+// since no post is pinned in it.
+const noPins = -1
+
 func GetPostsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	category := r.URL.Query().Get("category")
 	myPostsOnly := r.URL.Query().Get("my_posts_only") == "true"
 	likedPostsOnly := r.URL.Query().Get("liked_posts_only") == "true"
+	savedOnly := r.URL.Query().Get("saved_only") == "true"
+
 
-	// Build base query
-	query := `
-		SELECT 
-			p.post_id,
-			p.title,
-			p.content,
-			IFNULL(p.imgurl, '') as image_url, 
-			p.created_at,
-			p.user_id,
-			u.username,
-			u.first_name,
-			u.last_name,
-			GROUP_CONCAT(c.name) as categories,
-			(SELECT COUNT(*) FROM likes WHERE post_id = p.post_id) as like_count,
-			(SELECT COUNT(*) FROM comments WHERE post_id = p.post_id) as comment_count
-		FROM posts p
-		JOIN users u ON p.user_id = u.user_id
-		LEFT JOIN post_categories pc ON p.post_id = pc.post_id
-		LEFT JOIN categories c ON pc.category_id = c.category_id
-	`
+	// The viewer's own reaction and bookmark are included when they are logged in
+	viewerID := auth.GetCurrentUserID(r)
 
 	// Add filters
+	var joins string
 	var args []interface{}
+	// A category includes the posts of its subcategories
 	if category != "" {
-		query += " JOIN categories cat ON cat.category_id = pc.category_id AND cat.name = ?"
+		joins += ` JOIN categories cat ON cat.category_id = pc.category_id AND cat.category_id IN (
+			WITH RECURSIVE subtree(category_id) AS (
+				SELECT category_id FROM categories WHERE name = ?
+				UNION
+				SELECT c.category_id FROM categories c JOIN subtree ON c.parent_id = subtree.category_id
+			)
+			SELECT category_id FROM subtree)`
 		args = append(args, category)
 	}
 
+	var conditions []string
+	// Posts with all of the given tags, or any of them with tag_match=any
+	if condition, tagArgs := tagFilter(r.URL.Query()); condition != "" {
+		conditions = append(conditions, condition)
+		args = append(args, tagArgs...)
+	}
+
 	if myPostsOnly {
 		userID := auth.GetCurrentUserID(r)
 		if userID == 0 {
 			http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
 			return
 		}
-		query += " WHERE p.user_id = ?"
+		conditions = append(conditions, "p.user_id = ?")
 		args = append(args, userID)
 	}
 
@@ -61,34 +100,82 @@ func GetPostsHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
 			return
 		}
-		query += " WHERE EXISTS (SELECT 1 FROM likes WHERE post_id = p.post_id AND user_id = ?)"
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM reactions WHERE target_type = 'post' AND target_id = p.post_id AND reaction_type = 'like' AND user_id = ?)")
 		args = append(args, userID)
 	}
 
-	// Complete query
-	query += " GROUP BY p.post_id ORDER BY p.created_at DESC"
+	// Posts the viewer bookmarked, optionally limited to one collection
+	if savedOnly {
+		if viewerID == 0 {
+			http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		condition := "EXISTS (SELECT 1 FROM bookmarks WHERE target_type = 'post' AND target_id = p.post_id AND user_id = ?"
+		args = append(args, viewerID)
+		if collectionID, err := strconv.Atoi(r.URL.Query().Get("collection_id")); err == nil && collectionID > 0 {
+			condition += " AND collection_id = ?"
+			args = append(args, collectionID)
+		}
+		conditions = append(conditions, condition+")")
+	}
 
-	// Execute query
-	rows, err := db.DB.Query(query, args...)
+	// Pinned posts lead the unfiltered list and each category's list
+	pinCategoryID := noPins
+	if category != "" {
+		if id, err := db.CategoryIDByName(category); err == nil {
+			pinCategoryID = id
+		}
+	} else if len(conditions) == 0 {
+		pinCategoryID = models.GlobalPin
+	}
+
+	posts, err := queryPosts(viewerID, pinCategoryID, joins, conditions, args, "")
 	if err != nil {
 		log.Printf("Database error: %v", err)
 		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
 		return
 	}
+
+	json.NewEncoder(w).Encode(posts)
+}
+
+// queryPosts runs postListQuery with the given joins and AND-ed conditions,
+// posts pinned in pinCategoryID first and then newest first. suffix is
+// appended after the ORDER BY, for LIMIT and OFFSET.
+func queryPosts(viewerID, pinCategoryID int, joins string, conditions []string, args []interface{}, suffix string) ([]map[string]interface{}, error) {
+	query := postListQuery + joins
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// Complete query
+	query += " GROUP BY p.post_id ORDER BY pinned DESC, p.created_at DESC, p.post_id DESC" + suffix
+
+	// Execute query
+	rows, err := db.DB.Query(query, append([]interface{}{viewerID, viewerID, pinCategoryID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	var posts []map[string]interface{}
+	var postIDs []int
+	var imageURLs []string
 	for rows.Next() {
 		var postID, userID int
 		var title, content, imageURL, createdAt, username, firstName, lastName string
-		var categories sql.NullString
+		var categories, tags sql.NullString
 		
-		var likeCount, commentCount int
+		var likeCount, dislikeCount, commentCount int
+		var userReaction string
+		var pollID sql.NullInt64
+		var saved, pinned, locked, announcement bool
 
 		err := rows.Scan(
 			&postID, &title, &content, &imageURL, &createdAt,
 			&userID, &username, &firstName, &lastName,
-			&categories, &likeCount, &commentCount,
+			&categories, &tags, &likeCount, &dislikeCount, &userReaction, &commentCount,
+			&pollID, &saved, &pinned, &locked, &announcement,
 		)
 		if err != nil {
 			log.Printf("Row scan error: %v", err)
@@ -106,12 +193,82 @@ func GetPostsHandler(w http.ResponseWriter, r *http.Request) {
 			"first_name":   firstName,
 			"last_name":    lastName,
 			"categories":   categories.String,
+			"tags":         splitTagNames(tags.String),
 			"like_count":   likeCount,
+			"likes":        likeCount,
+			"dislikes":     dislikeCount,
+			"user_reaction": userReaction,
 			"comment_count": commentCount,
+			"saved":        saved,
+			"pinned":       pinned,
+			"locked":       locked,
+			"announcement": announcement,
+		}
+
+		if pollID.Valid {
+			post["poll_id"] = pollID.Int64
 		}
 
 		posts = append(posts, post)
+		postIDs = append(postIDs, postID)
+		if imageURL != "" {
+			imageURLs = append(imageURLs, imageURL)
+		}
 	}
+	rows.Close()
 
-	json.NewEncoder(w).Encode(posts)
+	// Galleries, and resized versions of processed images for srcset
+	galleries, err := db.GetPostImages(postIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, images := range galleries {
+		imageURLs = append(imageURLs, imageKeys(images)...)
+	}
+	variants, err := db.GetImageVariants(imageURLs)
+	if err != nil {
+		return nil, err
+	}
+	for i, post := range posts {
+		images := galleries[postIDs[i]]
+		if images == nil {
+			images = []models.PostImage{}
+		}
+		presentImages(images, variants)
+		post["images"] = images
+
+		cover := post["image_url"].(string)
+		if v := variants[cover]; len(v) > 0 {
+			post["image_srcset"] = srcset(v)
+			post["image_thumb_url"] = storage.URL(v[0].URL)
+			post["image_width"] = v[len(v)-1].Width
+			post["image_height"] = v[len(v)-1].Height
+		}
+		post["image_url"] = storage.URL(cover)
+	}
+
+	// Per-type reaction counts and the reactions each post accepts
+	counts, err := db.ReactionCounts(db.DB, models.ReactionTargetPost, postIDs)
+	if err != nil {
+		return nil, err
+	}
+	available, err := db.GetPostReactionTypes(postIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i, post := range posts {
+		postCounts := counts[postIDs[i]]
+		if postCounts == nil {
+			postCounts = map[string]int{}
+		}
+		post["reaction_counts"] = postCounts
+
+		postTypes := available[postIDs[i]]
+		if postTypes == nil {
+			postTypes = []string{}
+		}
+		post["available_reactions"] = postTypes
+	}
+
+	return posts, nil
 }
\ No newline at end of file
@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"real/db"
+	"real/models"
+	"real/storage"
+
+	rt_hub "real/websocket"
+)
+
+// datetimeLocalLayout is what an <input type="datetime-local"> submits.
+const datetimeLocalLayout = "2006-01-02T15:04"
+
+// parsePublishAt reads an optional publish time, either RFC 3339 or a
+// datetime-local value in server time. It returns nil when none was sent.
+func parsePublishAt(raw string) (*time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		t, err = time.ParseInLocation(datetimeLocalLayout, raw, time.Local)
+		if err != nil {
+			return nil, errors.New("Invalid publish time")
+		}
+	}
+	if !t.After(time.Now()) {
+		return nil, errors.New("Publish time must be in the future")
+	}
+	return &t, nil
+}
+
+// rawPoll keeps the poll field of a form as submitted, for storing in a draft.
+func rawPoll(pollRaw string) json.RawMessage {
+	if pollRaw == "" {
+		return nil
+	}
+	return json.RawMessage(pollRaw)
+}
+
+// draftIDFromForm reads the draft_id form field, writing a 400 when it is
+// missing or invalid.
+func draftIDFromForm(w http.ResponseWriter, r *http.Request) (int, bool) {
+	draftID, err := strconv.Atoi(r.FormValue("draft_id"))
+	if err != nil || draftID <= 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid draft_id"})
+		return 0, false
+	}
+	return draftID, true
+}
+
+func writeDraftError(w http.ResponseWriter, err error) {
+	if errors.Is(err, db.ErrDraftNotFound) {
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": "Draft not found"})
+		return
+	}
+	log.Printf("Draft error: %v", err)
+	WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+}
+
+// SaveDraftHandler creates or updates a draft. It is meant to be called
+// repeatedly while the author types, so incomplete posts are accepted; the
+// full post rules only apply when the draft is scheduled or published. A
+// draft keeps its images unless new ones are uploaded, which replace them.
+func SaveDraftHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	limitUploadBody(w, r)
+	if err := r.ParseMultipartForm(20 << 20); err != nil && err != http.ErrNotMultipart {
+		if isTooLarge(err) {
+			WriteJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "Upload is too large"})
+			return
+		}
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Failed to parse form"})
+		return
+	}
+
+	var draftID int
+	if r.FormValue("draft_id") != "" {
+		if draftID, ok = draftIDFromForm(w, r); !ok {
+			return
+		}
+	}
+
+	categoryIDs, err := parseCategoryIDs(r.Form["category"])
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	tags, err := parseTags(r.Form["tags"])
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	pollRaw := strings.TrimSpace(r.FormValue("poll"))
+	if pollRaw != "" && !json.Valid([]byte(pollRaw)) {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid poll format"})
+		return
+	}
+
+	draft := models.PostDraft{
+		DraftID:     draftID,
+		UserID:      userID,
+		Title:       strings.TrimSpace(r.FormValue("title")),
+		Content:     strings.TrimSpace(r.FormValue("content")),
+		CategoryIDs: categoryIDs,
+		Tags:        tags,
+		Poll:        rawPoll(pollRaw),
+	}
+
+	if draft.Images, err = saveUploadedImages(r, userID); err != nil {
+		status, message := uploadError(err)
+		WriteJSON(w, status, map[string]string{"error": message})
+		return
+	}
+
+	if draftID == 0 && draft.Title == "" && draft.Content == "" && len(draft.Images) == 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Draft is empty"})
+		return
+	}
+
+	draftID, err = db.SaveDraft(draft)
+	if err != nil {
+		writeDraftError(w, err)
+		return
+	}
+
+	saved, err := db.GetDraft(draftID, userID)
+	if err != nil {
+		writeDraftError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, presentDraft(saved))
+}
+
+// GetDraftsHandler lists the session user's drafts, or a single one when
+// draft_id is given.
+func GetDraftsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	if r.URL.Query().Get("draft_id") != "" {
+		draftID, ok := draftIDFromForm(w, r)
+		if !ok {
+			return
+		}
+		draft, err := db.GetDraft(draftID, userID)
+		if err != nil {
+			writeDraftError(w, err)
+			return
+		}
+		WriteJSON(w, http.StatusOK, presentDraft(draft))
+		return
+	}
+
+	drafts, err := db.ListDrafts(userID)
+	if err != nil {
+		writeDraftError(w, err)
+		return
+	}
+	for i := range drafts {
+		drafts[i] = presentDraft(drafts[i])
+	}
+	WriteJSON(w, http.StatusOK, drafts)
+}
+
+// DeleteDraftHandler discards one of the session user's drafts.
+func DeleteDraftHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	draftID, ok := draftIDFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	if err := db.DeleteDraft(draftID, userID); err != nil {
+		writeDraftError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true, "draft_id": draftID})
+}
+
+// ScheduleDraftHandler sets when a draft is published. The draft must already
+// be a valid post. An empty publish_at cancels the schedule.
+func ScheduleDraftHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	if !requireCanPost(w, userID) {
+		return
+	}
+	draftID, ok := draftIDFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	publishAt, err := parsePublishAt(r.FormValue("publish_at"))
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if publishAt != nil {
+		draft, err := db.GetDraft(draftID, userID)
+		if err != nil {
+			writeDraftError(w, err)
+			return
+		}
+		if _, err := postFromDraft(draft); err != nil {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := db.ScheduleDraft(draftID, userID, publishAt); err != nil {
+		writeDraftError(w, err)
+		return
+	}
+
+	draft, err := db.GetDraft(draftID, userID)
+	if err != nil {
+		writeDraftError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, presentDraft(draft))
+}
+
+// PublishDraftHandler publishes one of the session user's drafts right away.
+func PublishDraftHandler(hub *rt_hub.Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	if !requireCanPost(w, userID) {
+		return
+	}
+	draftID, ok := draftIDFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	draft, err := db.GetDraft(draftID, userID)
+	if err != nil {
+		writeDraftError(w, err)
+		return
+	}
+	post, err := postFromDraft(draft)
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	postID, pollID, err := db.PublishDraft(draftID, post)
+	if err != nil {
+		writeDraftError(w, err)
+		return
+	}
+	announcePost(hub, postID)
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Post created successfully",
+		"post_id": postID,
+		"images":  postGallery(int(postID)),
+	}
+	if len(post.Images) > 0 {
+		response["image_url"] = storage.URL(post.Images[0].URL)
+	}
+	if post.Poll != nil {
+		response["poll_id"] = pollID
+	}
+	WriteJSON(w, http.StatusOK, response)
+}
+
+// presentDraft prepares a draft's images for a response. The draft itself
+// keeps media keys, since that is what publishing stores.
+func presentDraft(d models.PostDraft) models.PostDraft {
+	images := make([]models.PostImage, len(d.Images))
+	copy(images, d.Images)
+	variants, err := db.GetImageVariants(imageKeys(images))
+	if err != nil {
+		log.Printf("Error loading image variants of draft %d: %v", d.DraftID, err)
+	}
+	presentImages(images, variants)
+	d.Images = images
+	return d
+}
+
+// postFromDraft applies the same rules as CreatePostHandler to a draft.
+func postFromDraft(d models.PostDraft) (models.NewPost, error) {
+	post, err := newPost(d.UserID, d.Title, d.Content, d.CategoryIDs, d.Tags, string(d.Poll))
+	post.Images = d.Images
+	return post, err
+}
+
+// ScheduleDraftPublisher publishes scheduled drafts once they are due, every
+// interval. A draft that no longer passes validation (for example because its
+// poll's closing time has passed) is unscheduled and keeps the reason in
+// publish_error.
+func ScheduleDraftPublisher(hub *rt_hub.Hub, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := publishDueDrafts(hub); err != nil {
+			log.Printf("error: draft publishing failed: %v", err)
+		}
+	}
+}
+
+func publishDueDrafts(hub *rt_hub.Hub) error {
+	drafts, err := db.DueDrafts(time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, draft := range drafts {
+		post, err := postFromDraft(draft)
+		if err != nil {
+			log.Printf("Scheduled draft %d cannot be published: %v", draft.DraftID, err)
+			if err := db.MarkDraftFailed(draft.DraftID, err.Error()); err != nil {
+				log.Printf("Error unscheduling draft %d: %v", draft.DraftID, err)
+			}
+			continue
+		}
+
+		postID, _, err := db.PublishDraft(draft.DraftID, post)
+		if errors.Is(err, db.ErrDraftNotFound) {
+			// Published or deleted by its author in the meantime
+			continue
+		} else if err != nil {
+			log.Printf("Error publishing draft %d: %v", draft.DraftID, err)
+			continue
+		}
+		log.Printf("Published scheduled draft %d as post %d", draft.DraftID, postID)
+		announcePost(hub, postID)
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"real/auth"
+	"real/db"
+	"real/models"
+)
+
+const (
+	defaultPostPageSize = 20
+	maxPostPageSize     = 50
+)
+
+// FollowHandler follows a user or category ({user_id} or {category_id}).
+func FollowHandler(w http.ResponseWriter, r *http.Request) {
+	handleFollow(w, r, true)
+}
+
+// UnfollowHandler undoes FollowHandler.
+func UnfollowHandler(w http.ResponseWriter, r *http.Request) {
+	handleFollow(w, r, false)
+}
+
+func handleFollow(w http.ResponseWriter, r *http.Request, follow bool) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.FollowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	var err error
+	switch {
+	case req.UserID > 0 && req.CategoryID == 0 && follow:
+		err = db.FollowUser(userID, req.UserID)
+	case req.UserID > 0 && req.CategoryID == 0:
+		err = db.UnfollowUser(userID, req.UserID)
+	case req.CategoryID > 0 && req.UserID == 0 && follow:
+		err = db.FollowCategory(userID, req.CategoryID)
+	case req.CategoryID > 0 && req.UserID == 0:
+		err = db.UnfollowCategory(userID, req.CategoryID)
+	default:
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Send either user_id or category_id"})
+		return
+	}
+	switch {
+	case errors.Is(err, db.ErrFollowSelf):
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	case errors.Is(err, db.ErrFollowTarget):
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": "The user or category does not exist"})
+		return
+	case err != nil:
+		log.Printf("Error updating follow: %v", err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	response := map[string]interface{}{"success": true, "following": follow}
+	if req.UserID > 0 {
+		// Updated counts for the profile being viewed
+		if profile, err := db.GetUserProfile(req.UserID, userID); err == nil {
+			response["profile"] = profile
+		}
+	}
+	WriteJSON(w, http.StatusOK, response)
+}
+
+// profileUserID reads the user a request is about from ?user_id= or
+// ?username=, defaulting to viewerID. It writes an error response and returns
+// false when the user cannot be found.
+func profileUserID(w http.ResponseWriter, r *http.Request, viewerID int) (int, bool) {
+	query := r.URL.Query()
+	if username := query.Get("username"); username != "" {
+		userID, err := db.GetUserIDByUsername(username)
+		if errors.Is(err, db.ErrUserNotFound) {
+			WriteJSON(w, http.StatusNotFound, map[string]string{"error": "User not found"})
+			return 0, false
+		} else if err != nil {
+			log.Printf("Error looking up user %q: %v", username, err)
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+			return 0, false
+		}
+		return userID, true
+	}
+	if query.Get("user_id") != "" {
+		userID, err := strconv.Atoi(query.Get("user_id"))
+		if err != nil || userID <= 0 {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid user_id"})
+			return 0, false
+		}
+		return userID, true
+	}
+	if viewerID == 0 {
+		WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return 0, false
+	}
+	return viewerID, true
+}
+
+// ProfileHandler returns a user's public profile with follower and following
+// counts.
+func ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	viewerID := auth.GetCurrentUserID(r)
+	userID, ok := profileUserID(w, r, viewerID)
+	if !ok {
+		return
+	}
+
+	profile, err := db.GetUserProfile(userID, viewerID)
+	if errors.Is(err, db.ErrUserNotFound) {
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": "User not found"})
+		return
+	} else if err != nil {
+		log.Printf("Error loading profile %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, profile)
+}
+
+// GetFollowersHandler lists the followers of a user (?user_id= or
+// ?username=, defaulting to the session user).
+func GetFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	viewerID := auth.GetCurrentUserID(r)
+	userID, ok := profileUserID(w, r, viewerID)
+	if !ok {
+		return
+	}
+
+	followers, err := db.GetFollowers(userID)
+	if err != nil {
+		log.Printf("Error loading followers of %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, followers)
+}
+
+// GetFollowingHandler lists the users a user follows. The followed categories
+// are only included for the session user's own list.
+func GetFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	viewerID := auth.GetCurrentUserID(r)
+	userID, ok := profileUserID(w, r, viewerID)
+	if !ok {
+		return
+	}
+
+	users, err := db.GetFollowedUsers(userID)
+	if err != nil {
+		log.Printf("Error loading follows of %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		return
+	}
+	response := map[string]interface{}{"users": users}
+
+	if userID == viewerID {
+		categories, err := db.GetFollowedCategories(userID)
+		if err != nil {
+			log.Printf("Error loading followed categories of %d: %v", userID, err)
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+			return
+		}
+		response["categories"] = categories
+	}
+	WriteJSON(w, http.StatusOK, response)
+}
+
+// FeedHandler returns the session user's home feed: posts by the users and in
+// the categories they follow, newest first, paginated with limit and offset.
+func FeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	limit, offset := pageParams(r)
+
+	conditions := []string{`(
+		p.user_id IN (SELECT followed_id FROM user_follows WHERE follower_id = ?)
+		OR EXISTS (
+			SELECT 1 FROM post_categories fpc
+			JOIN category_follows cf ON cf.category_id = fpc.category_id
+			WHERE fpc.post_id = p.post_id AND cf.user_id = ?
+		)
+	)`}
+	args := []interface{}{userID, userID, limit + 1, offset}
+
+	// One extra row tells whether there is another page
+	posts, err := queryPosts(userID, noPins, "", conditions, args, " LIMIT ? OFFSET ?")
+	if err != nil {
+		log.Printf("Error loading feed for %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, postPage(posts, limit, offset))
+}
+
+// pageParams reads the limit and offset query parameters of a paginated post
+// list.
+func pageParams(r *http.Request) (limit, offset int) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPostPageSize
+	}
+	if limit > maxPostPageSize {
+		limit = maxPostPageSize
+	}
+	offset, err = strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// postPage builds the response for one page of posts, queried with a limit of
+// limit+1 so the extra row tells whether there is another page.
+func postPage(posts []map[string]interface{}, limit, offset int) map[string]interface{} {
+	hasMore := len(posts) > limit
+	if hasMore {
+		posts = posts[:limit]
+	}
+	if posts == nil {
+		posts = []map[string]interface{}{}
+	}
+
+	response := map[string]interface{}{
+		"posts":    posts,
+		"has_more": hasMore,
+	}
+	if hasMore {
+		response["next_offset"] = offset + limit
+	}
+	return response
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"real/auth"
+	"real/db"
+)
+
+// TestMain opens a fresh database for the package. db.Init reads
+// db/schema.sql relative to the working directory, so the tests run from the
+// repository root.
+func TestMain(m *testing.M) {
+	os.Exit(runWithDatabase(m))
+}
+
+func runWithDatabase(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "handlers-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Chdir(".."); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := db.Init(filepath.Join(dir, "forum.db")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.DB.Close()
+	return m.Run()
+}
+
+// createTestUser adds a user and returns their ID.
+func createTestUser(t *testing.T, username string) int {
+	t.Helper()
+	result, err := db.DB.Exec(
+		`INSERT INTO users (username, email, password) VALUES (?, ?, '')`, username, username+"@example.com",
+	)
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+// sessionCookies logs userID in, returning the cookies and CSRF token a
+// browser would send with its requests.
+func sessionCookies(t *testing.T, userID int) ([]*http.Cookie, string) {
+	t.Helper()
+	s, err := auth.CreateSession(db.DB, httptest.NewRequest(http.MethodGet, "/", nil), userID, false)
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	auth.SetSessionCookie(rec, s)
+	return rec.Result().Cookies(), s.CSRFToken
+}
+
+func reactionRows(t *testing.T, targetType string, targetID int) int {
+	t.Helper()
+	var n int
+	err := db.DB.QueryRow(
+		`SELECT COUNT(*) FROM reactions WHERE target_type = ? AND target_id = ?`, targetType, targetID,
+	).Scan(&n)
+	if err != nil {
+		t.Fatalf("count reactions: %v", err)
+	}
+	return n
+}
+
+func TestReactionHandlersUseSessionUser(t *testing.T) {
+	author := createTestUser(t, "reaction_author")
+	reactor := createTestUser(t, "reaction_reactor")
+
+	result, err := db.DB.Exec(`INSERT INTO posts (user_id, title, content) VALUES (?, 'Title', 'Content')`, author)
+	if err != nil {
+		t.Fatalf("create post: %v", err)
+	}
+	postID, _ := result.LastInsertId()
+	result, err = db.DB.Exec(`INSERT INTO comments (post_id, user_id, content) VALUES (?, ?, 'Comment')`, postID, author)
+	if err != nil {
+		t.Fatalf("create comment: %v", err)
+	}
+	commentID, _ := result.LastInsertId()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/like", auth.AuthMiddleware(LikeHandler))
+	mux.HandleFunc("/comment/like", auth.AuthMiddleware(CommentReactionHandler))
+	server := httptest.NewServer(auth.SessionMiddleware(auth.CSRFMiddleware(mux), nil))
+	defer server.Close()
+
+	cookies, csrfToken := sessionCookies(t, reactor)
+
+	endpoints := []struct {
+		path       string
+		targetType string
+		targetID   int
+		idField    string
+	}{
+		{"/like", "post", int(postID), "post_id"},
+		{"/comment/like", "comment", int(commentID), "comment_id"},
+	}
+	cases := []struct {
+		name       string
+		userID     int
+		loggedIn   bool
+		wantStatus int
+		wantRows   int
+	}{
+		{"other user", author, true, http.StatusForbidden, 0},
+		{"no session", reactor, false, http.StatusUnauthorized, 0},
+		{"session user", reactor, true, http.StatusOK, 1},
+	}
+
+	for _, e := range endpoints {
+		for _, c := range cases {
+			t.Run(e.targetType+"/"+c.name, func(t *testing.T) {
+				body := fmt.Sprintf(`{%q: %d, "reaction_type": "like", "user_id": %d}`, e.idField, e.targetID, c.userID)
+				req, err := http.NewRequest(http.MethodPost, server.URL+e.path, strings.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("Content-Type", "application/json")
+				if c.loggedIn {
+					for _, cookie := range cookies {
+						req.AddCookie(cookie)
+					}
+					req.Header.Set(auth.CSRFHeader, csrfToken)
+				}
+
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != c.wantStatus {
+					t.Errorf("status = %d, want %d", resp.StatusCode, c.wantStatus)
+				}
+				if n := reactionRows(t, e.targetType, e.targetID); n != c.wantRows {
+					t.Errorf("%d reactions rows, want %d", n, c.wantRows)
+				}
+			})
+		}
+	}
+}
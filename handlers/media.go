@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"real/db"
+	"real/storage"
+)
+
+// MediaHandler serves files from local media storage through the signed URLs
+// made when MEDIA_PRIVATE is set. S3 signed URLs point at the bucket instead.
+func MediaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	local, ok := storage.Media.(*storage.Local)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, storage.SignedPrefix)
+	query := r.URL.Query()
+	if !local.Verify(key, query.Get("expires"), query.Get("signature")) {
+		http.Error(w, "Link is invalid or has expired", http.StatusForbidden)
+		return
+	}
+	path, err := local.Path(key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The link expires, so caches must not keep the file for longer
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	http.ServeFile(w, r, path)
+}
+
+// mediaLock keeps uploads from storing an image while the cleanup job is
+// deleting an unused image or stray file. Files are named after their
+// content, so an upload of the same file could otherwise store its files
+// just before the job removes them.
+var mediaLock sync.RWMutex
+
+// ScheduleMediaCleanup removes uploads nothing uses any more, every interval:
+// images no post, draft or private message refers to, and stored files with
+// no record at all, such as those of an upload whose post failed to save.
+// Nothing is removed until it has been unused for grace, so uploads waiting
+// to be attached to their post are kept.
+func ScheduleMediaCleanup(interval, grace time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := cleanupMedia(grace); err != nil {
+			log.Printf("error: media cleanup failed: %v", err)
+		}
+	}
+}
+
+func cleanupMedia(grace time.Duration) error {
+	cutoff := time.Now().Add(-grace)
+
+	images, err := db.OrphanImages(cutoff)
+	if err != nil {
+		return err
+	}
+	var removedImages int
+	for _, img := range images {
+		mediaLock.Lock()
+		removed, err := db.DeleteOrphanImage(img, cutoff, removeMedia)
+		mediaLock.Unlock()
+		if err != nil {
+			log.Printf("Error removing unused image %s: %v", img.URL, err)
+			continue
+		}
+		if removed {
+			removedImages++
+		}
+	}
+
+	// Files are collected first so the listing is not changed while it runs
+	var stray []string
+	err = storage.Media.List(uploadPrefix, func(key string, modTime time.Time) error {
+		if modTime.After(cutoff) {
+			return nil
+		}
+		referenced, err := db.MediaReferenced(key)
+		if err != nil || referenced {
+			return err
+		}
+		stray = append(stray, key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// An upload of the same file may have recorded it since it was listed
+	removedStray := 0
+	for _, key := range stray {
+		mediaLock.Lock()
+		referenced, err := db.MediaReferenced(key)
+		if err == nil && !referenced {
+			err = removeMedia([]string{key})
+		}
+		mediaLock.Unlock()
+		if err != nil {
+			return err
+		}
+		if !referenced {
+			removedStray++
+		}
+	}
+
+	if removedImages > 0 || removedStray > 0 {
+		log.Printf("Media cleanup removed %d unused images and %d stray files", removedImages, removedStray)
+	}
+	return nil
+}
+
+// removeMedia deletes stored files, stopping at the first failure.
+func removeMedia(keys []string) error {
+	for _, key := range keys {
+		if err := storage.Media.Delete(key); err != nil {
+			return fmt.Errorf("remove %s: %w", key, err)
+		}
+	}
+	return nil
+}
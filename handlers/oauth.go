@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"real/auth"
+	"real/db"
+	"real/oauth"
+)
+
+// oauthStateCookie binds a login at a provider to the browser that started
+// it, so that nobody can get someone else logged in as them by sending them
+// a callback link.
+const oauthStateCookie = "oauth_state"
+
+// oauthRedirectURI is where providers send users back to. It has to be
+// registered with each provider.
+func oauthRedirectURI() string {
+	return appBaseURL + "/oauth/callback"
+}
+
+// OAuthProvidersHandler lists the providers users can log in with.
+func OAuthProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	providers := []map[string]string{}
+	for _, p := range oauth.Providers() {
+		providers = append(providers, map[string]string{"id": p.Name(), "name": p.DisplayName()})
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"providers": providers})
+}
+
+// OAuthLoginHandler sends the browser to ?provider= to log in, with
+// ?remember_me=true for a remember-me session. The provider sends it back to
+// OAuthCallbackHandler.
+func OAuthLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	remember, _ := strconv.ParseBool(r.URL.Query().Get("remember_me"))
+	authURL, err := startOAuth(w, r.URL.Query().Get("provider"), 0, remember)
+	if err != nil {
+		if !errors.Is(err, oauth.ErrUnknownProvider) {
+			log.Printf("Error starting OAuth login: %v", err)
+		}
+		redirectOAuth(w, r, "oauth_error", "unavailable")
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// LinkOAuthHandler starts linking the provider in the JSON body to the
+// session user's account. It returns the URL to send the browser to; the
+// provider sends it back to OAuthCallbackHandler, which links the account.
+func LinkOAuthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Provider string `json:"provider"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Provider == "" {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	authURL, err := startOAuth(w, req.Provider, userID, false)
+	if errors.Is(err, oauth.ErrUnknownProvider) {
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": "Unknown provider"})
+		return
+	} else if err != nil {
+		log.Printf("Error starting OAuth link for %d: %v", userID, err)
+		WriteJSON(w, http.StatusBadGateway, map[string]string{"error": "The provider is unavailable"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]string{"url": authURL})
+}
+
+// startOAuth records a login starting at the provider called name, sets the
+// state cookie and returns the provider URL to send the browser to.
+func startOAuth(w http.ResponseWriter, name string, linkUserID int, remember bool) (string, error) {
+	provider, err := oauth.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	state, verifier, err := auth.CreateOAuthState(provider.Name(), linkUserID, remember)
+	if err != nil {
+		return "", err
+	}
+	authURL, err := provider.AuthCodeURL(state, oauth.CodeChallenge(verifier), oauthRedirectURI())
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     "/oauth/callback",
+		MaxAge:   600,
+		HttpOnly: true,
+		// Lax, so that the cookie comes along when the provider redirects back
+		SameSite: http.SameSiteLaxMode,
+	})
+	return authURL, nil
+}
+
+// OAuthCallbackHandler is where providers send the browser back to. It logs
+// the user in, creating or linking their account as needed, and sends the
+// browser on to the site with ?oauth_login=success, ?two_factor_token= when
+// a two-factor code is still needed, ?oauth_linked= after linking, or
+// ?oauth_error= naming what went wrong.
+func OAuthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	cookie, err := r.Cookie(oauthStateCookie)
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/oauth/callback", MaxAge: -1, HttpOnly: true})
+	if state == "" || err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		redirectOAuth(w, r, "oauth_error", "expired")
+		return
+	}
+	pending, err := auth.ConsumeOAuthState(state)
+	if errors.Is(err, auth.ErrOAuthState) {
+		redirectOAuth(w, r, "oauth_error", "expired")
+		return
+	} else if err != nil {
+		log.Printf("Error loading OAuth state: %v", err)
+		redirectOAuth(w, r, "oauth_error", "failed")
+		return
+	}
+
+	if e := query.Get("error"); e != "" {
+		if e != "access_denied" {
+			log.Printf("OAuth login at %s failed: %s: %s", pending.Provider, e, query.Get("error_description"))
+			redirectOAuth(w, r, "oauth_error", "failed")
+		} else {
+			redirectOAuth(w, r, "oauth_error", "denied")
+		}
+		return
+	}
+
+	provider, err := oauth.Lookup(pending.Provider)
+	if err != nil {
+		redirectOAuth(w, r, "oauth_error", "unavailable")
+		return
+	}
+	identity, err := provider.Exchange(r.Context(), query.Get("code"), pending.CodeVerifier, oauthRedirectURI())
+	if errors.Is(err, oauth.ErrNoEmail) {
+		redirectOAuth(w, r, "oauth_error", "no_email")
+		return
+	} else if err != nil {
+		log.Printf("Error completing OAuth login at %s: %v", pending.Provider, err)
+		redirectOAuth(w, r, "oauth_error", "failed")
+		return
+	}
+
+	if pending.LinkUserID != 0 {
+		linkOAuthIdentity(w, r, pending.LinkUserID, identity)
+		return
+	}
+
+	userID, err := auth.OAuthLogin(identity)
+	switch {
+	case errors.Is(err, auth.ErrEmailInUse):
+		redirectOAuth(w, r, "oauth_error", "email_in_use")
+		return
+	case errors.Is(err, auth.ErrEmailUnverified):
+		redirectOAuth(w, r, "oauth_error", "email_unverified")
+		return
+	case errors.Is(err, auth.ErrAccountLinked):
+		redirectOAuth(w, r, "oauth_error", "account_linked")
+		return
+	case err != nil:
+		log.Printf("Error logging in with %s: %v", identity.Provider, err)
+		redirectOAuth(w, r, "oauth_error", "failed")
+		return
+	}
+
+	var username string
+	if err := db.DB.QueryRow(`SELECT username FROM users WHERE user_id = ?`, userID).Scan(&username); err != nil {
+		log.Printf("Error loading user %d: %v", userID, err)
+		redirectOAuth(w, r, "oauth_error", "failed")
+		return
+	}
+
+	// The provider stands in for the password; a code is still needed when
+	// the user has two-factor authentication on
+	twoFactor, err := auth.TwoFactorEnabled(userID)
+	if err != nil {
+		log.Printf("Error checking two-factor authentication: %v", err)
+		redirectOAuth(w, r, "oauth_error", "failed")
+		return
+	}
+	if twoFactor {
+		token, _, err := auth.CreatePendingLogin(userID, pending.Remember)
+		if err != nil {
+			log.Printf("Error creating pending login: %v", err)
+			redirectOAuth(w, r, "oauth_error", "failed")
+			return
+		}
+		redirectOAuth(w, r, "two_factor_token", token)
+		return
+	}
+
+	recordLogin(r, userID, username, true, "")
+	if _, err := createLoginSession(w, r, userID, pending.Remember); err != nil {
+		log.Printf("Error creating session: %v", err)
+		redirectOAuth(w, r, "oauth_error", "failed")
+		return
+	}
+	redirectOAuth(w, r, "oauth_login", "success")
+}
+
+// linkOAuthIdentity finishes linking identity to the account of userID,
+// provided they are still the one logged in.
+func linkOAuthIdentity(w http.ResponseWriter, r *http.Request, userID int, identity oauth.Identity) {
+	if auth.GetCurrentUserID(r) != userID {
+		redirectOAuth(w, r, "oauth_error", "expired")
+		return
+	}
+	err := auth.LinkOAuth(userID, identity)
+	switch {
+	case errors.Is(err, auth.ErrIdentityLinked):
+		redirectOAuth(w, r, "oauth_error", "identity_linked")
+	case errors.Is(err, auth.ErrAccountLinked):
+		redirectOAuth(w, r, "oauth_error", "account_linked")
+	case err != nil:
+		log.Printf("Error linking %s to %d: %v", identity.Provider, userID, err)
+		redirectOAuth(w, r, "oauth_error", "failed")
+	default:
+		redirectOAuth(w, r, "oauth_linked", identity.Provider)
+	}
+}
+
+// redirectOAuth sends the browser to the site with the query parameter key
+// set to value.
+func redirectOAuth(w http.ResponseWriter, r *http.Request, key, value string) {
+	http.Redirect(w, r, "/?"+url.Values{key: {value}}.Encode(), http.StatusSeeOther)
+}
+
+// OAuthAccountHandler reports which provider the session user's account is
+// linked to, if any, and whether they have a password.
+func OAuthAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	provider, hasPassword, err := auth.OAuthAccount(userID)
+	if err != nil {
+		log.Printf("Error loading linked provider of %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"provider": provider, "has_password": hasPassword})
+}
+
+// UnlinkOAuthHandler unlinks the session user's account from its provider,
+// given their password. Accounts created through a provider have no password
+// until the user sets one with a password reset, and cannot be unlinked
+// before then, since nothing would be left to log in with.
+func UnlinkOAuthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	_, hasPassword, err := auth.OAuthAccount(userID)
+	if err != nil {
+		log.Printf("Error loading linked provider of %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	if !hasPassword {
+		WriteJSON(w, http.StatusConflict, map[string]string{"error": "Set a password with \"Forgot your password?\" first"})
+		return
+	}
+	if _, ok := requirePassword(w, r); !ok {
+		return
+	}
+
+	err = auth.UnlinkOAuth(userID)
+	if errors.Is(err, auth.ErrNotLinked) {
+		WriteJSON(w, http.StatusConflict, map[string]string{"error": "Your account is not linked to a provider"})
+		return
+	} else if err != nil {
+		log.Printf("Error unlinking provider of %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
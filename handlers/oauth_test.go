@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"real/auth"
+	"real/db"
+	"real/oauth"
+	"real/oauth/oauthtest"
+	rt_hub "real/websocket"
+)
+
+// newOAuthServer serves the OAuth and password reset endpoints with a mock
+// OpenID Connect provider configured as "oidc".
+func newOAuthServer(t *testing.T) (*httptest.Server, *oauthtest.Provider) {
+	t.Helper()
+	mock := oauthtest.NewProvider()
+	t.Cleanup(mock.Close)
+	t.Setenv("OIDC_ISSUER", mock.URL)
+	t.Setenv("OIDC_CLIENT_ID", oauthtest.ClientID)
+	t.Setenv("OIDC_CLIENT_SECRET", oauthtest.ClientSecret)
+	if err := oauth.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	hub := rt_hub.NewHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/login", OAuthLoginHandler)
+	mux.HandleFunc("/oauth/callback", OAuthCallbackHandler)
+	mux.HandleFunc("/password/reset", func(w http.ResponseWriter, r *http.Request) {
+		ResetPasswordHandler(hub, w, r)
+	})
+	server := httptest.NewServer(auth.SessionMiddleware(auth.CSRFMiddleware(mux), nil))
+	t.Cleanup(server.Close)
+
+	saved := appBaseURL
+	appBaseURL = server.URL
+	t.Cleanup(func() { appBaseURL = saved })
+	return server, mock
+}
+
+// oauthLogin logs in at the mock provider as claims, following the redirects
+// through the provider and back, and returns the query the site sends the
+// browser on with.
+func oauthLogin(t *testing.T, server *httptest.Server, mock *oauthtest.Provider, claims map[string]interface{}) url.Values {
+	t.Helper()
+	mock.SetClaims(claims)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{
+		Jar: jar,
+		// Stop once sent back to the site's pages
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if strings.HasPrefix(req.URL.String(), server.URL+"/?") {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+	resp, err := client.Get(server.URL + "/oauth/login?provider=oidc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatalf("login ended with %s and no redirect", resp.Status)
+	}
+	return location.Query()
+}
+
+func userByEmail(t *testing.T, email string) (userID int, provider sql.NullString, verified bool) {
+	t.Helper()
+	err := db.DB.QueryRow(
+		`SELECT user_id, provider_id, email_verified_at IS NOT NULL FROM users WHERE email = ?`, email,
+	).Scan(&userID, &provider, &verified)
+	if err == sql.ErrNoRows {
+		return 0, provider, false
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return userID, provider, verified
+}
+
+func TestOAuthCallback(t *testing.T) {
+	server, mock := newOAuthServer(t)
+	if _, err := db.DB.Exec(`
+		INSERT INTO users (username, email, password, email_verified_at)
+		VALUES ('oauth_owner', 'owner@example.com', 'hash', CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		claims      map[string]interface{}
+		key, value  string
+		wantAccount bool
+	}{
+		{
+			name:   "new verified address",
+			claims: map[string]interface{}{"sub": "new-1", "email": "newcomer@example.com", "email_verified": true},
+			key:    "oauth_login", value: "success", wantAccount: true,
+		},
+		{
+			name:   "new unverified address",
+			claims: map[string]interface{}{"sub": "squatter-1", "email": "squatted@example.com", "email_verified": false},
+			key:    "oauth_error", value: "email_unverified",
+		},
+		{
+			name:   "email_verified left out",
+			claims: map[string]interface{}{"sub": "squatter-2", "email": "squatted2@example.com"},
+			key:    "oauth_error", value: "email_unverified",
+		},
+		{
+			name:   "address of an account, unverified at the provider",
+			claims: map[string]interface{}{"sub": "squatter-3", "email": "owner@example.com"},
+			key:    "oauth_error", value: "email_in_use", wantAccount: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := oauthLogin(t, server, mock, tt.claims)
+			if got := query.Get(tt.key); got != tt.value {
+				t.Errorf("sent on with %v, want %s=%s", query, tt.key, tt.value)
+			}
+			userID, provider, _ := userByEmail(t, tt.claims["email"].(string))
+			if (userID != 0) != tt.wantAccount {
+				t.Errorf("account exists: %t, want %t", userID != 0, tt.wantAccount)
+			}
+			if provider.Valid && provider.String != tt.claims["sub"] {
+				t.Errorf("account is linked to %s", provider.String)
+			}
+		})
+	}
+}
+
+func TestResetPasswordUnlinksProviderOfUnverifiedAccount(t *testing.T) {
+	server, mock := newOAuthServer(t)
+
+	tests := []struct {
+		name       string
+		email      string
+		verified   bool
+		wantLinked bool
+	}{
+		// As created through a provider that had not verified the address
+		{"unverified", "claimed@example.com", false, false},
+		{"verified", "linked@example.com", true, true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifiedAt := "NULL"
+			if tt.verified {
+				verifiedAt = "CURRENT_TIMESTAMP"
+			}
+			subject := "reset-sub-" + tt.name
+			result, err := db.DB.Exec(`
+				INSERT INTO users (username, email, password, auth_type, provider_id, email_verified_at)
+				VALUES (?, ?, '', 'oidc', ?, `+verifiedAt+`)`,
+				"reset_user_"+string(rune('a'+i)), tt.email, subject)
+			if err != nil {
+				t.Fatal(err)
+			}
+			userID, _ := result.LastInsertId()
+			token, err := auth.IssueToken(int(userID), auth.TokenPasswordReset, tt.email)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body := `{"token": "` + token + `", "password": "new password", "confirm_password": "new password"}`
+			resp, err := http.Post(server.URL+"/password/reset", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("reset answered %s", resp.Status)
+			}
+
+			_, provider, verified := userByEmail(t, tt.email)
+			if provider.Valid != tt.wantLinked || !verified {
+				t.Errorf("after the reset: linked %t, verified %t; want linked %t, verified", provider.Valid, verified, tt.wantLinked)
+			}
+
+			// Whoever claimed the address through the provider is locked out
+			query := oauthLogin(t, server, mock, map[string]interface{}{"sub": subject, "email": tt.email})
+			wantKey := "oauth_error"
+			if tt.wantLinked {
+				wantKey = "oauth_login"
+			}
+			if query.Get(wantKey) == "" {
+				t.Errorf("provider login sent on with %v, want %s", query, wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"real/auth"
+	"real/db"
+	"real/models"
+
+	rt_hub "real/websocket"
+)
+
+const (
+	maxPollOptions        = 10
+	maxPollQuestionLength = 300
+	maxPollOptionLength   = 200
+)
+
+// parsePollInput reads the optional "poll" form field of a new post, a JSON
+// object matching models.PollInput. It returns nil when no poll was sent.
+func parsePollInput(raw string) (*models.PollInput, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	var poll models.PollInput
+	if err := json.Unmarshal([]byte(raw), &poll); err != nil {
+		return nil, errors.New("invalid poll format")
+	}
+
+	poll.Question = strings.TrimSpace(poll.Question)
+	if poll.Question == "" {
+		return nil, errors.New("poll question is required")
+	}
+	if len(poll.Question) > maxPollQuestionLength {
+		return nil, errors.New("poll question is too long")
+	}
+
+	seen := make(map[string]bool)
+	var options []string
+	for _, opt := range poll.Options {
+		opt = strings.TrimSpace(opt)
+		if opt == "" {
+			continue
+		}
+		if len(opt) > maxPollOptionLength {
+			return nil, errors.New("poll option is too long")
+		}
+		if seen[strings.ToLower(opt)] {
+			return nil, errors.New("poll options must be unique")
+		}
+		seen[strings.ToLower(opt)] = true
+		options = append(options, opt)
+	}
+	if len(options) < 2 || len(options) > maxPollOptions {
+		return nil, errors.New("a poll needs between 2 and 10 options")
+	}
+	poll.Options = options
+
+	if poll.ClosesAt != nil && !poll.ClosesAt.After(time.Now()) {
+		return nil, errors.New("poll closing time must be in the future")
+	}
+
+	return &poll, nil
+}
+
+// GetPollHandler returns a poll's results by poll_id or post_id, including
+// the caller's own votes when logged in.
+func GetPollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	var pollID int
+	var err error
+	if s := r.URL.Query().Get("poll_id"); s != "" {
+		pollID, err = strconv.Atoi(s)
+	} else {
+		var postID int
+		postID, err = strconv.Atoi(r.URL.Query().Get("post_id"))
+		if err == nil {
+			pollID, err = db.GetPollIDForPost(postID)
+		}
+	}
+	if errors.Is(err, db.ErrPollNotFound) {
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": "Poll not found"})
+		return
+	} else if err != nil {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "poll_id or post_id is required"})
+		return
+	}
+
+	viewerID := auth.GetCurrentUserID(r)
+	poll, err := db.GetPoll(pollID, viewerID)
+	if errors.Is(err, db.ErrPollNotFound) {
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": "Poll not found"})
+		return
+	} else if err != nil {
+		log.Printf("Error loading poll %d: %v", pollID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to load poll"})
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, poll)
+}
+
+// VotePollHandler records the session user's vote and pushes the new results
+// to every connected client. The body is {"poll_id": 1, "option_ids": [2]};
+// an empty option_ids withdraws the vote.
+func VotePollHandler(hub *rt_hub.Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	userID := auth.GetCurrentUserID(r)
+	if userID == 0 {
+		WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "You must be logged in to vote"})
+		return
+	}
+
+	var req struct {
+		PollID    int   `json:"poll_id"`
+		OptionIDs []int `json:"option_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PollID <= 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	err := db.VotePoll(req.PollID, userID, req.OptionIDs)
+	switch {
+	case errors.Is(err, db.ErrPollNotFound):
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": "Poll not found"})
+		return
+	case errors.Is(err, db.ErrPollClosed):
+		WriteJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	case errors.Is(err, db.ErrPollInvalidVote), errors.Is(err, db.ErrPollSingleChoice):
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		log.Printf("Error recording vote on poll %d: %v", req.PollID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to record vote"})
+		return
+	}
+
+	results, err := db.GetPoll(req.PollID, userID)
+	if err != nil {
+		log.Printf("Error loading poll %d: %v", req.PollID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Vote recorded but results could not be loaded"})
+		return
+	}
+
+	// Everyone gets the public results; only the voter sees their own votes.
+	public := results
+	public.MyVotes = nil
+	go func() {
+		if err := hub.BroadcastEvent("poll_results", public); err != nil {
+			log.Printf("Error broadcasting poll %d results: %v", req.PollID, err)
+		}
+	}()
+
+	WriteJSON(w, http.StatusOK, results)
+}
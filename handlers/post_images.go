@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"real/db"
+	"real/models"
+)
+
+// requirePostAuthor writes an error response and returns false unless userID
+// wrote postID. Moderators pass as well when allowModerators is set.
+func requirePostAuthor(w http.ResponseWriter, postID, userID int, allowModerators bool) bool {
+	authorID, err := db.GetPostAuthorID(postID)
+	if errors.Is(err, db.ErrPostNotFound) {
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": "Post not found"})
+		return false
+	} else if err != nil {
+		log.Printf("Error loading post %d: %v", postID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+		return false
+	}
+	if authorID != userID && !(allowModerators && db.IsModerator(userID)) {
+		WriteJSON(w, http.StatusForbidden, map[string]string{"error": "You can only change images on your own posts"})
+		return false
+	}
+	return true
+}
+
+func writePostImageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrPostImageNotFound):
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, db.ErrTooManyImages):
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		log.Printf("Post image error: %v", err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+}
+
+// postGallery returns the stored images of a post for a response.
+func postGallery(postID int) []models.PostImage {
+	galleries, err := db.GetPostImages([]int{postID})
+	if err != nil {
+		log.Printf("Error loading images of post %d: %v", postID, err)
+	}
+	images := galleries[postID]
+	if images == nil {
+		return []models.PostImage{}
+	}
+	variants, err := db.GetImageVariants(imageKeys(images))
+	if err != nil {
+		log.Printf("Error loading image variants of post %d: %v", postID, err)
+	}
+	presentImages(images, variants)
+	return images
+}
+
+// writePostImages responds with a post's gallery after a change.
+func writePostImages(w http.ResponseWriter, postID int) {
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true, "post_id": postID, "images": postGallery(postID)})
+}
+
+// AddPostImagesHandler appends images to one of the user's posts. It takes
+// the same multipart fields as CreatePostHandler: post_id, one or more "img"
+// files and optional "image_alt" and "image_caption" values.
+func AddPostImagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	limitUploadBody(w, r)
+	if err := r.ParseMultipartForm(20 << 20); err != nil {
+		if isTooLarge(err) {
+			WriteJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "Upload is too large"})
+			return
+		}
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Failed to parse form"})
+		return
+	}
+
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil || postID <= 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid post_id"})
+		return
+	}
+	if !requirePostAuthor(w, postID, userID, false) {
+		return
+	}
+
+	images, err := saveUploadedImages(r, userID)
+	if err != nil {
+		status, message := uploadError(err)
+		WriteJSON(w, status, map[string]string{"error": message})
+		return
+	}
+	if len(images) == 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "No images were uploaded"})
+		return
+	}
+
+	if err := db.AddPostImages(postID, images); err != nil {
+		writePostImageError(w, err)
+		return
+	}
+	writePostImages(w, postID)
+}
+
+// UpdatePostImageHandler sets the alt text and caption of a gallery image.
+func UpdatePostImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		PostID      int    `json:"post_id"`
+		PostImageID int    `json:"post_image_id"`
+		AltText     string `json:"alt_text"`
+		Caption     string `json:"caption"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PostID <= 0 || req.PostImageID <= 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+	req.AltText = strings.TrimSpace(req.AltText)
+	req.Caption = strings.TrimSpace(req.Caption)
+	if len(req.AltText) > maxImageTextLength || len(req.Caption) > maxImageTextLength {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": errImageText.Error()})
+		return
+	}
+	if !requirePostAuthor(w, req.PostID, userID, false) {
+		return
+	}
+
+	if err := db.UpdatePostImage(req.PostID, req.PostImageID, req.AltText, req.Caption); err != nil {
+		writePostImageError(w, err)
+		return
+	}
+	writePostImages(w, req.PostID)
+}
+
+// RemovePostImageHandler takes an image out of a post's gallery. Moderators
+// can remove images from any post.
+func RemovePostImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		PostID      int `json:"post_id"`
+		PostImageID int `json:"post_image_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PostID <= 0 || req.PostImageID <= 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+	if !requirePostAuthor(w, req.PostID, userID, true) {
+		return
+	}
+
+	if err := db.RemovePostImage(req.PostID, req.PostImageID); err != nil {
+		writePostImageError(w, err)
+		return
+	}
+	writePostImages(w, req.PostID)
+}
+
+// ReorderPostImagesHandler puts a post's gallery in the given order. The
+// first image becomes the cover.
+func ReorderPostImagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		PostID       int   `json:"post_id"`
+		PostImageIDs []int `json:"post_image_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PostID <= 0 || len(req.PostImageIDs) == 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+	if !requirePostAuthor(w, req.PostID, userID, false) {
+		return
+	}
+
+	if err := db.ReorderPostImages(req.PostID, req.PostImageIDs); err != nil {
+		writePostImageError(w, err)
+		return
+	}
+	writePostImages(w, req.PostID)
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"real/db"
+
+	rt_hub "real/websocket"
+)
+
+const announcementsLimit = 20
+
+func writePostModerationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrPostNotFound):
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": "Post not found"})
+	case errors.Is(err, db.ErrPinCategory):
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		log.Printf("Post moderation error: %v", err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+	}
+}
+
+// PinPostHandler lets a moderator pin a post to the top of a category, or of
+// the unfiltered post list when category_id is 0 or left out, or unpin it
+// with "pinned": false.
+func PinPostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	moderatorID, ok := requireModerator(w, r)
+	if !ok {
+		return
+	}
+
+	req := struct {
+		PostID     int  `json:"post_id"`
+		CategoryID int  `json:"category_id"`
+		Pinned     bool `json:"pinned"`
+	}{Pinned: true}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PostID <= 0 || req.CategoryID < 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	var err error
+	if req.Pinned {
+		err = db.PinPost(req.PostID, req.CategoryID, moderatorID)
+	} else {
+		err = db.UnpinPost(req.PostID, req.CategoryID)
+	}
+	if err != nil {
+		writePostModerationError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success":     true,
+		"post_id":     req.PostID,
+		"category_id": req.CategoryID,
+		"pinned":      req.Pinned,
+	})
+}
+
+// LockPostHandler lets a moderator stop (or, with "locked": false, allow
+// again) new comments on a post.
+func LockPostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	moderatorID, ok := requireModerator(w, r)
+	if !ok {
+		return
+	}
+
+	req := struct {
+		PostID int  `json:"post_id"`
+		Locked bool `json:"locked"`
+	}{Locked: true}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PostID <= 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	if err := db.SetPostLocked(req.PostID, req.Locked, moderatorID); err != nil {
+		writePostModerationError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true, "post_id": req.PostID, "locked": req.Locked})
+}
+
+// AnnouncePostHandler lets a moderator mark a post as an announcement, or
+// unmark it with "announcement": false. Connected users are sent an
+// "announcement" event the first time; everyone else finds it through
+// AnnouncementsHandler.
+func AnnouncePostHandler(hub *rt_hub.Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	if _, ok := requireModerator(w, r); !ok {
+		return
+	}
+
+	req := struct {
+		PostID       int  `json:"post_id"`
+		Announcement bool `json:"announcement"`
+	}{Announcement: true}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PostID <= 0 {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	announced, err := db.SetPostAnnouncement(req.PostID, req.Announcement)
+	if err != nil {
+		writePostModerationError(w, err)
+		return
+	}
+	if announced {
+		summary, err := db.GetPostSummary(int64(req.PostID))
+		if err != nil {
+			log.Printf("Error loading post %d for announcement: %v", req.PostID, err)
+		} else if err := hub.BroadcastEvent("announcement", summary); err != nil {
+			log.Printf("Error broadcasting announcement %d: %v", req.PostID, err)
+		}
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"success":      true,
+		"post_id":      req.PostID,
+		"announcement": req.Announcement,
+		"notified":     announced,
+	})
+}
+
+// AnnouncementsHandler lists the latest announcements for the session user
+// (GET, ?unread=true for the ones they have not dismissed), or dismisses one
+// (POST {post_id}).
+func AnnouncementsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		announcements, err := db.GetAnnouncements(userID, r.URL.Query().Get("unread") == "true", announcementsLimit)
+		if err != nil {
+			log.Printf("Error loading announcements for %d: %v", userID, err)
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+			return
+		}
+		WriteJSON(w, http.StatusOK, announcements)
+
+	case http.MethodPost:
+		var req struct {
+			PostID int `json:"post_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PostID <= 0 {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+			return
+		}
+		if err := db.MarkAnnouncementRead(userID, req.PostID); err != nil {
+			if errors.Is(err, db.ErrPostNotFound) {
+				WriteJSON(w, http.StatusNotFound, map[string]string{"error": "Announcement not found"})
+				return
+			}
+			writePostModerationError(w, err)
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true, "post_id": req.PostID})
+
+	default:
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
@@ -1,31 +1,41 @@
 package handlers
 
 import (
-	"database/sql"
 	"encoding/json"
-	"io"
+	"errors"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
 
 	"real/auth"
 	"real/db"
+	"real/models"
+	"real/storage"
 
-	"github.com/google/uuid"
+	rt_hub "real/websocket"
 )
 
-func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
-	userID, ok := auth.GetUserID(r)
-	
-	if !ok || userID == "" {
+// CreatePostHandler publishes a new post. When a future publish_at is sent
+// the post is saved as a scheduled draft instead and published later by
+// ScheduleDraftPublisher.
+func CreatePostHandler(hub *rt_hub.Hub, w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetCurrentUserID(r)
+	if userID == 0 {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	if !requireCanPost(w, userID) {
+		return
+	}
 
 	// Parse form with file upload
+	limitUploadBody(w, r)
 	if err := r.ParseMultipartForm(20 << 20); err != nil { 
+		if isTooLarge(err) {
+			http.Error(w, "Upload is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
 	}
@@ -33,161 +43,152 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	// Get form values
 	title := strings.TrimSpace(r.FormValue("title"))
 	content := strings.TrimSpace(r.FormValue("content"))
-	categories := r.Form["category"] // Gets all selected categories
-
-	// Validate inputs
-	if title == "" || content == "" {
-		http.Error(w, "Title and content are required", http.StatusBadRequest)
+	categoryIDs, err := parseCategoryIDs(r.Form["category"]) // Gets all selected categories
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	if len(categories) == 0 {
-		http.Error(w, "At least one category is required", http.StatusBadRequest)
+	tags, err := parseTags(r.Form["tags"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	pollRaw := strings.TrimSpace(r.FormValue("poll"))
 
-	// Process image upload if exists
-	var imgURL string
-	file, header, err := r.FormFile("img")
-	if err == nil {
-		defer file.Close()
-		
-		
-
-		// Validate image
-		if !strings.HasSuffix(strings.ToLower(header.Filename), ".jpg") &&
-			!strings.HasSuffix(strings.ToLower(header.Filename), ".jpeg") &&
-			!strings.HasSuffix(strings.ToLower(header.Filename), ".png") {
-			http.Error(w, "Only JPG, JPEG, and PNG images are allowed", http.StatusBadRequest)
-			return
-		}
-
-		// Create upload directory if not exists
-		uploadDir := "static/images/posts"
-		if err := os.MkdirAll(uploadDir, 0o755); err != nil {
-			
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-
-		// Create unique filename
-		uniqueID := uuid.New().String()
-		ext := filepath.Ext(header.Filename)
-		newFilename := uniqueID + ext
-		dstPath := filepath.Join(uploadDir, newFilename)
-
-
-		// Save file
-		dst, err := os.Create(dstPath)
-		if err != nil {
-			
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-		defer dst.Close()
-
-		if _, err := io.Copy(dst, file); err != nil {
-			
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-
-		
-		imgURL = "/static/images/posts/" + newFilename
-		
-		
-		
-		
-		// Verify file was created
-		if _, err := os.Stat(dstPath); os.IsNotExist(err) {
-			log.Printf("Warning: File was not created at %s", dstPath)
-		} 
-	} else if err != http.ErrMissingFile {
-		log.Printf("Error processing file upload: %v", err)
-	}
-
-	// Start database transaction
-	tx, err := db.DB.Begin()
+	// Validate inputs
+	post, err := newPost(userID, title, content, categoryIDs, tags, pollRaw)
 	if err != nil {
-		
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer tx.Rollback()
-
-	// Insert post
-	var result sql.Result
-	if imgURL != "" {
-		
-		result, err = tx.Exec(
-			"INSERT INTO posts (user_id, title, content, imgurl) VALUES (?, ?, ?, ?)",
-			userID, title, content, imgURL,
-		)
-	} else {
-		
-		result, err = tx.Exec(
-			"INSERT INTO posts (user_id, title, content) VALUES (?, ?, ?)",
-			userID, title, content,
-		)
-	}
 
+	publishAt, err := parsePublishAt(r.FormValue("publish_at"))
 	if err != nil {
-		
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Get post ID
-	postID, err := result.LastInsertId()
+	// Process image uploads if any; the first one is the cover image
+	images, err := saveUploadedImages(r, userID)
 	if err != nil {
-		
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		status, message := uploadError(err)
+		http.Error(w, message, status)
 		return
 	}
+	post.Images = images
+	var imgURL string
+	if len(images) > 0 {
+		imgURL = storage.URL(images[0].URL)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 
-	// Insert categories
-	for _, catID := range categories {
-		_, err = tx.Exec(
-			"INSERT INTO post_categories (post_id, category_id) VALUES (?, ?)",
-			postID, catID,
-		)
+	// Scheduled posts wait as drafts until the publisher picks them up
+	if publishAt != nil {
+		draftID, err := db.SaveDraft(models.PostDraft{
+			UserID:      userID,
+			Title:       title,
+			Content:     content,
+			CategoryIDs: categoryIDs,
+			Tags:        tags,
+			Images:      images,
+			Poll:        rawPoll(pollRaw),
+			PublishAt:   publishAt,
+		})
 		if err != nil {
-			
+			log.Printf("Error scheduling post: %v", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"message":    "Post scheduled successfully",
+			"scheduled":  true,
+			"draft_id":   draftID,
+			"publish_at": publishAt,
+			"image_url":  imgURL,
+		})
+		return
 	}
 
-	// Commit transaction
-	if err := tx.Commit(); err != nil {
-		
+	postID, pollID, err := db.CreatePost(post)
+	if err != nil {
+		log.Printf("Error creating post: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-
-	
+	announcePost(hub, postID)
 
 	// Return success response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	response := map[string]interface{}{
 		"success":  true,
 		"message":  "Post created successfully",
 		"post_id":  postID,
 		"image_url": imgURL,
-	})
+		"images":    postGallery(int(postID)),
+	}
+	if post.Poll != nil {
+		response["poll_id"] = pollID
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// newPost validates the fields shared by CreatePostHandler and draft
+// publishing. Errors are meant to be shown to the author.
+func newPost(userID int, title, content string, categoryIDs []int, tags []string, pollRaw string) (models.NewPost, error) {
+	post := models.NewPost{UserID: userID, Title: title, Content: content, CategoryIDs: categoryIDs, Tags: tags}
+
+	if title == "" || content == "" {
+		return post, errors.New("Title and content are required")
+	}
+
+	if len(categoryIDs) == 0 {
+		return post, errors.New("At least one category is required")
+	}
+	if err := db.CheckCategoryIDs(categoryIDs); err != nil {
+		if errors.Is(err, db.ErrCategoryNotFound) || errors.Is(err, db.ErrCategoryArchived) {
+			return post, err
+		}
+		log.Printf("Error checking categories %v: %v", categoryIDs, err)
+		return post, errors.New("Could not check categories, please try again")
+	}
+
+	// Optional poll, sent as a JSON object in the "poll" field
+	poll, err := parsePollInput(pollRaw)
+	if err != nil {
+		return post, err
+	}
+	post.Poll = poll
+
+	return post, nil
 }
 
-func GetCategoriesHandler(w http.ResponseWriter, r *http.Request) {
-    categories, err := db.GetAllCategories()
-    if err != nil {
-        
-        http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
-        return
-    }
-
-    w.Header().Set("Content-Type", "application/json")
-    if err := json.NewEncoder(w).Encode(categories); err != nil {
-        
-        http.Error(w, "Internal server error", http.StatusInternalServerError)
-    }
-}
\ No newline at end of file
+// parseCategoryIDs converts the submitted "category" values to IDs, dropping
+// repeats.
+func parseCategoryIDs(values []string) ([]int, error) {
+	var ids []int
+	seen := make(map[int]bool)
+	for _, v := range values {
+		id, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || id <= 0 {
+			return nil, errors.New("Invalid category")
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// announcePost tells connected clients about a newly published post.
+func announcePost(hub *rt_hub.Hub, postID int64) {
+	summary, err := db.GetPostSummary(postID)
+	if err != nil {
+		log.Printf("Error loading post %d for broadcast: %v", postID, err)
+		return
+	}
+	if err := hub.BroadcastEvent("new_post", summary); err != nil {
+		log.Printf("Error broadcasting post %d: %v", postID, err)
+	}
+}
@@ -8,9 +8,9 @@ import (
 	"strconv"
 	"time"
 
+	"real/auth"
 	"real/db"
 
-	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -131,13 +131,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create session
-	sessionID := uuid.New().String()
-	expiresAt := time.Now().Add(24 * time.Hour)
-
-	_, err = tx.Exec(
-		`INSERT INTO sessions (session_id, user_id, expires_at) VALUES (?, ?, ?)`,
-		sessionID, userID, expiresAt,
-	)
+	session, err := auth.CreateSession(tx, r, int(userID), false)
 	if err != nil {
 		log.Printf("Session creation error: %v", err)
 		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
@@ -152,19 +146,17 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Set cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    sessionID,
-		Path:     "/",
-		Expires:  expiresAt,
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-	})
+	auth.SetSessionCookie(w, session)
 
 	// Update user status to online
 	db.UpdateUserStatus(int(userID), true)
 
+	go func() {
+		if err := sendVerificationEmail(int(userID), formData["username"], formData["email"]); err != nil {
+			log.Printf("Error sending verification email to %d: %v", userID, err)
+		}
+	}()
+
 	// Success response
 	WriteJSON(w, http.StatusCreated, map[string]interface{}{
 		"success": true,
@@ -227,6 +219,16 @@ func validateRegistrationInput(username, ageStr, gender, firstname, lastname, em
 		errors["email"] = "Invalid email format"
 	}
 
+	for field, message := range validatePassword(password, confirmpassword) {
+		errors[field] = message
+	}
+
+	return errors
+}
+
+// validatePassword checks a new password and its confirmation.
+func validatePassword(password, confirmpassword string) map[string]string {
+	errors := make(map[string]string)
 	if password == "" {
 		errors["password"] = "Password is required"
 	} else if len(password) < 6 {
@@ -237,6 +239,5 @@ func validateRegistrationInput(username, ageStr, gender, firstname, lastname, em
 	} else if confirmpassword != password {
 		errors["confirmPassword"] = "Passwords do not match"
 	}
-
 	return errors
 }
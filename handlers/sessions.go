@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"real/auth"
+
+	rt_hub "real/websocket"
+)
+
+// SessionsHandler lists the devices the session user is logged in on, most
+// recently used first. Each has an id for RevokeSessionHandler.
+func SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	session, ok := auth.CurrentSession(r)
+	if !ok {
+		WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	sessions, err := auth.ListSessions(session.UserID, session.ID)
+	if err != nil {
+		log.Printf("Error listing sessions for %d: %v", session.UserID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, sessions)
+}
+
+// RevokeSessionHandler logs the session user out of one device, given its id
+// from SessionsHandler, closing that device's realtime connection. Revoking
+// the current session also clears its cookie.
+func RevokeSessionHandler(hub *rt_hub.Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	session, ok := auth.CurrentSession(r)
+	if !ok {
+		WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	sessionID, err := auth.RevokeSession(session.UserID, req.ID)
+	if errors.Is(err, auth.ErrSessionNotFound) {
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": "Session not found"})
+		return
+	} else if err != nil {
+		log.Printf("Error revoking session of %d: %v", session.UserID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	hub.CloseSessions(sessionID)
+
+	current := sessionID == session.ID
+	if current {
+		auth.ClearSessionCookie(w)
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true, "id": req.ID, "current": current})
+}
+
+// RevokeOtherSessionsHandler logs the session user out of every device but
+// the one making the request.
+func RevokeOtherSessionsHandler(hub *rt_hub.Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	session, ok := auth.CurrentSession(r)
+	if !ok {
+		WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	revoked, err := auth.RevokeOtherSessions(session.UserID, session.ID)
+	// Close whatever was revoked even if a later delete failed
+	hub.CloseSessions(revoked...)
+	if err != nil {
+		log.Printf("Error revoking sessions of %d: %v", session.UserID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true, "revoked": len(revoked)})
+}
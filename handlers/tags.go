@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"real/auth"
+	"real/db"
+)
+
+const (
+	maxTagsPerPost   = 5
+	maxTagLength     = 30
+	tagSearchLimit   = 10
+	relatedTagsLimit = 10
+	maxTrendingDays  = 90
+)
+
+// normalizeTag turns user input into a tag name: lower case letters and
+// digits, with words joined by single dashes, so "#Go Lang" and "go_lang" are
+// the same tag. It returns "" when nothing usable is left.
+func normalizeTag(raw string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(raw)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		case unicode.IsSpace(r) || r == '-' || r == '_':
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// parseTags reads the submitted "tags" values, each of which may hold several
+// comma-separated tags, and returns them normalised without repeats.
+func parseTags(values []string) ([]string, error) {
+	tags := []string{}
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, raw := range strings.Split(value, ",") {
+			tag := normalizeTag(raw)
+			if tag == "" || seen[tag] {
+				continue
+			}
+			if utf8.RuneCountInString(tag) > maxTagLength {
+				return nil, fmt.Errorf("Tags must be at most %d characters", maxTagLength)
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxTagsPerPost {
+		return nil, fmt.Errorf("A post can have at most %d tags", maxTagsPerPost)
+	}
+	return tags, nil
+}
+
+// splitTagNames splits the comma-separated tag names of a post list row.
+func splitTagNames(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+// tagFilter returns the condition limiting a post list to the tags in query:
+// posts with every tag, or with any of them when tag_match=any. The tags come
+// from repeated "tag" parameters or a comma-separated "tags" one.
+func tagFilter(query map[string][]string) (string, []interface{}) {
+	var tags []interface{}
+	seen := make(map[string]bool)
+	for _, value := range append(query["tag"], query["tags"]...) {
+		for _, raw := range strings.Split(value, ",") {
+			if tag := normalizeTag(raw); tag != "" && !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	if len(tags) == 0 {
+		return "", nil
+	}
+
+	exists := `EXISTS (SELECT 1 FROM post_tags fpt JOIN tags ft ON ft.tag_id = fpt.tag_id
+		WHERE fpt.post_id = p.post_id AND ft.name IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(tags)), ", ") + `)`
+	if len(query["tag_match"]) > 0 && query["tag_match"][0] == "any" {
+		return exists + ")", tags
+	}
+	return exists + ` GROUP BY fpt.post_id HAVING COUNT(*) = ` + strconv.Itoa(len(tags)) + `)`, tags
+}
+
+// TagsHandler suggests tags for autocomplete: the most used tags starting
+// with q, or the most used overall when q is empty.
+func TagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > tagSearchLimit {
+		limit = tagSearchLimit
+	}
+
+	tags, err := db.SearchTags(normalizeTag(r.URL.Query().Get("q")), limit)
+	if err != nil {
+		log.Printf("Error searching tags: %v", err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, tags)
+}
+
+// TagPageHandler returns a tag with its post count, the tags most often used
+// alongside it, and its posts newest first, paginated with limit and offset.
+func TagPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	name := normalizeTag(r.URL.Query().Get("name"))
+	tag, err := db.GetTag(name)
+	if errors.Is(err, db.ErrTagNotFound) {
+		WriteJSON(w, http.StatusNotFound, map[string]string{"error": "Tag not found"})
+		return
+	} else if err != nil {
+		log.Printf("Error loading tag %q: %v", name, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	related, err := db.RelatedTags(name, relatedTagsLimit)
+	if err != nil {
+		log.Printf("Error loading tags related to %q: %v", name, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	viewerID := auth.GetCurrentUserID(r)
+	limit, offset := pageParams(r)
+	condition, args := tagFilter(map[string][]string{"tag": {name}})
+	posts, err := queryPosts(viewerID, noPins, "", []string{condition}, append(args, limit+1, offset), " LIMIT ? OFFSET ?")
+	if err != nil {
+		log.Printf("Error loading posts tagged %q: %v", name, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	response := postPage(posts, limit, offset)
+	response["tag"] = tag
+	response["related"] = related
+	WriteJSON(w, http.StatusOK, response)
+}
+
+// TrendingTagsHandler ranks tags by how many posts got them in the last days
+// days (default 7), with the count for the days before that for comparison.
+func TrendingTagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days <= 0 || days > maxTrendingDays {
+		days = 7
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > tagSearchLimit {
+		limit = tagSearchLimit
+	}
+
+	tags, err := db.TrendingTags(time.Now(), time.Duration(days)*24*time.Hour, limit)
+	if err != nil {
+		log.Printf("Error loading trending tags: %v", err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"days": days, "tags": tags})
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"real/auth"
+	"real/db"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LoginTwoFactorHandler completes a login that LoginHandler left pending
+// because the user has two-factor authentication on. It takes the
+// pending_token from LoginHandler and a code from the user's authenticator
+// app or one of their recovery codes, and responds like a successful login.
+func LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"message": "Method not allowed"})
+		return
+	}
+
+	var req struct {
+		PendingToken string `json:"pending_token"`
+		Code         string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PendingToken == "" || req.Code == "" {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"message": "Invalid request body"})
+		return
+	}
+
+	userID, err := auth.PendingLoginUser(req.PendingToken)
+	if errors.Is(err, auth.ErrPendingLogin) {
+		WriteJSON(w, http.StatusUnauthorized, map[string]interface{}{"success": false, "message": "Login expired, please log in again"})
+		return
+	} else if err != nil {
+		log.Printf("Error loading pending login: %v", err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"message": "Internal server error"})
+		return
+	}
+
+	var username, email string
+	if err := db.DB.QueryRow(`SELECT username, email FROM users WHERE user_id = ?`, userID).Scan(&username, &email); err != nil {
+		log.Printf("Error loading user %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"message": "Internal server error"})
+		return
+	}
+
+	// Codes are throttled like passwords, under the account's username
+	attemptID, wait, err := auth.ReserveLogin(r, userID, username)
+	if err != nil {
+		log.Printf("Error checking login attempts: %v", err)
+		cancelLogin(attemptID)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"message": "Internal server error"})
+		return
+	}
+	if wait > 0 {
+		writeLoginThrottled(w, wait)
+		return
+	}
+
+	_, remember, err := auth.CompletePendingLogin(req.PendingToken, req.Code)
+	switch {
+	case errors.Is(err, auth.ErrInvalidCode):
+		finishLogin(attemptID, false, auth.LoginBadCode)
+		WriteJSON(w, http.StatusUnauthorized, map[string]interface{}{"success": false, "message": "Invalid code"})
+		return
+	case errors.Is(err, auth.ErrPendingLogin), errors.Is(err, auth.ErrTwoFactorNotEnabled):
+		cancelLogin(attemptID)
+		WriteJSON(w, http.StatusUnauthorized, map[string]interface{}{"success": false, "message": "Login expired, please log in again"})
+		return
+	case err != nil:
+		log.Printf("Error completing pending login: %v", err)
+		cancelLogin(attemptID)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"message": "Internal server error"})
+		return
+	}
+
+	finishLogin(attemptID, true, "")
+	w.Header().Set("Content-Type", "application/json")
+	startSession(w, r, userID, username, email, remember)
+}
+
+// TwoFactorHandler reports whether the session user has two-factor
+// authentication on, and how many unused recovery codes they have left.
+func TwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	enabled, err := auth.TwoFactorEnabled(userID)
+	if err != nil {
+		log.Printf("Error checking two-factor authentication of %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	remaining, err := auth.RemainingRecoveryCodes(userID)
+	if err != nil {
+		log.Printf("Error counting recovery codes of %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"enabled": enabled, "recovery_codes_remaining": remaining})
+}
+
+// EnrollTwoFactorHandler starts turning on two-factor authentication for the
+// session user. It returns a new TOTP secret and its otpauth URI for their
+// authenticator app; nothing changes until ConfirmTwoFactorHandler gets a
+// code generated from it.
+func EnrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var email string
+	if err := db.DB.QueryRow(`SELECT email FROM users WHERE user_id = ?`, userID).Scan(&email); err != nil {
+		log.Printf("Error loading user %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+
+	secret, uri, err := auth.BeginTOTPEnrollment(userID, email)
+	if errors.Is(err, auth.ErrTwoFactorEnabled) {
+		WriteJSON(w, http.StatusConflict, map[string]string{"error": "Two-factor authentication is already enabled"})
+		return
+	} else if err != nil {
+		log.Printf("Error starting two-factor enrollment of %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]string{"secret": secret, "otpauth_uri": uri})
+}
+
+// ConfirmTwoFactorHandler turns on two-factor authentication once the session
+// user sends a code from the secret EnrollTwoFactorHandler gave them. The
+// response holds their recovery codes, which cannot be shown again.
+func ConfirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	codes, err := auth.ConfirmTOTPEnrollment(userID, req.Code)
+	switch {
+	case errors.Is(err, auth.ErrInvalidCode):
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid code"})
+	case errors.Is(err, auth.ErrNoEnrollment):
+		WriteJSON(w, http.StatusConflict, map[string]string{"error": "Start enrollment first"})
+	case errors.Is(err, auth.ErrTwoFactorEnabled):
+		WriteJSON(w, http.StatusConflict, map[string]string{"error": "Two-factor authentication is already enabled"})
+	case err != nil:
+		log.Printf("Error confirming two-factor enrollment of %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+	default:
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true, "recovery_codes": codes})
+	}
+}
+
+// RecoveryCodesHandler replaces the session user's recovery codes with new
+// ones, given their password.
+func RecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requirePassword(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := auth.RegenerateRecoveryCodes(userID)
+	if errors.Is(err, auth.ErrTwoFactorNotEnabled) {
+		WriteJSON(w, http.StatusConflict, map[string]string{"error": "Two-factor authentication is not enabled"})
+		return
+	} else if err != nil {
+		log.Printf("Error regenerating recovery codes of %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true, "recovery_codes": codes})
+}
+
+// DisableTwoFactorHandler turns two-factor authentication off for the
+// session user, given their password.
+func DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	userID, ok := requirePassword(w, r)
+	if !ok {
+		return
+	}
+
+	err := auth.DisableTwoFactor(userID)
+	if errors.Is(err, auth.ErrTwoFactorNotEnabled) {
+		WriteJSON(w, http.StatusConflict, map[string]string{"error": "Two-factor authentication is not enabled"})
+		return
+	} else if err != nil {
+		log.Printf("Error disabling two-factor authentication of %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// requirePassword returns the session user when the JSON body of r repeats
+// their password, and otherwise writes an error response.
+func requirePassword(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return 0, false
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" {
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "Password required"})
+		return 0, false
+	}
+
+	var passwordHash string
+	err := db.DB.QueryRow(`SELECT password FROM users WHERE user_id = ?`, userID).Scan(&passwordHash)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error loading password of %d: %v", userID, err)
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		return 0, false
+	}
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)) != nil {
+		WriteJSON(w, http.StatusForbidden, map[string]string{"error": "Incorrect password"})
+		return 0, false
+	}
+	return userID, true
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"real/config"
+	"real/db"
+	"real/imaging"
+	"real/models"
+	"real/storage"
+)
+
+const (
+	uploadPrefix       = "posts/"
+	maxImageTextLength = 500
+)
+
+var errImageText = fmt.Errorf("image alt text and captions must be at most %d characters", maxImageTextLength)
+
+// imageLimits bound what an upload may be before it is decoded.
+var imageLimits = imaging.Limits{
+	MaxBytes:  int64(config.Int("IMAGE_MAX_UPLOAD_MB", 10)) << 20,
+	MaxWidth:  imaging.DefaultLimits.MaxWidth,
+	MaxHeight: imaging.DefaultLimits.MaxHeight,
+	MaxPixels: config.Int("IMAGE_MAX_PIXELS", imaging.DefaultLimits.MaxPixels),
+}
+
+// limitUploadBody caps a form body at a full gallery of the largest images
+// plus room for the other fields. Each image is checked against
+// imageLimits.MaxBytes on its own by saveImageFile.
+func limitUploadBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(db.MaxPostImages)*imageLimits.MaxBytes+1<<20)
+}
+
+// isTooLarge reports whether parsing a form failed on limitUploadBody.
+func isTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// saveUploadedImages processes every "img" file of a multipart form, in
+// order, pairing each with the "image_alt" and "image_caption" values at the
+// same index. Images stored for a request that then fails are not used by
+// anything, and are removed by the media cleanup job.
+func saveUploadedImages(r *http.Request, userID int) ([]models.PostImage, error) {
+	if r.MultipartForm == nil {
+		return nil, nil
+	}
+	files := r.MultipartForm.File["img"]
+	if len(files) > db.MaxPostImages {
+		return nil, db.ErrTooManyImages
+	}
+
+	alts, captions := r.MultipartForm.Value["image_alt"], r.MultipartForm.Value["image_caption"]
+	images := make([]models.PostImage, len(files))
+	for i := range files {
+		if i < len(alts) {
+			images[i].AltText = strings.TrimSpace(alts[i])
+		}
+		if i < len(captions) {
+			images[i].Caption = strings.TrimSpace(captions[i])
+		}
+		if len(images[i].AltText) > maxImageTextLength || len(images[i].Caption) > maxImageTextLength {
+			return nil, errImageText
+		}
+	}
+
+	for i, fh := range files {
+		key, err := saveImageFile(fh, userID)
+		if err != nil {
+			return nil, err
+		}
+		images[i].URL = key
+	}
+	return images, nil
+}
+
+// saveImageFile processes one upload and stores every generated size under
+// posts/ in the media storage. It returns the key of the "full" size.
+// Files are named after the SHA-256 of the upload, so a file that was
+// uploaded before is not processed or stored again.
+func saveImageFile(fh *multipart.FileHeader, userID int) (string, error) {
+	file, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, imageLimits.MaxBytes+1))
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	mediaLock.RLock()
+	defer mediaLock.RUnlock()
+	if key, err := db.ReuseImage(hash); err != nil || key != "" {
+		return key, err
+	}
+
+	result, err := imaging.Process(data, imageLimits)
+	if err != nil {
+		return "", err
+	}
+
+	// The full size is <hash>.<ext>; the others get a suffix
+	img := models.Image{
+		UserID:      userID,
+		ContentHash: hash,
+		ContentType: result.ContentType,
+		Width:       result.Width,
+		Height:      result.Height,
+	}
+	for _, v := range result.Variants {
+		key := uploadPrefix + hash + "_" + v.Name + result.Ext
+		if v.Name == "full" {
+			key = uploadPrefix + hash + result.Ext
+		}
+		if err := storage.Media.Put(key, v.Data, result.ContentType); err != nil {
+			return "", err
+		}
+
+		if v.Name == "full" {
+			img.URL = key
+			img.SizeBytes = int64(len(v.Data))
+		}
+		img.Variants = append(img.Variants, models.ImageVariant{Name: v.Name, URL: key, Width: v.Width, Height: v.Height})
+	}
+
+	if _, err := db.SaveImage(img); err != nil {
+		return "", err
+	}
+	return img.URL, nil
+}
+
+// uploadError maps a failed saveUploadedImages call to a status and a message
+// for the author. Rejected images get a 4xx explaining why.
+func uploadError(err error) (int, string) {
+	switch {
+	case errors.Is(err, imaging.ErrUnsupportedFormat), errors.Is(err, imaging.ErrCorrupt):
+		return http.StatusBadRequest, imaging.ErrUnsupportedFormat.Error()
+	case errors.Is(err, imaging.ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("Image must be at most %d MB", imageLimits.MaxBytes>>20)
+	case errors.Is(err, imaging.ErrDimensions):
+		return http.StatusBadRequest, "Image dimensions are too large"
+	case errors.Is(err, db.ErrTooManyImages), errors.Is(err, errImageText):
+		return http.StatusBadRequest, err.Error()
+	}
+	log.Printf("Error saving uploaded image: %v", err)
+	return http.StatusInternalServerError, "Internal server error"
+}
+
+// srcset formats image variants for an <img srcset> attribute.
+func srcset(variants []models.ImageVariant) string {
+	parts := make([]string, len(variants))
+	for i, v := range variants {
+		parts[i] = storage.URL(v.URL) + " " + strconv.Itoa(v.Width) + "w"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// presentImages prepares gallery images for a response: it fills in their
+// srcset fields from variants, keyed by media key, and turns the keys into
+// URLs.
+func presentImages(images []models.PostImage, variants map[string][]models.ImageVariant) {
+	for i := range images {
+		if v := variants[images[i].URL]; len(v) > 0 {
+			images[i].Srcset = srcset(v)
+			images[i].ThumbURL = storage.URL(v[0].URL)
+			images[i].Width = v[len(v)-1].Width
+			images[i].Height = v[len(v)-1].Height
+		}
+		images[i].URL = storage.URL(images[i].URL)
+	}
+}
+
+// imageKeys lists the media keys of images, for GetImageVariants.
+func imageKeys(images []models.PostImage) []string {
+	keys := make([]string, len(images))
+	for i, img := range images {
+		keys[i] = img.URL
+	}
+	return keys
+}
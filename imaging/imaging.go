@@ -0,0 +1,225 @@
+// Package imaging validates uploaded images and produces the resized
+// versions served to clients. Every output is re-encoded from decoded pixels,
+// so EXIF data, including GPS coordinates, never reaches the server's disk.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("Only JPG, JPEG, and PNG images are allowed")
+	ErrFileTooLarge      = errors.New("image file is too large")
+	ErrDimensions        = errors.New("image dimensions are too large")
+	ErrCorrupt           = errors.New("image could not be read")
+)
+
+// Limits guard against oversized uploads and decompression bombs: a small
+// file can declare enormous dimensions, so they are checked from the header
+// before any pixels are decoded.
+type Limits struct {
+	MaxBytes  int64
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int
+}
+
+// DefaultLimits allow typical phone photos with room to spare.
+var DefaultLimits = Limits{
+	MaxBytes:  10 << 20,
+	MaxWidth:  10000,
+	MaxHeight: 10000,
+	MaxPixels: 24000000,
+}
+
+// Size is a named output width. Images are never scaled up, so a size wider
+// than the source is skipped.
+type Size struct {
+	Name  string
+	Width int
+}
+
+// Sizes generated for every upload. "full" caps what is served as the
+// original.
+var Sizes = []Size{
+	{Name: "thumb", Width: 320},
+	{Name: "medium", Width: 1024},
+	{Name: "full", Width: 2048},
+}
+
+// Variant is one encoded output image.
+type Variant struct {
+	Name   string
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// Result is a processed upload. ContentType and Ext describe every variant:
+// opaque images are stored as JPEG, images with transparency as PNG.
+type Result struct {
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+	Variants    []Variant
+}
+
+// Variant returns the named variant, or false when it was skipped.
+func (r *Result) Variant(name string) (Variant, bool) {
+	for _, v := range r.Variants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Variant{}, false
+}
+
+// Process checks that data really is a JPEG or PNG within limits, whatever
+// its file name claims, and returns it re-encoded at each of Sizes. The
+// "full" variant is always present.
+func Process(data []byte, limits Limits) (*Result, error) {
+	if limits.MaxBytes > 0 && int64(len(data)) > limits.MaxBytes {
+		return nil, ErrFileTooLarge
+	}
+
+	sniffed := http.DetectContentType(data)
+	if sniffed != "image/jpeg" && sniffed != "image/png" {
+		return nil, ErrUnsupportedFormat
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	if "image/"+format != sniffed {
+		return nil, ErrUnsupportedFormat
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrCorrupt
+	}
+	if (limits.MaxWidth > 0 && cfg.Width > limits.MaxWidth) ||
+		(limits.MaxHeight > 0 && cfg.Height > limits.MaxHeight) ||
+		(limits.MaxPixels > 0 && cfg.Width*cfg.Height > limits.MaxPixels) {
+		return nil, ErrDimensions
+	}
+
+	var img image.Image
+	if format == "jpeg" {
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	} else {
+		img, err = png.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+
+	src := toNRGBA(img)
+	if format == "jpeg" {
+		// The orientation tag is about to be stripped along with the rest of
+		// the EXIF data, so apply it to the pixels first.
+		src = orient(src, exifOrientation(data))
+	}
+
+	result := &Result{
+		ContentType: "image/jpeg",
+		Ext:         ".jpg",
+		Width:       src.Rect.Dx(),
+		Height:      src.Rect.Dy(),
+	}
+	if !src.Opaque() {
+		result.ContentType = "image/png"
+		result.Ext = ".png"
+	}
+
+	for _, size := range Sizes {
+		if size.Width >= result.Width && size.Name != "full" {
+			continue
+		}
+		out := src
+		if size.Width < result.Width {
+			height := result.Height * size.Width / result.Width
+			if height < 1 {
+				height = 1
+			}
+			out = resize(src, size.Width, height)
+		}
+
+		var buf bytes.Buffer
+		if result.ContentType == "image/png" {
+			err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, out)
+		} else {
+			err = jpeg.Encode(&buf, out, &jpeg.Options{Quality: 85})
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Variants = append(result.Variants, Variant{
+			Name:   size.Name,
+			Width:  out.Rect.Dx(),
+			Height: out.Rect.Dy(),
+			Data:   buf.Bytes(),
+		})
+	}
+	return result, nil
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) {
+		return n
+	}
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+	return dst
+}
+
+// resize scales src down to w×h by averaging the source pixels covered by
+// each destination pixel, weighting colour by alpha so transparent pixels do
+// not darken edges.
+func resize(src *image.NRGBA, w, h int) *image.NRGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		sy0, sy1 := y*sh/h, (y+1)*sh/h
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+		for x := 0; x < w; x++ {
+			sx0, sx1 := x*sw/w, (x+1)*sw/w
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				i := src.PixOffset(sx0, sy)
+				for sx := sx0; sx < sx1; sx++ {
+					pa := uint64(src.Pix[i+3])
+					r += uint64(src.Pix[i]) * pa
+					g += uint64(src.Pix[i+1]) * pa
+					b += uint64(src.Pix[i+2]) * pa
+					a += pa
+					n++
+					i += 4
+				}
+			}
+
+			o := dst.PixOffset(x, y)
+			if a > 0 {
+				dst.Pix[o] = uint8(r / a)
+				dst.Pix[o+1] = uint8(g / a)
+				dst.Pix[o+2] = uint8(b / a)
+			}
+			dst.Pix[o+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
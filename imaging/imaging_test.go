@@ -0,0 +1,289 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// tiffEntry is one 12-byte IFD entry holding a SHORT value.
+type tiffEntry struct {
+	tag, value uint16
+}
+
+// buildTIFF returns a TIFF header in the given byte order followed by IFD0
+// with entries.
+func buildTIFF(order binary.ByteOrder, entries []tiffEntry) []byte {
+	b := make([]byte, 10, 10+12*len(entries))
+	if order == binary.LittleEndian {
+		copy(b, "II")
+	} else {
+		copy(b, "MM")
+	}
+	order.PutUint16(b[2:], 42)
+	order.PutUint32(b[4:], 8)
+	order.PutUint16(b[8:], uint16(len(entries)))
+	for _, e := range entries {
+		entry := make([]byte, 12)
+		order.PutUint16(entry[0:], e.tag)
+		order.PutUint16(entry[2:], 3) // SHORT
+		order.PutUint32(entry[4:], 1)
+		order.PutUint16(entry[8:], e.value)
+		b = append(b, entry...)
+	}
+	return b
+}
+
+// withAPP1 returns a JPEG start of image marker followed by an APP1 segment
+// holding payload, then rest.
+func withAPP1(payload, rest []byte) []byte {
+	b := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)+2))
+	b = append(b, payload...)
+	return append(b, rest...)
+}
+
+func exif(tiff []byte) []byte {
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+func TestExifOrientation(t *testing.T) {
+	orientation := func(v uint16) []tiffEntry { return []tiffEntry{{0x0112, v}} }
+
+	hugeOffset := buildTIFF(binary.BigEndian, orientation(6))
+	binary.BigEndian.PutUint32(hugeOffset[4:], 0xFFFFFFFF)
+	smallOffset := buildTIFF(binary.BigEndian, orientation(6))
+	binary.BigEndian.PutUint32(smallOffset[4:], 4)
+	manyEntries := buildTIFF(binary.LittleEndian, nil)
+	binary.LittleEndian.PutUint16(manyEntries[8:], 0xFFFF)
+	truncatedEntry := buildTIFF(binary.BigEndian, orientation(6))
+	truncatedEntry = truncatedEntry[:len(truncatedEntry)-4]
+	badOrder := buildTIFF(binary.BigEndian, orientation(6))
+	copy(badOrder, "XX")
+
+	sosFirst := []byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02}
+	sosFirst = append(sosFirst, withAPP1(exif(buildTIFF(binary.BigEndian, orientation(6))), nil)[2:]...)
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"empty", nil, 1},
+		{"not a JPEG", []byte("\x89PNG\r\n\x1a\n"), 1},
+		{"SOI only", []byte{0xFF, 0xD8}, 1},
+		{"no EXIF", withAPP1([]byte("JFIF\x00"), nil), 1},
+		{"big endian", withAPP1(exif(buildTIFF(binary.BigEndian, orientation(6))), nil), 6},
+		{"little endian", withAPP1(exif(buildTIFF(binary.LittleEndian, orientation(8))), nil), 8},
+		{"tag after other entries", withAPP1(exif(buildTIFF(binary.BigEndian, []tiffEntry{{0x010F, 1}, {0x0112, 3}})), nil), 3},
+		{"no orientation tag", withAPP1(exif(buildTIFF(binary.BigEndian, []tiffEntry{{0x010F, 6}})), nil), 1},
+		{"orientation 0", withAPP1(exif(buildTIFF(binary.BigEndian, orientation(0))), nil), 1},
+		{"orientation 9", withAPP1(exif(buildTIFF(binary.BigEndian, orientation(9))), nil), 1},
+		{"segment longer than file", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x10, 0x00, 'E', 'x'}, 1},
+		{"segment length below 2", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01, 0, 0}, 1},
+		{"garbage between segments", []byte{0xFF, 0xD8, 0x00, 0xE1, 0x00, 0x02}, 1},
+		{"truncated TIFF header", withAPP1(exif([]byte("MM\x00*")), nil), 1},
+		{"bad byte order", withAPP1(exif(badOrder), nil), 1},
+		{"IFD offset past the end", withAPP1(exif(hugeOffset), nil), 1},
+		{"IFD offset inside the header", withAPP1(exif(smallOffset), nil), 1},
+		{"entry count past the end", withAPP1(exif(manyEntries), nil), 1},
+		{"truncated entry", withAPP1(exif(truncatedEntry), nil), 1},
+		{"EXIF after start of scan", sosFirst, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exifOrientation(tt.data); got != tt.want {
+				t.Errorf("exifOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 2×3 image with one marked pixel in its top left corner
+	marker := color.NRGBA{R: 255, A: 255}
+	tests := []struct {
+		orientation int
+		w, h        int
+		x, y        int
+	}{
+		{1, 2, 3, 0, 0},
+		{2, 2, 3, 1, 0},
+		{3, 2, 3, 1, 2},
+		{4, 2, 3, 0, 2},
+		{5, 3, 2, 0, 0},
+		{6, 3, 2, 2, 0},
+		{7, 3, 2, 2, 1},
+		{8, 3, 2, 0, 1},
+	}
+	for _, tt := range tests {
+		src := image.NewNRGBA(image.Rect(0, 0, 2, 3))
+		src.SetNRGBA(0, 0, marker)
+		dst := orient(src, tt.orientation)
+		if dst.Rect.Dx() != tt.w || dst.Rect.Dy() != tt.h {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", tt.orientation, dst.Rect.Dx(), dst.Rect.Dy(), tt.w, tt.h)
+			continue
+		}
+		if got := dst.NRGBAAt(tt.x, tt.y); got != marker {
+			t.Errorf("orientation %d: pixel (%d, %d) = %v, want the marker", tt.orientation, tt.x, tt.y, got)
+		}
+	}
+}
+
+func encodeJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pngWithSize rewrites the dimensions in the IHDR chunk of a PNG, fixing up
+// its checksum, without adding any pixel data.
+func pngWithSize(t *testing.T, w, h uint32) []byte {
+	t.Helper()
+	data := encodePNG(t, image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+	// Signature (8), chunk length (4), "IHDR" (4), then width and height
+	binary.BigEndian.PutUint32(data[16:], w)
+	binary.BigEndian.PutUint32(data[20:], h)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestProcessRejects(t *testing.T) {
+	truncated := encodeJPEG(t, 64, 64)
+	truncated = truncated[:len(truncated)/2]
+
+	tests := []struct {
+		name   string
+		data   []byte
+		limits Limits
+		want   error
+	}{
+		{"text", []byte("just some text, not an image"), DefaultLimits, ErrUnsupportedFormat},
+		{"GIF", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"), DefaultLimits, ErrUnsupportedFormat},
+		{"JPEG signature only", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00}, DefaultLimits, ErrCorrupt},
+		{"truncated JPEG", truncated, DefaultLimits, ErrCorrupt},
+		{"too many bytes", encodeJPEG(t, 8, 8), Limits{MaxBytes: 100}, ErrFileTooLarge},
+		{"too wide", pngWithSize(t, 100000, 1), DefaultLimits, ErrDimensions},
+		{"too tall", pngWithSize(t, 1, 100000), DefaultLimits, ErrDimensions},
+		{"too many pixels", pngWithSize(t, 9000, 9000), DefaultLimits, ErrDimensions},
+		{"overflowing dimensions", pngWithSize(t, 0x7FFFFFFF, 0x7FFFFFFF), DefaultLimits, ErrCorrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Process(tt.data, tt.limits); !errors.Is(err, tt.want) {
+				t.Errorf("Process() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessDetectsFormatFromContent(t *testing.T) {
+	// Process never sees the file name, so a PNG uploaded as photo.jpg is
+	// read as the PNG it is
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	result, err := Process(encodePNG(t, img), DefaultLimits)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	// Opaque images are stored as JPEG whatever they came in as
+	if result.ContentType != "image/jpeg" || result.Ext != ".jpg" {
+		t.Errorf("opaque PNG stored as %s %s, want image/jpeg .jpg", result.ContentType, result.Ext)
+	}
+
+	img.SetNRGBA(0, 0, color.NRGBA{})
+	result, err = Process(encodePNG(t, img), DefaultLimits)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result.ContentType != "image/png" || result.Ext != ".png" {
+		t.Errorf("transparent PNG stored as %s %s, want image/png .png", result.ContentType, result.Ext)
+	}
+}
+
+func TestProcessStripsEXIFAndAppliesOrientation(t *testing.T) {
+	plain := encodeJPEG(t, 40, 20)
+	payload := exif(buildTIFF(binary.BigEndian, []tiffEntry{{0x0112, 6}}))
+	tagged := withAPP1(payload, plain[2:])
+
+	result, err := Process(tagged, DefaultLimits)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result.Width != 20 || result.Height != 40 {
+		t.Errorf("size %dx%d, want the rotated 20x40", result.Width, result.Height)
+	}
+	for _, v := range result.Variants {
+		if bytes.Contains(v.Data, []byte("Exif\x00\x00")) {
+			t.Errorf("variant %s still has EXIF data", v.Name)
+		}
+	}
+}
+
+func TestProcessSizes(t *testing.T) {
+	tests := []struct {
+		name   string
+		width  int
+		want   []string
+		widths []int
+	}{
+		{"small image is not scaled up", 100, []string{"full"}, []int{100}},
+		{"medium image", 600, []string{"thumb", "full"}, []int{320, 600}},
+		{"large image", 2100, []string{"thumb", "medium", "full"}, []int{320, 1024, 2048}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Process(encodeJPEG(t, tt.width, 10), DefaultLimits)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if len(result.Variants) != len(tt.want) {
+				t.Fatalf("got %d variants, want %v", len(result.Variants), tt.want)
+			}
+			for i, v := range result.Variants {
+				if v.Name != tt.want[i] || v.Width != tt.widths[i] {
+					t.Errorf("variant %d = %s %dpx, want %s %dpx", i, v.Name, v.Width, tt.want[i], tt.widths[i])
+				}
+				if v.Height < 1 {
+					t.Errorf("variant %s has height %d", v.Name, v.Height)
+				}
+			}
+		})
+	}
+}
+
+func TestResizeWeightsByAlpha(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	// Fully transparent black must not darken the red
+	src.SetNRGBA(1, 0, color.NRGBA{})
+
+	got := resize(src, 1, 1).NRGBAAt(0, 0)
+	if want := (color.NRGBA{R: 255, A: 127}); got != want {
+		t.Errorf("resize() = %v, want %v", got, want)
+	}
+}
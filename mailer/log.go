@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// Log notes messages in the server log instead of delivering them. Only the
+// recipient and subject are logged: bodies carry password reset and
+// verification links, which must not end up in logs. Use File to read
+// messages in full during development.
+type Log struct{}
+
+// NewLog returns a Log mailer.
+func NewLog() *Log {
+	return &Log{}
+}
+
+func (Log) Send(from string, msg Message) error {
+	// Rendered only to reject what the other transports would
+	if _, err := render(from, msg); err != nil {
+		return err
+	}
+	log.Printf("mailer: not delivering message to %s: %q", msg.To, msg.Subject)
+	return nil
+}
+
+// File appends messages to a file instead of delivering them, each preceded
+// by an mbox style "From " line, for development and tests that read the
+// mail back.
+type File struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFile returns a File mailer appending to path, creating it if needed.
+func NewFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open MAIL_FILE: %w", err)
+	}
+	f.Close()
+	return &File{path: path}, nil
+}
+
+func (m *File) Send(from string, msg Message) error {
+	data, err := render(from, msg)
+	if err != nil {
+		return err
+	}
+	sender, err := address(from)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open MAIL_FILE: %w", err)
+	}
+	if _, err := fmt.Fprintf(f, "From %s\r\n%s\r\n", sender, data); err != nil {
+		f.Close()
+		return fmt.Errorf("write MAIL_FILE: %w", err)
+	}
+	return f.Close()
+}
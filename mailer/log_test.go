@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogLeavesOutBody(t *testing.T) {
+	var out bytes.Buffer
+	saved := log.Writer()
+	log.SetOutput(&out)
+	defer log.SetOutput(saved)
+
+	err := NewLog().Send("no-reply@example.com", Message{
+		To:      "user@example.org",
+		Subject: "Reset your password",
+		Body:    "https://example.com/reset?token=secret-token",
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := out.String(); strings.Contains(got, "secret-token") {
+		t.Errorf("log contains the body: %s", got)
+	} else if !strings.Contains(got, "user@example.org") || !strings.Contains(got, "Reset your password") {
+		t.Errorf("log lacks the recipient or subject: %s", got)
+	}
+
+	if err := NewLog().Send("no-reply@example.com", Message{To: "user@example.org", Subject: "a\nb"}); err == nil {
+		t.Error("Send with a multi-line subject succeeded, want an error")
+	}
+}
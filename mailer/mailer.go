@@ -0,0 +1,131 @@
+// Package mailer sends the site's email through a pluggable transport: an
+// SMTP server, or a log or file for development, where messages are written
+// out instead of delivered.
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+
+	"real/config"
+)
+
+var ErrInvalidAddress = errors.New("invalid email address")
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	// Send delivers msg from the given sender address.
+	Send(from string, msg Message) error
+}
+
+// Default is the transport Send uses, set up by Init.
+var Default Mailer
+
+// from is the sender of all mail, MAIL_FROM.
+var from string
+
+// Init sets up Default from the MAIL_* and SMTP_* settings.
+func Init() error {
+	m, err := FromConfig()
+	if err != nil {
+		return err
+	}
+	Default = m
+	from = config.String("MAIL_FROM", "Forum <no-reply@localhost>")
+	if _, err := mail.ParseAddress(from); err != nil {
+		return fmt.Errorf("invalid MAIL_FROM %q: %v", from, err)
+	}
+	return nil
+}
+
+// FromConfig builds the transport selected by MAIL_TRANSPORT ("log", "file"
+// or "smtp").
+func FromConfig() (Mailer, error) {
+	switch transport := config.String("MAIL_TRANSPORT", "log"); transport {
+	case "log":
+		return NewLog(), nil
+	case "file":
+		return NewFile(config.String("MAIL_FILE", "mail.log"))
+	case "smtp":
+		return NewSMTP(SMTPConfig{
+			Host:     config.String("SMTP_HOST", "localhost"),
+			Port:     config.Int("SMTP_PORT", 25),
+			Username: config.String("SMTP_USERNAME", ""),
+			Password: config.String("SMTP_PASSWORD", ""),
+		})
+	default:
+		return nil, fmt.Errorf("unknown MAIL_TRANSPORT %q", transport)
+	}
+}
+
+// Send delivers msg with Default from MAIL_FROM.
+func Send(msg Message) error {
+	return Default.Send(from, msg)
+}
+
+// render formats msg as an RFC 5322 message from the given sender. Header
+// values are checked and encoded so that user input cannot add headers.
+func render(from string, msg Message) ([]byte, error) {
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAddress, msg.To)
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAddress, from)
+	}
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, errors.New("subject must be a single line")
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := sender.Address[strings.LastIndex(sender.Address, "@")+1:]
+
+	var b bytes.Buffer
+	headers := [][2]string{
+		{"From", sender.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", "<" + hex.EncodeToString(id) + "@" + domain + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "8bit"},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", h[0], h[1])
+	}
+	b.WriteString("\r\n")
+	// Mail wants CRLF line endings
+	for _, line := range strings.Split(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n") {
+		b.WriteString(line + "\r\n")
+	}
+	return b.Bytes(), nil
+}
+
+// address is the bare address of an RFC 5322 address such as
+// "Name <user@example.com>".
+func address(addr string) (string, error) {
+	a, err := mail.ParseAddress(addr)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", ErrInvalidAddress, addr)
+	}
+	return a.Address, nil
+}
@@ -0,0 +1,97 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// smtpTimeout bounds how long delivering one message may take.
+const smtpTimeout = 30 * time.Second
+
+// SMTPConfig says how to reach an SMTP server. Username and Password are
+// optional; when set, PLAIN authentication is used, which net/smtp only
+// allows over TLS or to localhost.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// SMTP delivers mail through an SMTP server, upgrading the connection with
+// STARTTLS whenever the server offers it.
+type SMTP struct {
+	cfg SMTPConfig
+}
+
+// NewSMTP returns an SMTP mailer for cfg.
+func NewSMTP(cfg SMTPConfig) (*SMTP, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("SMTP_HOST is required for the smtp mail transport")
+	}
+	if cfg.Port <= 0 {
+		return nil, fmt.Errorf("invalid SMTP_PORT %d", cfg.Port)
+	}
+	return &SMTP{cfg: cfg}, nil
+}
+
+func (s *SMTP) Send(from string, msg Message) error {
+	data, err := render(from, msg)
+	if err != nil {
+		return err
+	}
+	sender, err := address(from)
+	if err != nil {
+		return err
+	}
+	recipient, err := address(msg.To)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port)), smtpTimeout)
+	if err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.cfg.Host}); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+	if s.cfg.Username != "" {
+		auth := smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+		if err := c.Auth(auth); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := c.Mail(sender); err != nil {
+		return fmt.Errorf("smtp MAIL FROM: %w", err)
+	}
+	if err := c.Rcpt(recipient); err != nil {
+		return fmt.Errorf("smtp RCPT TO: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	return c.Quit()
+}
@@ -0,0 +1,172 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSession is what a fakeSMTP server was told by one client.
+type smtpSession struct {
+	mailFrom string
+	rcptTo   []string
+	data     string
+}
+
+// fakeSMTP accepts connections on a local port and records each session on
+// sessions. It offers no extensions, so clients send plain commands.
+func fakeSMTP(t *testing.T) (port int, sessions <-chan smtpSession) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ch := make(chan smtpSession, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, ch)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, ch
+}
+
+func serveSMTP(conn net.Conn, sessions chan<- smtpSession) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	var s smtpSession
+	reply("220 localhost fake SMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(cmd, " ", 2)[0])
+		switch {
+		case verb == "EHLO" || verb == "HELO":
+			reply("250 localhost")
+		case strings.HasPrefix(strings.ToUpper(cmd), "MAIL FROM:"):
+			s.mailFrom = cmd[len("MAIL FROM:"):]
+			reply("250 OK")
+		case strings.HasPrefix(strings.ToUpper(cmd), "RCPT TO:"):
+			s.rcptTo = append(s.rcptTo, cmd[len("RCPT TO:"):])
+			reply("250 OK")
+		case verb == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				// Undo dot-stuffing
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.data = data.String()
+			reply("250 OK queued")
+		case verb == "QUIT":
+			reply("221 Bye")
+			sessions <- s
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestSMTPSend(t *testing.T) {
+	port, sessions := fakeSMTP(t)
+	m, err := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send("Forum <no-reply@example.com>", Message{
+		To:      "Zoë <zoe@example.org>",
+		Subject: "Réinitialiser votre mot de passe",
+		Body:    "Hello,\nfollow this link:\r\nhttps://example.com/reset\n.\nBye",
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	var s smtpSession
+	select {
+	case s = <-sessions:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server got no complete session")
+	}
+	if s.mailFrom != "<no-reply@example.com>" {
+		t.Errorf("MAIL FROM:%s, want <no-reply@example.com>", s.mailFrom)
+	}
+	if len(s.rcptTo) != 1 || s.rcptTo[0] != "<zoe@example.org>" {
+		t.Errorf("RCPT TO:%v, want <zoe@example.org>", s.rcptTo)
+	}
+
+	if strings.Contains(strings.ReplaceAll(s.data, "\r\n", ""), "\n") {
+		t.Errorf("message has bare LF line endings:\n%q", s.data)
+	}
+	headers, body, ok := strings.Cut(s.data, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("message has no header/body separator:\n%q", s.data)
+	}
+	if want := "Hello,\r\nfollow this link:\r\nhttps://example.com/reset\r\n.\r\nBye\r\n"; body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+	if want := "Subject: =?utf-8?q?R=C3=A9initialiser_votre_mot_de_passe?=\r\n"; !strings.Contains(headers+"\r\n", want) {
+		t.Errorf("headers lack %q:\n%s", want, headers)
+	}
+	for _, want := range []string{"From: \"Forum\" <no-reply@example.com>", "To: =?utf-8?q?Zo=C3=AB?= <zoe@example.org>"} {
+		if !strings.Contains(headers, want+"\r\n") {
+			t.Errorf("headers lack %q:\n%s", want, headers)
+		}
+	}
+}
+
+func TestSMTPSendRejectsHeaderInjection(t *testing.T) {
+	port, sessions := fakeSMTP(t)
+	m, err := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, subject := range []string{"Hi\r\nBcc: victim@example.org", "Hi\nBcc: victim@example.org", "Hi\rthere"} {
+		err := m.Send("no-reply@example.com", Message{To: "user@example.org", Subject: subject, Body: "body"})
+		if err == nil {
+			t.Errorf("Send with subject %q succeeded, want an error", subject)
+		}
+	}
+	if err := m.Send("no-reply@example.com", Message{To: "a@example.org\r\nRCPT TO:<b@example.org>", Subject: "Hi"}); err == nil {
+		t.Error("Send to an address with CRLF succeeded, want an error")
+	}
+	select {
+	case s := <-sessions:
+		t.Errorf("rejected messages reached the server: %+v", s)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNewSMTPValidatesConfig(t *testing.T) {
+	for _, cfg := range []SMTPConfig{{Port: 25}, {Host: "localhost"}, {Host: "localhost", Port: -1}} {
+		if _, err := NewSMTP(cfg); err == nil {
+			t.Errorf("NewSMTP(%+v) succeeded, want an error", cfg)
+		}
+	}
+	if _, err := NewSMTP(SMTPConfig{Host: "localhost", Port: 587}); err != nil {
+		t.Errorf("NewSMTP: %v", err)
+	}
+}
@@ -7,9 +7,15 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
+	"real/auth"
+	"real/config"
 	"real/db"
 	"real/handlers"
+	"real/mailer"
+	"real/oauth"
+	"real/storage"
 
 	rt_hub "real/websocket"
 
@@ -60,13 +66,35 @@ func main() {
 	}
 	defer db.DB.Close()
 
+	if err := storage.Init(); err != nil {
+		log.Fatalf("Media storage initialization failed: %v", err)
+	}
+
+	if err := mailer.Init(); err != nil {
+		log.Fatalf("Mailer initialization failed: %v", err)
+	}
+
+	if err := oauth.Init(); err != nil {
+		log.Fatalf("OAuth initialization failed: %v", err)
+	}
+
 	// Initialize the WebSocket Hub
 	hub := rt_hub.NewHub()
 	go hub.Run()
 
+	// Publish scheduled posts once they are due
+	go handlers.ScheduleDraftPublisher(hub, config.Duration("DRAFT_PUBLISH_INTERVAL", 30*time.Second))
+
+	// Remove uploads no post, draft or message uses any more
+	go handlers.ScheduleMediaCleanup(
+		config.Duration("MEDIA_CLEANUP_INTERVAL", time.Hour),
+		config.Duration("MEDIA_CLEANUP_GRACE", 24*time.Hour),
+	)
+
 	// Serve static files with proper MIME types
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
 	http.Handle("/images/", http.StripPrefix("/images/", http.FileServer(http.Dir("static/images"))))
+	http.HandleFunc(storage.SignedPrefix, handlers.MediaHandler)
 
 	// Explicit handler for JavaScript files
 	http.HandleFunc("/js/", func(w http.ResponseWriter, r *http.Request) {
@@ -75,22 +103,96 @@ func main() {
 	})
 
 	// Existing API handlers
-	http.HandleFunc("/api/categories", handlers.GetCategoriesHandler)
+	http.HandleFunc("/api/categories", handlers.CategoriesHandler)
+	http.HandleFunc("/api/categories/delete", handlers.DeleteCategoryHandler)
+	http.HandleFunc("/api/categories/reorder", handlers.ReorderCategoriesHandler)
+	http.HandleFunc("/api/categories/reaction-types", handlers.CategoryReactionTypesHandler)
+	http.HandleFunc("/api/reaction-types", handlers.ReactionTypesHandler)
+	http.HandleFunc("/api/tags", handlers.TagsHandler)
+	http.HandleFunc("/api/tags/page", handlers.TagPageHandler)
+	http.HandleFunc("/api/tags/trending", handlers.TrendingTagsHandler)
 	http.HandleFunc("/api/posts", handlers.GetPostsHandler)
-	http.HandleFunc("/post/create", handlers.CreatePostHandler)
+	http.HandleFunc("/post/create", auth.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handlers.CreatePostHandler(hub, w, r)
+	}))
+	http.HandleFunc("/post/pin", auth.AuthMiddleware(handlers.PinPostHandler))
+	http.HandleFunc("/post/lock", auth.AuthMiddleware(handlers.LockPostHandler))
+	http.HandleFunc("/post/announce", auth.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handlers.AnnouncePostHandler(hub, w, r)
+	}))
+	http.HandleFunc("/api/announcements", auth.AuthMiddleware(handlers.AnnouncementsHandler))
+	http.HandleFunc("/post/images/add", auth.AuthMiddleware(handlers.AddPostImagesHandler))
+	http.HandleFunc("/post/images/update", auth.AuthMiddleware(handlers.UpdatePostImageHandler))
+	http.HandleFunc("/post/images/remove", auth.AuthMiddleware(handlers.RemovePostImageHandler))
+	http.HandleFunc("/post/images/reorder", auth.AuthMiddleware(handlers.ReorderPostImagesHandler))
+	http.HandleFunc("/post/draft", auth.AuthMiddleware(handlers.SaveDraftHandler))
+	http.HandleFunc("/post/draft/delete", auth.AuthMiddleware(handlers.DeleteDraftHandler))
+	http.HandleFunc("/post/draft/schedule", auth.AuthMiddleware(handlers.ScheduleDraftHandler))
+	http.HandleFunc("/post/draft/publish", auth.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handlers.PublishDraftHandler(hub, w, r)
+	}))
+	http.HandleFunc("/api/drafts", auth.AuthMiddleware(handlers.GetDraftsHandler))
+	http.HandleFunc("/api/feed", auth.AuthMiddleware(handlers.FeedHandler))
+	http.HandleFunc("/api/profile", handlers.ProfileHandler)
+	http.HandleFunc("/api/followers", handlers.GetFollowersHandler)
+	http.HandleFunc("/api/following", handlers.GetFollowingHandler)
+	http.HandleFunc("/follow", auth.AuthMiddleware(handlers.FollowHandler))
+	http.HandleFunc("/unfollow", auth.AuthMiddleware(handlers.UnfollowHandler))
+	http.HandleFunc("/api/bookmarks", auth.AuthMiddleware(handlers.GetBookmarksHandler))
+	http.HandleFunc("/api/bookmarks/collections", auth.AuthMiddleware(handlers.BookmarkCollectionsHandler))
+	http.HandleFunc("/bookmark/add", auth.AuthMiddleware(handlers.AddBookmarkHandler))
+	http.HandleFunc("/bookmark/remove", auth.AuthMiddleware(handlers.RemoveBookmarkHandler))
+	http.HandleFunc("/bookmark/reorder", auth.AuthMiddleware(handlers.ReorderBookmarksHandler))
+	http.HandleFunc("/bookmark/collection/delete", auth.AuthMiddleware(handlers.DeleteBookmarkCollectionHandler))
 	http.HandleFunc("/login", handlers.LoginHandler)
+	http.HandleFunc("/login/2fa", handlers.LoginTwoFactorHandler)
 	http.HandleFunc("/register", handlers.RegisterHandler)
-	http.HandleFunc("/like", handlers.LikeHandler)
-	http.HandleFunc("/comment/create", handlers.CreateCommentHandler)
+	http.HandleFunc("/password/forgot", handlers.ForgotPasswordHandler)
+	http.HandleFunc("/password/reset", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ResetPasswordHandler(hub, w, r)
+	})
+	http.HandleFunc("/oauth/login", handlers.OAuthLoginHandler)
+	http.HandleFunc("/oauth/callback", handlers.OAuthCallbackHandler)
+	http.HandleFunc("/api/oauth/providers", handlers.OAuthProvidersHandler)
+	http.HandleFunc("/api/oauth", auth.AuthMiddleware(handlers.OAuthAccountHandler))
+	http.HandleFunc("/api/oauth/link", auth.AuthMiddleware(handlers.LinkOAuthHandler))
+	http.HandleFunc("/api/oauth/unlink", auth.AuthMiddleware(handlers.UnlinkOAuthHandler))
+	http.HandleFunc("/email/verify", handlers.VerifyEmailHandler)
+	http.HandleFunc("/api/email/verify/resend", auth.AuthMiddleware(handlers.ResendVerificationHandler))
+	http.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		handlers.LogoutHandler(hub, w, r)
+	})
+	http.HandleFunc("/like", auth.AuthMiddleware(handlers.LikeHandler))
+	http.HandleFunc("/comment/create", auth.AuthMiddleware(handlers.CreateCommentHandler))
+	http.HandleFunc("/comment/reply", auth.AuthMiddleware(handlers.ReplyCommentHandler))
+	http.HandleFunc("/comment/edit", auth.AuthMiddleware(handlers.EditCommentHandler))
+	http.HandleFunc("/comment/delete", auth.AuthMiddleware(handlers.DeleteCommentHandler))
 	http.HandleFunc("/comments", handlers.GetCommentsHandler)
-	http.HandleFunc("/comment/like", handlers.CommentReactionHandler)
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/comment/like", auth.AuthMiddleware(handlers.CommentReactionHandler))
+	http.HandleFunc("/ws", auth.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		handlers.ServeWs(hub, w, r)
-	})
-	http.HandleFunc("/api/users", handlers.HandleGetUsers)
-	http.HandleFunc("/api/messages", handlers.HandleGetMessages)
-	http.HandleFunc("/api/online-users", handlers.HandleGetOnlineUsers)
+	}))
+	http.HandleFunc("/api/polls", handlers.GetPollHandler)
+	http.HandleFunc("/poll/vote", auth.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handlers.VotePollHandler(hub, w, r)
+	}))
+	http.HandleFunc("/api/users", auth.AuthMiddleware(handlers.HandleGetUsers))
+	http.HandleFunc("/api/messages", auth.AuthMiddleware(handlers.HandleGetMessages))
+	http.HandleFunc("/api/online-users", auth.AuthMiddleware(handlers.HandleGetOnlineUsers))
 	http.HandleFunc("/api/validate-session", handlers.ValidateSessionHandler)
+	http.HandleFunc("/api/sessions", auth.AuthMiddleware(handlers.SessionsHandler))
+	http.HandleFunc("/api/2fa", auth.AuthMiddleware(handlers.TwoFactorHandler))
+	http.HandleFunc("/api/2fa/enroll", auth.AuthMiddleware(handlers.EnrollTwoFactorHandler))
+	http.HandleFunc("/api/2fa/confirm", auth.AuthMiddleware(handlers.ConfirmTwoFactorHandler))
+	http.HandleFunc("/api/2fa/recovery-codes", auth.AuthMiddleware(handlers.RecoveryCodesHandler))
+	http.HandleFunc("/api/2fa/disable", auth.AuthMiddleware(handlers.DisableTwoFactorHandler))
+	http.HandleFunc("/api/admin/login-attempts", auth.AuthMiddleware(handlers.LoginAttemptsHandler))
+	http.HandleFunc("/api/sessions/revoke", auth.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handlers.RevokeSessionHandler(hub, w, r)
+	}))
+	http.HandleFunc("/api/sessions/revoke-others", auth.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handlers.RevokeOtherSessionsHandler(hub, w, r)
+	}))
 
 	// Serve index.html for all other routes
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -102,5 +204,10 @@ func main() {
 	})
 
 	log.Println("Server started at http://localhost:9002")
-	log.Fatal(http.ListenAndServe(":9002", nil))
+	// Every request has its session resolved once, for AuthMiddleware and the
+	// handlers to share. Realtime connections follow their session when its
+	// ID is rotated. State-changing requests are checked for CSRF before
+	// reaching any handler
+	handler := auth.SessionMiddleware(auth.CSRFMiddleware(http.DefaultServeMux), hub.RenameSession)
+	log.Fatal(http.ListenAndServe(":9002", auth.CORSMiddleware(handler)))
 }
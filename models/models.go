@@ -2,13 +2,33 @@ package models
 
 import (
 	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// User roles stored in users.role
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Category groups posts. ParentID is nil for top-level categories. The post
+// counts are only filled in when categories are listed; TotalPostCount
+// includes the posts of subcategories.
 type Category struct {
-	CategoryID  int    `json:"category_id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	CategoryID     int    `json:"category_id"`
+	Name           string `json:"name"`
+	Description    string `json:"description"`
+	ParentID       *int   `json:"parent_id"`
+	Color          string `json:"color"`
+	Icon           string `json:"icon"`
+	Position       int    `json:"position"`
+	Archived       bool   `json:"archived"`
+	PostCount      int    `json:"post_count"`
+	TotalPostCount int    `json:"total_post_count"`
 }
 
 type Post struct {
@@ -21,11 +41,91 @@ type Post struct {
 	UpdatedAt time.Time      `json:"updated_at"`
 	ImgURL    sql.NullString `json:"imgurl,omitempty"`
 }
-type LikeRequest struct {
-	UserID    int    `json:"user_id"`
-	PostID    *int   `json:"post_id,omitempty"`
-	CommentID *int   `json:"comment_id,omitempty"`
-	LikeType  string `json:"like_type"`
+
+// Reaction targets and the reaction types seeded as the default set
+const (
+	ReactionTargetPost    = "post"
+	ReactionTargetComment = "comment"
+
+	ReactionLike    = "like"
+	ReactionDislike = "dislike"
+)
+
+// UserSession is one of a user's logged-in devices, as listed to that user.
+// ID is derived from the session ID, which is never shown.
+type UserSession struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Remember   bool      `json:"remember"`
+	Current    bool      `json:"current"`
+}
+
+// LoginAttempt is a failed login from the audit log. UserID is nil when the
+// identifier matched no account.
+type LoginAttempt struct {
+	ID         int       `json:"id"`
+	Identifier string    `json:"identifier"`
+	UserID     *int      `json:"user_id"`
+	Username   string    `json:"username,omitempty"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ReactionRequest is the body of the reaction endpoints. The reacting user is
+// always taken from the session, never from the request. Older clients still
+// send user_id; it is only read to turn away requests claiming someone else.
+type ReactionRequest struct {
+	PostID       int             `json:"post_id,omitempty"`
+	CommentID    int             `json:"comment_id,omitempty"`
+	ReactionType string          `json:"reaction_type,omitempty"`
+	LikeType     string          `json:"like_type,omitempty"` // older clients
+	UserID       json.RawMessage `json:"user_id,omitempty"`   // older clients
+}
+
+// ClaimsOtherUser reports whether the request names a user other than
+// sessionUserID, as a number or a numeric string. A user_id that is absent,
+// null or empty claims nobody.
+func (r ReactionRequest) ClaimsOtherUser(sessionUserID int) bool {
+	raw := strings.Trim(strings.TrimSpace(string(r.UserID)), `"`)
+	if raw == "" || raw == "null" {
+		return false
+	}
+	return raw != strconv.Itoa(sessionUserID)
+}
+
+// Type returns the requested reaction, accepting the older like_type field.
+func (r ReactionRequest) Type() string {
+	if r.ReactionType != "" {
+		return r.ReactionType
+	}
+	return r.LikeType
+}
+
+// ReactionSummary is the state of a post or comment after a reaction.
+type ReactionSummary struct {
+	TargetType   string         `json:"target_type"`
+	TargetID     int            `json:"target_id"`
+	Counts       map[string]int `json:"counts"`
+	Likes        int            `json:"likes"`
+	Dislikes     int            `json:"dislikes"`
+	UserReaction string         `json:"userReaction"`
+}
+
+// ReactionType is an admin-configurable reaction such as 👍 or 🎉. Default
+// types are enabled for every category that has no set of its own.
+type ReactionType struct {
+	Code      string `json:"code"`
+	Emoji     string `json:"emoji"`
+	Label     string `json:"label"`
+	SortOrder int    `json:"sort_order"`
+	IsDefault bool   `json:"is_default"`
+	Active    bool   `json:"active"`
 }
 type Response struct {
 	Status  string      `json:"status"`
@@ -57,3 +157,215 @@ type OnlineUser struct {
 	Username string    `json:"username"`
 	LastSeen time.Time `json:"lastSeen"`
 }
+
+// PollInput is the optional poll sent with a new post.
+type PollInput struct {
+	Question       string     `json:"question"`
+	Options        []string   `json:"options"`
+	MultipleChoice bool       `json:"multiple_choice"`
+	Anonymous      bool       `json:"anonymous"`
+	ClosesAt       *time.Time `json:"closes_at,omitempty"`
+}
+
+type PollOption struct {
+	OptionID int      `json:"option_id"`
+	Text     string   `json:"text"`
+	Votes    int      `json:"votes"`
+	Voters   []string `json:"voters,omitempty"` // usernames, never set for anonymous polls
+}
+
+// Poll holds a poll and its current results. MyVotes is only filled in for
+// the requesting user and is left out of broadcast updates.
+type Poll struct {
+	PollID         int          `json:"poll_id"`
+	PostID         int          `json:"post_id"`
+	Question       string       `json:"question"`
+	MultipleChoice bool         `json:"multiple_choice"`
+	Anonymous      bool         `json:"anonymous"`
+	ClosesAt       *time.Time   `json:"closes_at,omitempty"`
+	Closed         bool         `json:"closed"`
+	TotalVoters    int          `json:"total_voters"`
+	Options        []PollOption `json:"options"`
+	MyVotes        []int        `json:"my_votes,omitempty"`
+}
+
+// NewPost is a validated post ready to be stored, coming either from
+// CreatePostHandler or from a draft being published.
+type NewPost struct {
+	UserID      int
+	Title       string
+	Content     string
+	Images      []PostImage
+	CategoryIDs []int
+	Tags        []string
+	Poll        *PollInput
+}
+
+// Tag is a free-form label on posts. Names are normalised before they are
+// stored, so they can be compared and linked to directly.
+type Tag struct {
+	Name      string `json:"name"`
+	PostCount int    `json:"post_count"`
+}
+
+// TrendingTag counts the posts tagged with a tag in a recent period and in
+// the period of the same length before it.
+type TrendingTag struct {
+	Name          string `json:"name"`
+	RecentCount   int    `json:"recent_count"`
+	PreviousCount int    `json:"previous_count"`
+	PostCount     int    `json:"post_count"`
+}
+
+// GlobalPin is the category_id of pins on the unfiltered post list.
+const GlobalPin = 0
+
+// Announcement is a post moderators pushed to every user. Read is whether the
+// viewing user has dismissed it.
+type Announcement struct {
+	PostID      int       `json:"post_id"`
+	UserID      int       `json:"user_id"`
+	Username    string    `json:"username"`
+	Title       string    `json:"title"`
+	AnnouncedAt time.Time `json:"announced_at"`
+	Read        bool      `json:"read"`
+}
+
+// PostSummary is pushed to websocket clients when a post is published.
+type PostSummary struct {
+	PostID     int       `json:"post_id"`
+	UserID     int       `json:"user_id"`
+	Username   string    `json:"username"`
+	Title      string    `json:"title"`
+	Categories string    `json:"categories"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Draft statuses
+const (
+	DraftStatusDraft     = "draft"
+	DraftStatusScheduled = "scheduled"
+)
+
+// PostDraft is an unpublished post. Fields may be incomplete until it is
+// published; scheduled drafts are published by the background publisher once
+// PublishAt has passed.
+type PostDraft struct {
+	DraftID      int             `json:"draft_id"`
+	UserID       int             `json:"user_id"`
+	Title        string          `json:"title"`
+	Content      string          `json:"content"`
+	CategoryIDs  []int           `json:"category_ids"`
+	Tags         []string        `json:"tags"`
+	Images       []PostImage     `json:"images"`
+	Poll         json.RawMessage `json:"poll,omitempty"`
+	Status       string          `json:"status"`
+	PublishAt    *time.Time      `json:"publish_at,omitempty"`
+	PublishError string          `json:"publish_error,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+// DefaultBookmarkCollection is created for a user the first time they save
+// something without choosing a collection.
+const DefaultBookmarkCollection = "Saved"
+
+// BookmarkCollection is a named, ordered list of a user's saved posts and
+// comments.
+type BookmarkCollection struct {
+	CollectionID int       `json:"collection_id"`
+	Name         string    `json:"name"`
+	Position     int       `json:"position"`
+	ItemCount    int       `json:"item_count"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Bookmark is a saved post or comment. PostID is the post itself, or the post
+// a saved comment belongs to.
+type Bookmark struct {
+	BookmarkID   int       `json:"bookmark_id"`
+	CollectionID int       `json:"collection_id"`
+	TargetType   string    `json:"target_type"`
+	TargetID     int       `json:"target_id"`
+	PostID       int       `json:"post_id"`
+	Title        string    `json:"title"`
+	Excerpt      string    `json:"excerpt"`
+	Author       string    `json:"author"`
+	Deleted      bool      `json:"deleted"`
+	Position     int       `json:"position"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// BookmarkRequest is the body of the bookmark endpoints. Exactly one of
+// PostID and CommentID is set; a zero CollectionID means the default
+// collection.
+type BookmarkRequest struct {
+	PostID       int `json:"post_id,omitempty"`
+	CommentID    int `json:"comment_id,omitempty"`
+	CollectionID int `json:"collection_id,omitempty"`
+}
+
+// UserProfile is the public view of a user. IsFollowing tells whether the
+// viewer follows them.
+type UserProfile struct {
+	UserID         int       `json:"user_id"`
+	Username       string    `json:"username"`
+	Bio            string    `json:"bio"`
+	ProfilePicture string    `json:"profile_picture"`
+	CreatedAt      time.Time `json:"created_at"`
+	PostCount      int       `json:"post_count"`
+	FollowerCount  int       `json:"follower_count"`
+	FollowingCount int       `json:"following_count"`
+	IsFollowing    bool      `json:"is_following"`
+}
+
+// FollowedUser is an entry in a follower or following list.
+type FollowedUser struct {
+	UserID     int       `json:"user_id"`
+	Username   string    `json:"username"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// FollowRequest is the body of the follow endpoints. Exactly one of UserID
+// and CategoryID is set.
+type FollowRequest struct {
+	UserID     int `json:"user_id,omitempty"`
+	CategoryID int `json:"category_id,omitempty"`
+}
+
+// Image is a processed upload. URL is the media storage key of its "full"
+// variant, as are the URLs of its variants.
+type Image struct {
+	ImageID     int            `json:"image_id"`
+	UserID      int            `json:"user_id"`
+	URL         string         `json:"url"`
+	ContentHash string         `json:"content_hash,omitempty"`
+	ContentType string         `json:"content_type"`
+	Width       int            `json:"width"`
+	Height      int            `json:"height"`
+	SizeBytes   int64          `json:"size_bytes"`
+	Variants    []ImageVariant `json:"variants"`
+}
+
+// ImageVariant is one stored size of an image, e.g. "thumb" or "medium".
+type ImageVariant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// PostImage is one image of a post's gallery. URL is a media storage key
+// until the image is prepared for a response, which also fills in the srcset
+// fields for processed uploads.
+type PostImage struct {
+	PostImageID int    `json:"post_image_id"`
+	URL         string `json:"url"`
+	Position    int    `json:"position"`
+	AltText     string `json:"alt_text"`
+	Caption     string `json:"caption"`
+	Srcset      string `json:"srcset,omitempty"`
+	ThumbURL    string `json:"thumb_url,omitempty"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+}
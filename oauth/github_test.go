@@ -0,0 +1,145 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// toServer sends requests for any host to a test server, with the original
+// host kept in the path, e.g. /api.github.com/user.
+type toServer struct {
+	server *httptest.Server
+}
+
+func (t toServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, err := url.Parse(t.server.URL)
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.URL.Path = "/" + req.URL.Host + req.URL.Path
+	req.URL.Scheme, req.URL.Host, req.Host = target.Scheme, target.Host, ""
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// fakeGitHub stands in for github.com and its API, logging in as user with
+// emails. The token endpoint answers errors with a 200, as GitHub does.
+func fakeGitHub(t *testing.T, user map[string]interface{}, emails []githubEmail) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/github.com/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("code") != "good-code" || r.PostForm.Get("code_verifier") != "the-verifier" ||
+			r.PostForm.Get("client_secret") != "secret" {
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "gho_token"})
+	})
+	authorized := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer gho_token" {
+				http.Error(w, `{"message": "Bad credentials"}`, http.StatusUnauthorized)
+				return
+			}
+			h(w, r)
+		}
+	}
+	mux.HandleFunc("/api.github.com/user", authorized(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(user)
+	}))
+	mux.HandleFunc("/api.github.com/user/emails", authorized(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(emails)
+	}))
+	server := httptest.NewServer(mux)
+
+	saved := httpClient
+	httpClient = &http.Client{Transport: toServer{server}}
+	t.Cleanup(func() {
+		httpClient = saved
+		server.Close()
+	})
+}
+
+func TestGitHubExchange(t *testing.T) {
+	octocat := map[string]interface{}{"id": 583231, "login": "octocat", "name": "The Octocat"}
+	tests := []struct {
+		name    string
+		user    map[string]interface{}
+		emails  []githubEmail
+		code    string
+		want    Identity
+		wantErr bool
+	}{
+		{
+			name: "verified primary email",
+			user: octocat,
+			emails: []githubEmail{
+				{Email: "octo@users.noreply.github.com", Verified: true},
+				{Email: "octocat@github.com", Primary: true, Verified: true},
+			},
+			want: Identity{Provider: "github", Subject: "583231", Email: "octocat@github.com", EmailVerified: true,
+				Username: "octocat", FirstName: "The", LastName: "Octocat"},
+		},
+		{
+			name:   "unverified primary email",
+			user:   map[string]interface{}{"id": 1, "login": "newbie", "name": "Newbie"},
+			emails: []githubEmail{{Email: "newbie@example.com", Primary: true}, {Email: "other@example.com", Verified: true}},
+			want:   Identity{Provider: "github", Subject: "1", Email: "newbie@example.com", Username: "newbie", FirstName: "Newbie"},
+		},
+		{
+			name:    "no primary email",
+			user:    octocat,
+			emails:  []githubEmail{{Email: "octocat@github.com", Verified: true}},
+			wantErr: true,
+		},
+		{
+			name:    "no user ID",
+			user:    map[string]interface{}{"login": "ghost"},
+			emails:  []githubEmail{{Email: "ghost@example.com", Primary: true, Verified: true}},
+			wantErr: true,
+		},
+		{
+			name:    "bad code",
+			user:    octocat,
+			emails:  []githubEmail{{Email: "octocat@github.com", Primary: true, Verified: true}},
+			code:    "stolen-code",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeGitHub(t, tt.user, tt.emails)
+			code := tt.code
+			if code == "" {
+				code = "good-code"
+			}
+			got, err := NewGitHub("client", "secret").Exchange(context.Background(), code, "the-verifier", testRedirectURI)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("Exchange error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Exchange = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitHubNoPrimaryEmailIsErrNoEmail(t *testing.T) {
+	fakeGitHub(t, map[string]interface{}{"id": 2, "login": "private"}, nil)
+	_, err := NewGitHub("client", "secret").Exchange(context.Background(), "good-code", "the-verifier", testRedirectURI)
+	if !errors.Is(err, ErrNoEmail) {
+		t.Errorf("Exchange = %v, want ErrNoEmail", err)
+	}
+}
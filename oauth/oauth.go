@@ -0,0 +1,245 @@
+// Package oauth logs users in through third-party OAuth 2.0 providers:
+// GitHub, Google, and any OpenID Connect provider, such as a company's
+// single sign-on or a local mock provider for development.
+//
+// Every provider uses the authorization code flow with PKCE. The identity is
+// read from the provider's API with the access token, which comes straight
+// from its token endpoint, so no ID token needs to be verified.
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"real/config"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown OAuth provider")
+	ErrNoEmail         = errors.New("provider did not share an email address")
+)
+
+// Identity is who the provider says the user is.
+type Identity struct {
+	// Provider is the name of the provider, e.g. "github"
+	Provider string
+	// Subject identifies the user at the provider and never changes, unlike
+	// their email address or username
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// Username is a suggestion for a new account's username
+	Username  string
+	FirstName string
+	LastName  string
+}
+
+// Provider is a third-party service users can log in with.
+type Provider interface {
+	// Name identifies the provider in URLs and in users.auth_type.
+	Name() string
+	// DisplayName is shown to users, e.g. "GitHub".
+	DisplayName() string
+	// AuthCodeURL is where the user is sent to log in. The provider sends
+	// them back to redirectURI with state and a code.
+	AuthCodeURL(state, codeChallenge, redirectURI string) (string, error)
+	// Exchange turns the code the provider sent back into the identity of
+	// the user who logged in.
+	Exchange(ctx context.Context, code, codeVerifier, redirectURI string) (Identity, error)
+}
+
+// providers are the configured providers, by name, set up by Init.
+var providers = map[string]Provider{}
+
+// httpClient makes every request to the providers.
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// Init sets up the providers that have a client ID configured.
+func Init() error {
+	ps, err := FromConfig()
+	if err != nil {
+		return err
+	}
+	providers = make(map[string]Provider)
+	for _, p := range ps {
+		providers[p.Name()] = p
+	}
+	return nil
+}
+
+// FromConfig builds the providers configured through the OAUTH_* and OIDC_*
+// settings. A provider is left out when its client ID is not set.
+func FromConfig() ([]Provider, error) {
+	var ps []Provider
+	if id := config.String("OAUTH_GITHUB_CLIENT_ID", ""); id != "" {
+		ps = append(ps, NewGitHub(id, config.String("OAUTH_GITHUB_CLIENT_SECRET", "")))
+	}
+	if id := config.String("OAUTH_GOOGLE_CLIENT_ID", ""); id != "" {
+		ps = append(ps, NewOIDC(OIDCConfig{
+			Name:         "google",
+			DisplayName:  "Google",
+			Issuer:       "https://accounts.google.com",
+			ClientID:     id,
+			ClientSecret: config.String("OAUTH_GOOGLE_CLIENT_SECRET", ""),
+		}))
+	}
+	if id := config.String("OIDC_CLIENT_ID", ""); id != "" {
+		issuer := config.String("OIDC_ISSUER", "")
+		if issuer == "" {
+			return nil, errors.New("OIDC login needs OIDC_ISSUER")
+		}
+		ps = append(ps, NewOIDC(OIDCConfig{
+			Name:         "oidc",
+			DisplayName:  config.String("OIDC_NAME", "Single sign-on"),
+			Issuer:       issuer,
+			ClientID:     id,
+			ClientSecret: config.String("OIDC_CLIENT_SECRET", ""),
+		}))
+	}
+	return ps, nil
+}
+
+// Lookup returns the configured provider called name, or ErrUnknownProvider.
+func Lookup(name string) (Provider, error) {
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, name)
+	}
+	return p, nil
+}
+
+// Providers returns the configured providers, sorted by name.
+func Providers() []Provider {
+	ps := make([]Provider, 0, len(providers))
+	for _, p := range providers {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Name() < ps[j].Name() })
+	return ps
+}
+
+// NewCodeVerifier returns a random PKCE code verifier, kept by the server
+// until the user comes back from the provider.
+func NewCodeVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate code verifier: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge is the S256 PKCE challenge for verifier, which is sent to
+// the provider in its place.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authCodeURL adds the parameters of an authorization request to endpoint.
+func authCodeURL(endpoint, clientID, scope, state, codeChallenge, redirectURI string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint %q: %v", endpoint, err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", clientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", scope)
+	q.Set("state", state)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// exchangeCode trades an authorization code for an access token at the
+// token endpoint.
+func exchangeCode(ctx context.Context, tokenURL, clientID, clientSecret, code, codeVerifier, redirectURI string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {clientID},
+		"code_verifier": {codeVerifier},
+	}
+	if clientSecret != "" {
+		form.Set("client_secret", clientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := doJSON(req, &token)
+	if err != nil {
+		return "", fmt.Errorf("exchange code: %w", err)
+	}
+	// GitHub reports errors with a 200, so the body is checked either way
+	if token.Error != "" {
+		return "", fmt.Errorf("exchange code: %s: %s", token.Error, token.ErrorDescription)
+	}
+	if status != http.StatusOK || token.AccessToken == "" {
+		return "", fmt.Errorf("exchange code: token endpoint answered %d without a token", status)
+	}
+	return token.AccessToken, nil
+}
+
+// getJSON fetches url with the access token and decodes the response into v.
+func getJSON(ctx context.Context, url, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	status, err := doJSON(req, v)
+	if err != nil {
+		return fmt.Errorf("get %s: %w", url, err)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("get %s: status %d", url, status)
+	}
+	return nil
+}
+
+// maxResponseSize caps how much of a provider's response is read.
+const maxResponseSize = 1 << 20
+
+// doJSON sends req and decodes the JSON body of the response into v,
+// returning the response status.
+func doJSON(req *http.Request, v interface{}) (int, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("decode response: %v", err)
+	}
+	return resp.StatusCode, nil
+}
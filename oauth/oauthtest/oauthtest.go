@@ -0,0 +1,190 @@
+// Package oauthtest provides a mock OpenID Connect provider for tests. It
+// serves discovery, authorization, token and userinfo endpoints, approves
+// every login as the user set with SetClaims, and checks the client
+// credentials, redirect URI and PKCE code verifier of each code exchange.
+package oauthtest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	ClientID     = "test-client"
+	ClientSecret = "test-secret"
+)
+
+// Provider is a running mock provider. Its issuer is its URL.
+type Provider struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// documentIssuer overrides the issuer the discovery document claims
+	documentIssuer string
+	claims         map[string]interface{}
+	grants         map[string]grant
+	tokens         map[string]map[string]interface{}
+}
+
+// grant is an authorization code waiting to be exchanged.
+type grant struct {
+	challenge   string
+	redirectURI string
+	claims      map[string]interface{}
+}
+
+// NewProvider starts a mock provider. Close it when done.
+func NewProvider() *Provider {
+	p := &Provider{grants: make(map[string]grant), tokens: make(map[string]map[string]interface{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorizeHandler)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/userinfo", p.userinfo)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// SetClaims sets the userinfo claims of whoever logs in next, e.g. "sub",
+// "email" and "email_verified".
+func (p *Provider) SetClaims(claims map[string]interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.claims = claims
+}
+
+// SetDocumentIssuer makes the discovery document claim issuer instead of
+// the provider's URL.
+func (p *Provider) SetDocumentIssuer(issuer string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.documentIssuer = issuer
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	issuer := p.documentIssuer
+	p.mu.Unlock()
+	if issuer == "" {
+		issuer = p.URL
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 issuer,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+		"userinfo_endpoint":      p.URL + "/userinfo",
+	})
+}
+
+// Authorize approves the authorization request authURL as the user set with
+// SetClaims, as the authorization endpoint would, and returns the URL the
+// browser is sent back to.
+func (p *Provider) Authorize(authURL string) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	redirectURI := q.Get("redirect_uri")
+	back, err := url.Parse(redirectURI)
+	if err != nil || redirectURI == "" {
+		return "", errors.New("invalid redirect_uri")
+	}
+	switch {
+	case q.Get("client_id") != ClientID:
+		return "", errors.New("unknown client_id")
+	case q.Get("response_type") != "code":
+		return "", errors.New("unsupported response_type")
+	case q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "":
+		return "", errors.New("PKCE with S256 is required")
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.grants[code] = grant{challenge: q.Get("code_challenge"), redirectURI: redirectURI, claims: p.claims}
+	p.mu.Unlock()
+
+	values := back.Query()
+	values.Set("code", code)
+	values.Set("state", q.Get("state"))
+	back.RawQuery = values.Encode()
+	return back.String(), nil
+}
+
+func (p *Provider) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	back, err := p.Authorize(p.URL + r.URL.RequestURI())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, back, http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if r.PostForm.Get("client_id") != ClientID || r.PostForm.Get("client_secret") != ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, ok := p.grants[code]
+	// Codes work once, whether or not the exchange succeeds
+	delete(p.grants, code)
+	p.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || g.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":             "invalid_grant",
+			"error_description": "unknown code, or wrong redirect_uri or code_verifier",
+		})
+		return
+	}
+
+	token := randomString()
+	p.mu.Lock()
+	p.tokens[token] = g.claims
+	p.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]string{"access_token": token, "token_type": "Bearer"})
+}
+
+func (p *Provider) userinfo(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	p.mu.Lock()
+	claims, ok := p.tokens[token]
+	p.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+	writeJSON(w, http.StatusOK, claims)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,124 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// OIDCConfig describes an OpenID Connect provider.
+type OIDCConfig struct {
+	Name        string
+	DisplayName string
+	// Issuer is the provider's issuer URL, e.g. "https://accounts.google.com"
+	// or "http://localhost:8080/default". Its endpoints are discovered from
+	// <Issuer>/.well-known/openid-configuration.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+}
+
+// OIDC logs users in through an OpenID Connect provider, reading their
+// identity from its userinfo endpoint.
+type OIDC struct {
+	cfg OIDCConfig
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+}
+
+// oidcDiscovery holds the endpoints from the provider's discovery document.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// NewOIDC returns an OIDC provider. Its discovery document is fetched the
+// first time it is used, so the server starts even while the provider is
+// unreachable.
+func NewOIDC(cfg OIDCConfig) *OIDC {
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	return &OIDC{cfg: cfg}
+}
+
+func (p *OIDC) Name() string        { return p.cfg.Name }
+func (p *OIDC) DisplayName() string { return p.cfg.DisplayName }
+
+// discover returns the provider's endpoints, fetching them on first use.
+func (p *OIDC) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	if err := getJSON(ctx, p.cfg.Issuer+"/.well-known/openid-configuration", "", &d); err != nil {
+		return nil, fmt.Errorf("discover %s: %w", p.cfg.Issuer, err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("discover %s: document is for issuer %q", p.cfg.Issuer, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.UserinfoEndpoint == "" {
+		return nil, fmt.Errorf("discover %s: missing endpoints", p.cfg.Issuer)
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+func (p *OIDC) AuthCodeURL(state, codeChallenge, redirectURI string) (string, error) {
+	d, err := p.discover(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return authCodeURL(d.AuthorizationEndpoint, p.cfg.ClientID, "openid email profile", state, codeChallenge, redirectURI)
+}
+
+func (p *OIDC) Exchange(ctx context.Context, code, codeVerifier, redirectURI string) (Identity, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+	token, err := exchangeCode(ctx, d.TokenEndpoint, p.cfg.ClientID, p.cfg.ClientSecret, code, codeVerifier, redirectURI)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var info struct {
+		Subject           string `json:"sub"`
+		Email             string `json:"email"`
+		EmailVerified     *bool  `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+		Nickname          string `json:"nickname"`
+		GivenName         string `json:"given_name"`
+		FamilyName        string `json:"family_name"`
+	}
+	if err := getJSON(ctx, d.UserinfoEndpoint, token, &info); err != nil {
+		return Identity{}, err
+	}
+	if info.Subject == "" {
+		return Identity{}, errors.New("userinfo has no subject")
+	}
+	if info.Email == "" {
+		return Identity{}, ErrNoEmail
+	}
+
+	username := info.PreferredUsername
+	if username == "" {
+		username = info.Nickname
+	}
+	return Identity{
+		Provider: p.cfg.Name,
+		Subject:  info.Subject,
+		Email:    info.Email,
+		// Providers that leave the claim out have not verified the address
+		EmailVerified: info.EmailVerified != nil && *info.EmailVerified,
+		Username:      username,
+		FirstName:     info.GivenName,
+		LastName:      info.FamilyName,
+	}, nil
+}
@@ -0,0 +1,216 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"real/oauth/oauthtest"
+)
+
+const testRedirectURI = "http://forum.test/oauth/callback"
+
+func newTestOIDC(issuer string) *OIDC {
+	return NewOIDC(OIDCConfig{
+		Name:         "oidc",
+		DisplayName:  "Single sign-on",
+		Issuer:       issuer + "/",
+		ClientID:     oauthtest.ClientID,
+		ClientSecret: oauthtest.ClientSecret,
+	})
+}
+
+// login runs the authorization code flow against mock, as the user set with
+// SetClaims, with verifier as the code verifier sent to the token endpoint.
+func login(t *testing.T, p Provider, mock *oauthtest.Provider, verifier string) (Identity, error) {
+	t.Helper()
+	sent, err := NewCodeVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifier == "" {
+		verifier = sent
+	}
+	authURL, err := p.AuthCodeURL("the-state", CodeChallenge(sent), testRedirectURI)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	back, err := mock.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	u, err := url.Parse(back)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Query().Get("state"); got != "the-state" {
+		t.Fatalf("state = %q, want the-state", got)
+	}
+	return p.Exchange(context.Background(), u.Query().Get("code"), verifier, testRedirectURI)
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	mock := oauthtest.NewProvider()
+	defer mock.Close()
+
+	authURL, err := newTestOIDC(mock.URL).AuthCodeURL("the-state", "the-challenge", testRedirectURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != mock.URL+"/authorize" {
+		t.Errorf("endpoint = %s, want the discovered %s/authorize", got, mock.URL)
+	}
+	want := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oauthtest.ClientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"openid email profile"},
+		"state":                 {"the-state"},
+		"code_challenge":        {"the-challenge"},
+		"code_challenge_method": {"S256"},
+	}
+	if got := u.Query(); got.Encode() != want.Encode() {
+		t.Errorf("query = %v, want %v", got, want)
+	}
+}
+
+func TestOIDCDiscovery(t *testing.T) {
+	t.Run("issuer mismatch", func(t *testing.T) {
+		mock := oauthtest.NewProvider()
+		defer mock.Close()
+		mock.SetDocumentIssuer("https://attacker.example")
+
+		_, err := newTestOIDC(mock.URL).AuthCodeURL("state", "challenge", testRedirectURI)
+		if err == nil || !strings.Contains(err.Error(), "attacker.example") {
+			t.Errorf("AuthCodeURL = %v, want an issuer mismatch", err)
+		}
+	})
+
+	t.Run("missing endpoints", func(t *testing.T) {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"issuer": "` + server.URL + `", "authorization_endpoint": "` + server.URL + `/authorize"}`))
+		}))
+		defer server.Close()
+
+		if _, err := newTestOIDC(server.URL).AuthCodeURL("state", "challenge", testRedirectURI); err == nil {
+			t.Error("AuthCodeURL succeeded without token and userinfo endpoints")
+		}
+	})
+
+	t.Run("unreachable, then up", func(t *testing.T) {
+		mock := oauthtest.NewProvider()
+		p := newTestOIDC(mock.URL)
+		mock.Close()
+		if _, err := p.AuthCodeURL("state", "challenge", testRedirectURI); err == nil {
+			t.Fatal("AuthCodeURL succeeded with the provider down")
+		}
+		// A failed discovery is not remembered
+		up := oauthtest.NewProvider()
+		defer up.Close()
+		p.cfg.Issuer = up.URL
+		if _, err := p.AuthCodeURL("state", "challenge", testRedirectURI); err != nil {
+			t.Errorf("AuthCodeURL after the provider came up: %v", err)
+		}
+	})
+}
+
+func TestOIDCExchange(t *testing.T) {
+	tests := []struct {
+		name     string
+		claims   map[string]interface{}
+		verifier string
+		want     Identity
+		wantErr  error
+	}{
+		{
+			name: "verified email",
+			claims: map[string]interface{}{
+				"sub": "user-1", "email": "ada@example.com", "email_verified": true,
+				"preferred_username": "ada", "nickname": "countess", "given_name": "Ada", "family_name": "Lovelace",
+			},
+			want: Identity{Provider: "oidc", Subject: "user-1", Email: "ada@example.com", EmailVerified: true,
+				Username: "ada", FirstName: "Ada", LastName: "Lovelace"},
+		},
+		{
+			name:   "unverified email",
+			claims: map[string]interface{}{"sub": "user-2", "email": "bob@example.com", "email_verified": false},
+			want:   Identity{Provider: "oidc", Subject: "user-2", Email: "bob@example.com"},
+		},
+		{
+			name:   "email_verified left out",
+			claims: map[string]interface{}{"sub": "user-3", "email": "eve@example.com", "nickname": "eve"},
+			want:   Identity{Provider: "oidc", Subject: "user-3", Email: "eve@example.com", Username: "eve"},
+		},
+		{
+			name:    "no email",
+			claims:  map[string]interface{}{"sub": "user-4", "email_verified": true},
+			wantErr: ErrNoEmail,
+		},
+		{
+			name:    "no subject",
+			claims:  map[string]interface{}{"email": "nobody@example.com", "email_verified": true},
+			wantErr: errors.New("userinfo has no subject"),
+		},
+		{
+			name:     "wrong code verifier",
+			claims:   map[string]interface{}{"sub": "user-5", "email": "mallory@example.com", "email_verified": true},
+			verifier: "not-the-verifier-sent-with-the-challenge",
+			wantErr:  errors.New("invalid_grant"),
+		},
+	}
+
+	mock := oauthtest.NewProvider()
+	defer mock.Close()
+	p := newTestOIDC(mock.URL)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.SetClaims(tt.claims)
+			got, err := login(t, p, mock, tt.verifier)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("Exchange: %v", err)
+			case tt.wantErr == ErrNoEmail && !errors.Is(err, ErrNoEmail):
+				t.Fatalf("Exchange = %v, want ErrNoEmail", err)
+			case tt.wantErr != nil && (err == nil || !strings.Contains(err.Error(), tt.wantErr.Error())):
+				t.Fatalf("Exchange = %v, want an error containing %q", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Exchange = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCCodeWorksOnce(t *testing.T) {
+	mock := oauthtest.NewProvider()
+	defer mock.Close()
+	mock.SetClaims(map[string]interface{}{"sub": "user-1", "email": "ada@example.com", "email_verified": true})
+	p := newTestOIDC(mock.URL)
+
+	verifier, _ := NewCodeVerifier()
+	authURL, err := p.AuthCodeURL("state", CodeChallenge(verifier), testRedirectURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := mock.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(back)
+	code := u.Query().Get("code")
+	if _, err := p.Exchange(context.Background(), code, verifier, testRedirectURI); err != nil {
+		t.Fatalf("first Exchange: %v", err)
+	}
+	if _, err := p.Exchange(context.Background(), code, verifier, testRedirectURI); err == nil {
+		t.Error("second Exchange of the same code succeeded")
+	}
+}
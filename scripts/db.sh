@@ -42,6 +42,7 @@ show_help() {
     echo "  status   - Check database status"
     echo "  backup   - Create a backup of the current database"
     echo "  clean    - Remove database and backup files"
+    echo "  role     - Set a user's role: role <username> <user|moderator|admin>"
     echo "  help     - Show this help message"
     echo ""
     echo "Examples:"
@@ -49,6 +50,7 @@ show_help() {
     echo "  ./scripts/db.sh reset    # Reset database (WARNING: deletes all data)"
     echo "  ./scripts/db.sh status   # Check if database exists"
     echo "  ./scripts/db.sh backup   # Create backup before making changes"
+    echo "  ./scripts/db.sh role alice moderator  # Let alice moderate content"
 }
 
 # Function to check database status
@@ -122,6 +124,36 @@ clean_database() {
     fi
 }
 
+# Function to change a user's role
+set_role() {
+    local username="$1"
+    local role="$2"
+
+    if [ -z "$username" ] || [ -z "$role" ]; then
+        print_error "Usage: ./scripts/db.sh role <username> <user|moderator|admin>"
+        return 1
+    fi
+    case "$role" in
+        user|moderator|admin) ;;
+        *)
+            print_error "Unknown role: $role"
+            return 1
+            ;;
+    esac
+    if [ ! -f "$DB_FILE" ]; then
+        print_error "Database does not exist: $DB_FILE"
+        return 1
+    fi
+
+    updated=$(sqlite3 "$DB_FILE" "UPDATE users SET role = '$role' WHERE username = '${username//\'/\'\'}'; SELECT changes();")
+    if [ "$updated" = "1" ]; then
+        print_status "$username is now $role"
+    else
+        print_error "No user named $username"
+        return 1
+    fi
+}
+
 # Main script logic
 case "${1:-help}" in
     "init")
@@ -139,6 +171,9 @@ case "${1:-help}" in
     "clean")
         clean_database
         ;;
+    "role")
+        set_role "$2" "$3"
+        ;;
     "help"|*)
         show_help
         ;;
@@ -0,0 +1,105 @@
+// Command migrate_media copies uploads kept on the local disk into the media
+// storage configured by the MEDIA_* and S3_* environment variables, e.g. when
+// moving to an S3-compatible bucket:
+//
+//	MEDIA_STORAGE=s3 S3_ENDPOINT=http://localhost:9000 S3_BUCKET=forum \
+//	S3_ACCESS_KEY=... S3_SECRET_KEY=... go run ./scripts/migrate_media
+//
+// Keys are the file paths relative to -from, which is what the database
+// stores, so no rows change. Copying is idempotent and can be re-run.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"real/storage"
+)
+
+func main() {
+	from := flag.String("from", "static/images", "local directory the uploads are in")
+	prefix := flag.String("prefix", "posts/", "only copy keys starting with this")
+	deleteSource := flag.Bool("delete", false, "remove each local file once it is copied")
+	dryRun := flag.Bool("dry-run", false, "list the files that would be copied")
+	flag.Parse()
+
+	dest, err := storage.FromConfig()
+	if err != nil {
+		log.Fatalf("❌ Media storage: %v", err)
+	}
+	if local, ok := dest.(*storage.Local); ok && sameDir(local.Dir, *from) {
+		log.Fatalf("❌ Destination is %s already; set MEDIA_STORAGE or MEDIA_DIR to where files should go", local.Dir)
+	}
+	source, err := storage.NewLocal(*from, "", "")
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	var copied, failed int
+	err = filepath.WalkDir(*from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(*from, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, *prefix) {
+			return nil
+		}
+
+		if *dryRun {
+			fmt.Println(key)
+			copied++
+			return nil
+		}
+		if err := copyKey(source, dest, key, *deleteSource); err != nil {
+			log.Printf("⚠️  %s: %v", key, err)
+			failed++
+			return nil
+		}
+		copied++
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("❌ Reading %s: %v", *from, err)
+	}
+
+	if *dryRun {
+		fmt.Printf("%d files would be copied\n", copied)
+		return
+	}
+	fmt.Printf("✅ Copied %d files", copied)
+	if failed > 0 {
+		fmt.Printf(", %d failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println()
+}
+
+func copyKey(source *storage.Local, dest storage.Storage, key string, deleteSource bool) error {
+	data, err := source.Get(key)
+	if err != nil {
+		return err
+	}
+	if err := dest.Put(key, data, mime.TypeByExtension(filepath.Ext(key))); err != nil {
+		return err
+	}
+	if deleteSource {
+		return source.Delete(key)
+	}
+	return nil
+}
+
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -0,0 +1,53 @@
+import { escapeHtml } from './helpers.js';
+
+// loadAnnouncements shows the announcements the user has not dismissed yet,
+// including the ones made while they were offline.
+export async function loadAnnouncements() {
+    try {
+        const response = await fetch('/api/announcements?unread=true', { credentials: 'include' });
+        if (!response.ok) return;
+        const announcements = await response.json();
+        announcements.reverse().forEach(showAnnouncement);
+    } catch (error) {
+        console.error('Error loading announcements:', error);
+    }
+}
+
+// handleAnnouncement shows an announcement pushed over the websocket.
+export function handleAnnouncement(payload) {
+    showAnnouncement(payload);
+    if ('Notification' in window && Notification.permission === 'granted') {
+        new Notification(`Announcement from ${payload.username}`, { body: payload.title });
+    }
+}
+
+function showAnnouncement(announcement) {
+    const container = document.getElementById('announcements-container');
+    if (!container || container.querySelector(`[data-post-id="${announcement.post_id}"]`)) return;
+
+    const banner = document.createElement('div');
+    banner.className = 'announcement-banner';
+    banner.dataset.postId = announcement.post_id;
+    banner.innerHTML = `
+        <i class="fas fa-bullhorn"></i>
+        <span class="announcement-title">${escapeHtml(announcement.title)}</span>
+        <span class="announcement-author">by ${escapeHtml(announcement.username)}</span>
+        <button class="announcement-dismiss" title="Dismiss">&times;</button>
+    `;
+    banner.querySelector('.announcement-dismiss').addEventListener('click', () => dismissAnnouncement(banner));
+    container.prepend(banner);
+}
+
+async function dismissAnnouncement(banner) {
+    banner.remove();
+    try {
+        await fetch('/api/announcements', {
+            method: 'POST',
+            credentials: 'include',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ post_id: Number(banner.dataset.postId) }),
+        });
+    } catch (error) {
+        console.error('Error dismissing announcement:', error);
+    }
+}
@@ -37,6 +37,104 @@ export function getUserId() {
     return user.id || 0;
 }
 
+// pendingToken is set while a login waits for its two-factor code.
+let pendingToken = null;
+
+// completeTwoFactorLogin sends the code for the pending login and returns the
+// login response.
+async function completeTwoFactorLogin(code) {
+    const response = await fetch('/login/2fa', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ pending_token: pendingToken, code }),
+        credentials: 'include'
+    });
+    const data = await response.json();
+    if (response.status === 401 && data.message !== 'Invalid code') {
+        // The pending login is gone; start again from the password
+        resetTwoFactor();
+    }
+    if (!response.ok) throw new Error(data.message || 'Login failed');
+    resetTwoFactor();
+    return data;
+}
+
+function resetTwoFactor() {
+    pendingToken = null;
+    const group = document.getElementById('two-factor-group');
+    if (group) group.hidden = true;
+    const input = document.getElementById('two-factor-code');
+    if (input) input.value = '';
+    setPasswordRequired(true);
+}
+
+// setPasswordRequired makes the identifier and password fields required, or
+// not while a login through a provider waits for its two-factor code.
+function setPasswordRequired(required) {
+    ['identifier', 'password'].forEach(id => {
+        const input = document.getElementById(id);
+        if (input) input.required = required;
+    });
+}
+
+// startTwoFactorLogin asks for the two-factor code of a login through a
+// provider, which came back with a pending token instead of a session.
+export function startTwoFactorLogin(token) {
+    pendingToken = token;
+    setPasswordRequired(false);
+    document.getElementById('two-factor-group').hidden = false;
+    document.getElementById('two-factor-code').focus();
+}
+
+// oauthIcons are the Font Awesome icons of the known providers.
+const oauthIcons = { github: 'fab fa-github', google: 'fab fa-google' };
+
+// loadOAuthProviders adds a button to the login form for every provider
+// users can log in with.
+export async function loadOAuthProviders() {
+    const container = document.getElementById('oauth-providers');
+    if (!container) return;
+    try {
+        const response = await fetch('/api/oauth/providers', { credentials: 'include' });
+        if (!response.ok) return;
+        const data = await response.json();
+        container.replaceChildren(...data.providers.map(provider => {
+            const link = document.createElement('a');
+            link.className = 'oauth-btn';
+            link.href = `/oauth/login?provider=${encodeURIComponent(provider.id)}`;
+            const icon = document.createElement('i');
+            icon.className = oauthIcons[provider.id] || 'fas fa-key';
+            link.append(icon, ` Log in with ${provider.name}`);
+            link.addEventListener('click', () => {
+                // Carry the remember-me choice through the provider
+                const rememberMe = document.getElementById('remember-me')?.checked || false;
+                link.href = `/oauth/login?provider=${encodeURIComponent(provider.id)}&remember_me=${rememberMe}`;
+            });
+            return link;
+        }));
+        container.hidden = data.providers.length === 0;
+    } catch (error) {
+        console.error('Error loading login providers:', error);
+    }
+}
+
+// oauthErrors explain the oauth_error codes the server redirects back with.
+const oauthErrors = {
+    expired: 'The login took too long or was started elsewhere. Please try again.',
+    denied: 'The login was cancelled.',
+    unavailable: 'That login provider is not available right now.',
+    no_email: 'The provider did not share your email address, which is needed for an account.',
+    email_in_use: 'An account already uses this email address. Log in with your password, then link the provider from your account.',
+    email_unverified: 'The provider has not verified your email address. Verify it there, or sign up with your email address instead.',
+    account_linked: 'This account is already linked to another login provider.',
+    identity_linked: 'That provider account is already linked to another user.',
+    failed: 'Logging in through the provider failed. Please try again.'
+};
+
+export function oauthErrorMessage(code) {
+    return oauthErrors[code] || oauthErrors.failed;
+}
+
 export async function handleLogin() {
     const form = document.getElementById('login-form');
     if (!form) {
@@ -46,9 +144,10 @@ export async function handleLogin() {
 
     const identifier = form.querySelector('#identifier').value;
     const password = form.querySelector('#password').value;
+    const rememberMe = form.querySelector('#remember-me')?.checked || false;
     const submitBtn = form.querySelector('button[type="submit"]');
 
-    if (!identifier || !password) {
+    if (!pendingToken && (!identifier || !password)) {
         const errorElement = document.getElementById('identifier-error') || 
                            document.getElementById('password-error');
         if (errorElement) {
@@ -63,16 +162,30 @@ export async function handleLogin() {
     submitBtn.disabled = true;
 
     try {
-        const response = await fetch('/login', {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ identifier, password }),
-            credentials: 'include'
-        });
-        
-        const { ok, data } = await response.json().then(data => ({ ok: response.ok, data }));
-        
-        if (!ok) throw new Error(data.message || 'Login failed');
+        let data;
+        if (pendingToken) {
+            const code = form.querySelector('#two-factor-code').value.trim();
+            if (!code) throw new Error('Enter your authentication code');
+            data = await completeTwoFactorLogin(code);
+        } else {
+            const response = await fetch('/login', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ identifier, password, remember_me: rememberMe }),
+                credentials: 'include'
+            });
+
+            const result = await response.json().then(data => ({ ok: response.ok, data }));
+            data = result.data;
+
+            if (!result.ok) throw new Error(data.message || 'Login failed');
+            if (data.two_factor_required) {
+                pendingToken = data.pending_token;
+                form.querySelector('#two-factor-group').hidden = false;
+                form.querySelector('#two-factor-code').focus();
+                throw new Error('Enter the code from your authenticator app');
+            }
+        }
 
         localStorage.setItem('isAuthenticated', 'true');
         if (data.token) localStorage.setItem('auth_token', data.token);
@@ -100,6 +213,47 @@ export function handleLogout() {
     localStorage.removeItem('user');
 }
 
+// logout ends the session on the server, then clears the local auth state
+// even if the server could not be reached.
+export async function logout() {
+    try {
+        await fetch('/logout', { method: 'POST', credentials: 'include' });
+    } catch (error) {
+        console.error('Logout error:', error);
+    }
+    handleLogout();
+}
+
+// requestPasswordReset asks for a password reset email and returns the
+// server's message, which does not say whether the account exists.
+export async function requestPasswordReset(email) {
+    const response = await fetch('/password/forgot', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ email }),
+        credentials: 'include'
+    });
+    const data = await response.json();
+    if (!response.ok) throw new Error(data.error || 'Could not send the reset link');
+    return data.message;
+}
+
+// resetPassword sets a new password with the token from a reset email.
+export async function resetPassword(token, password, confirmPassword) {
+    const response = await fetch('/password/reset', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token, password, confirm_password: confirmPassword }),
+        credentials: 'include'
+    });
+    const data = await response.json();
+    if (!response.ok) {
+        const message = data.error || (data.errors && Object.values(data.errors)[0]);
+        throw new Error(message || 'Could not reset the password');
+    }
+    return data.message;
+}
+
 export async function handleRegister() {
     const form = document.getElementById('register-form');
     if (!form) {
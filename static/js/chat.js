@@ -1,5 +1,6 @@
 import {  throttleScroll } from './helpers.js';
 import { formatDate, escapeHtml } from './helpers.js';
+import { handleAnnouncement } from './announcements.js';
 
 let ws;
 let currentUserId = null;
@@ -159,6 +160,8 @@ function connectWebSocket(url) {
                 handleNewMessage(message.payload);
             } else if (message.type === 'user_status_update') {
                 handleUserStatusUpdate(message.payload);
+            } else if (message.type === 'announcement') {
+                handleAnnouncement(message.payload);
             }
         } catch (error) {
             console.error("Error parsing WebSocket message:", error);
@@ -167,7 +170,8 @@ function connectWebSocket(url) {
 
     ws.onclose = (event) => {
         console.log("WebSocket connection closed.", event.code, event.reason);
-        if (event.code === 1006 || event.code === 1011) {
+        // 4001: this session was logged out or revoked, maybe in another tab
+        if (event.code === 1006 || event.code === 1011 || event.code === 4001) {
             console.log("WebSocket closed due to authentication issues");
             // Trigger session validation
             window.location.reload();
@@ -49,7 +49,7 @@ export async function showComments(postId) {
 
             list.innerHTML = ''; 
             if (comments && comments.length > 0) {
-                comments.forEach(comment => addCommentToUI(comment));
+                renderCommentTree(comments);
             } else {
                 list.innerHTML = '<p class="text-center" style="padding: 1rem;">No comments yet. Be the first to comment!</p>';
             }
@@ -67,6 +67,19 @@ export async function showComments(postId) {
 
 
 
+/**
+ * Renders a comment tree depth-first so replies follow their parent.
+ * @param {Array} comments
+ */
+function renderCommentTree(comments) {
+    comments.forEach(comment => {
+        addCommentToUI(comment);
+        if (comment.replies && comment.replies.length > 0) {
+            renderCommentTree(comment.replies);
+        }
+    });
+}
+
 export function addCommentToUI(comment) {
     const list = document.getElementById(`comment-list-for-post-${comment.post_id}`);
     if (!list) return;
@@ -80,6 +93,7 @@ export function addCommentToUI(comment) {
     const commentEl = document.createElement('div');
     commentEl.className = 'comment-card'; // Use the new card class
     commentEl.setAttribute('data-comment-id', comment.comment_id);
+    commentEl.style.marginLeft = `${(comment.depth || 0) * 1.5}rem`;
 
     const authorName = escapeHtml(comment.author);
     const initial = authorName.charAt(0).toUpperCase();
@@ -93,7 +107,7 @@ export function addCommentToUI(comment) {
             ${initial}
         </div>
         <div class="comment-body">
-            <p class="comment-author">${authorName}</p>
+            <p class="comment-author">${authorName}${comment.edited && !comment.deleted ? ' <span class="comment-edited">(edited)</span>' : ''}</p>
             <p class="comment-content">${escapeHtml(comment.content)}</p>
             <div class="comment-actions">
                 <button class="action-btn like-btn ${likeActiveClass}" onclick="handleCommentReaction(${comment.comment_id}, 'like')">
@@ -102,13 +116,145 @@ export function addCommentToUI(comment) {
                 <button class="action-btn dislike-btn ${dislikeActiveClass}" onclick="handleCommentReaction(${comment.comment_id}, 'dislike')">
                     <i class="far fa-thumbs-down"></i> <span class="dislike-count">${comment.dislikes || 0}</span>
                 </button>
+                ${comment.deleted ? '' : `
+                <button class="action-btn reply-btn" onclick="handleReplyComment(${comment.comment_id})">
+                    <i class="far fa-comment"></i> Reply
+                </button>`}
+                ${comment.can_edit ? `
+                <button class="action-btn edit-btn" onclick="handleEditComment(${comment.comment_id})">
+                    <i class="far fa-edit"></i> Edit
+                </button>` : ''}
+                ${comment.can_delete ? `
+                <button class="action-btn delete-btn" onclick="handleDeleteComment(${comment.comment_id})">
+                    <i class="far fa-trash-alt"></i> Delete
+                </button>` : ''}
+                ${comment.collapsed ? `
+                <button class="action-btn expand-btn" onclick="expandCommentThread(${comment.post_id}, ${comment.comment_id})">
+                    Show ${comment.total_replies} more ${comment.total_replies === 1 ? 'reply' : 'replies'}
+                </button>` : ''}
             </div>
         </div>
     `;
   
+    // Replies are inserted after the last comment of their parent's thread
+    const parentEl = comment.parent_comment_id
+        ? list.querySelector(`.comment-card[data-comment-id="${comment.parent_comment_id}"]`)
+        : null;
+    if (parentEl) {
+        let anchor = parentEl;
+        while (anchor.nextElementSibling &&
+               parseInt(anchor.nextElementSibling.dataset.depth || '0') > (comment.depth - 1)) {
+            anchor = anchor.nextElementSibling;
+        }
+        commentEl.dataset.depth = comment.depth;
+        anchor.after(commentEl);
+        return;
+    }
+    commentEl.dataset.depth = comment.depth || 0;
     list.appendChild(commentEl);
 }
 
+export async function handleReplyComment(commentId) {
+    if (!isLoggedIn()) {
+        alert('Please log in to reply');
+        return;
+    }
+
+    const content = (prompt('Write a reply') || '').trim();
+    if (!content) return;
+
+    try {
+        const response = await fetch('/comment/reply', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/x-www-form-urlencoded',
+            },
+            credentials: 'include',
+            body: new URLSearchParams({
+                'comment_id': commentId,
+                'content': content
+            })
+        });
+
+        if (!response.ok) {
+            const error = await response.json();
+            throw new Error(error.error || 'Failed to post reply');
+        }
+
+        addCommentToUI(await response.json());
+    } catch (error) {
+        console.error('Error posting reply:', error);
+        alert(error.message || 'Failed to post reply');
+    }
+}
+
+export async function handleEditComment(commentId) {
+    const commentEl = document.querySelector(`.comment-card[data-comment-id="${commentId}"]`);
+    const current = commentEl ? commentEl.querySelector('.comment-content').textContent : '';
+    const content = (prompt('Edit your comment', current) || '').trim();
+    if (!content || content === current) return;
+
+    await submitCommentChange('/comment/edit', { 'comment_id': commentId, 'content': content });
+}
+
+export async function handleDeleteComment(commentId) {
+    if (!confirm('Delete this comment?')) return;
+
+    await submitCommentChange('/comment/delete', { 'comment_id': commentId });
+}
+
+async function submitCommentChange(url, fields) {
+    try {
+        const response = await fetch(url, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/x-www-form-urlencoded',
+            },
+            credentials: 'include',
+            body: new URLSearchParams(fields)
+        });
+
+        const data = await response.json();
+        if (!response.ok) {
+            throw new Error(data.error || 'Failed to update comment');
+        }
+        replaceCommentInUI(data);
+    } catch (error) {
+        console.error('Error updating comment:', error);
+        alert(error.message || 'Failed to update comment');
+    }
+}
+
+function replaceCommentInUI(comment) {
+    const existing = document.querySelector(`.comment-card[data-comment-id="${comment.comment_id}"]`);
+    if (!existing) return;
+
+    // Render next to the old element, then swap it in
+    const marker = document.createElement('div');
+    existing.before(marker);
+    existing.remove();
+    addCommentToUI(comment);
+    const rendered = document.querySelector(`.comment-card[data-comment-id="${comment.comment_id}"]`);
+    if (rendered) marker.replaceWith(rendered);
+    else marker.remove();
+}
+
+export async function expandCommentThread(postId, commentId) {
+    try {
+        const response = await fetch(`/comments?post_id=${postId}&parent_id=${commentId}`, { credentials: 'include' });
+        if (!response.ok) {
+            throw new Error('Failed to load replies.');
+        }
+        const replies = await response.json();
+
+        const expandBtn = document.querySelector(`.comment-card[data-comment-id="${commentId}"] .expand-btn`);
+        if (expandBtn) expandBtn.remove();
+        if (replies) renderCommentTree(replies);
+    } catch (error) {
+        console.error('Error fetching replies:', error);
+    }
+}
+
 
 export async function handleCreateComment(event, postId) {
     event.preventDefault();
@@ -160,15 +306,8 @@ export async function handleCommentReaction(commentId, reactionType) {
         return;
     }
 
-    const user = JSON.parse(localStorage.getItem('user') || '{}');
-    const userId = String(user.id);
-    if (!userId || userId === 'undefined' || userId === 'null') { 
-        alert('User information not found. Please log in again.');
-        return;
-    }
-
-    const commentIdStr = String(commentId);
-    if (!commentIdStr || commentIdStr === 'undefined') {
+    const commentIdNum = Number(commentId);
+    if (!commentIdNum) {
         console.error("Invalid comment ID:", commentId);
         return;
     }
@@ -182,19 +321,17 @@ export async function handleCommentReaction(commentId, reactionType) {
             },
             credentials: 'include',
             body: JSON.stringify({
-                user_id: userId,         
-                comment_id: commentIdStr, 
+                comment_id: commentIdNum,
                 like_type: reactionType
             })
         });
 
+        const data = await response.json();
         if (!response.ok) {
-            const error = await response.text();
-            throw new Error(error || 'Failed to process reaction');
+            throw new Error(data.error || 'Failed to process reaction');
         }
-        
-        const data = await response.json();
-        updateCommentReactionsUI(parseInt(commentIdStr), data);
+
+        updateCommentReactionsUI(commentIdNum, data);
     } catch (error) {
         console.error(`Error handling comment ${reactionType}:`, error);
         alert(error.message || 'Failed to process reaction');
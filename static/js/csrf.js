@@ -0,0 +1,26 @@
+// Requests that change something must carry the session's CSRF token, which
+// the server keeps in the csrf_token cookie. Wrapping fetch adds it to every
+// such request to this site, so callers need not remember to.
+const CSRF_COOKIE = 'csrf_token';
+const CSRF_HEADER = 'X-CSRF-Token';
+const SAFE_METHODS = ['GET', 'HEAD', 'OPTIONS'];
+
+export function getCSRFToken() {
+    const cookie = document.cookie.split('; ').find(c => c.startsWith(`${CSRF_COOKIE}=`));
+    return cookie ? decodeURIComponent(cookie.slice(CSRF_COOKIE.length + 1)) : '';
+}
+
+const originalFetch = window.fetch.bind(window);
+
+window.fetch = (resource, init = {}) => {
+    const method = (init.method || (resource instanceof Request ? resource.method : 'GET')).toUpperCase();
+    const url = new URL(resource instanceof Request ? resource.url : resource, window.location.href);
+    const token = getCSRFToken();
+
+    if (!SAFE_METHODS.includes(method) && url.origin === window.location.origin && token) {
+        const headers = new Headers(init.headers || (resource instanceof Request ? resource.headers : undefined));
+        headers.set(CSRF_HEADER, token);
+        init = { ...init, headers };
+    }
+    return originalFetch(resource, init);
+};
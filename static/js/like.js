@@ -6,18 +6,11 @@ export async function handleReaction(postId, reactionType) {
         return;
     }
 
-    const user = JSON.parse(localStorage.getItem('user') || {});
-    const userId = user.id;
     const token = localStorage.getItem('auth_token');
-    
-    if (!userId || !token) {
-        alert('User information not found. Please log in again.');
-        return;
-    }
 
     try {
+        // The server identifies the user from the session
         const payload = {
-            user_id: Number(userId),
             post_id: Number(postId),
             like_type: reactionType.toLowerCase()
         };
@@ -76,4 +69,12 @@ export function updatePostReactionsUI(postId, data) {
     // Update active states
     likeBtn.classList.toggle('active', data.userReaction === 'like');
     dislikeBtn.classList.toggle('active', data.userReaction === 'dislike');
+
+    // Emoji reactions beyond like/dislike
+    postCard.querySelectorAll('.emoji-reaction-btn').forEach(btn => {
+        const code = btn.dataset.reaction;
+        const count = btn.querySelector('.reaction-count');
+        if (count) count.textContent = (data.counts && data.counts[code]) || 0;
+        btn.classList.toggle('active', data.userReaction === code);
+    });
 }
\ No newline at end of file
@@ -1,8 +1,10 @@
-import { isLoggedIn, getUserId, handleLogin, handleLogout, handleRegister, validateSession } from './auth.js';
+import './csrf.js';
+import { isLoggedIn, getUserId, handleLogin, handleLogout, logout, handleRegister, validateSession, requestPasswordReset, resetPassword, loadOAuthProviders, startTwoFactorLogin, oauthErrorMessage } from './auth.js';
 import { assignChatDomElements, setupChatEventListeners, initializeChat, fetchAndRenderOnlineUsers } from './chat.js';
-import { handleCreatePost, loadPosts, displayPosts, loadCategories } from './post.js';
+import { handleCreatePost, loadPosts, displayPosts, loadCategories, setupTagAutocomplete } from './post.js';
 import { handleReaction, updatePostReactionsUI } from './like.js';
-import { showComments, handleCreateComment, handleCommentReaction } from './comment.js';
+import { showComments, handleCreateComment, handleCommentReaction, handleReplyComment, expandCommentThread, handleEditComment, handleDeleteComment } from './comment.js';
+import { loadAnnouncements } from './announcements.js';
 import { escapeHtml } from './helpers.js';
 import { formatDate } from './helpers.js';
 import { scrollToBottom } from './helpers.js';
@@ -16,6 +18,10 @@ document.addEventListener('DOMContentLoaded', async function () {
     setupChatEventListeners();
     showPage('home');
     loadCategories();
+    setupTagAutocomplete();
+    handleEmailLinks();
+    loadOAuthProviders();
+    await handleOAuthRedirect();
 
     // Validate session on page load
     await initializeAuth();
@@ -34,6 +40,54 @@ document.addEventListener('DOMContentLoaded', async function () {
     }, 5 * 60 * 1000); // 5 minutes
 });
 
+// handleEmailLinks deals with the links in password reset and verification
+// emails, which open the site with a query parameter.
+function handleEmailLinks() {
+    const params = new URLSearchParams(window.location.search);
+    const resetToken = params.get('reset_token');
+    const emailVerified = params.get('email_verified');
+    if (!resetToken && emailVerified === null) return;
+
+    // Keep the token out of the history and of later page loads
+    history.replaceState(null, '', window.location.pathname);
+
+    if (resetToken) {
+        document.getElementById('reset-token').value = resetToken;
+        showPage('reset-password');
+    } else if (emailVerified === 'true') {
+        alert('Your email address is verified. Thank you!');
+    } else {
+        alert('This verification link is invalid or has expired.');
+    }
+}
+
+// handleOAuthRedirect deals with the server sending the browser back from a
+// login provider, which it does with a query parameter saying how it went.
+async function handleOAuthRedirect() {
+    const params = new URLSearchParams(window.location.search);
+    const login = params.get('oauth_login');
+    const error = params.get('oauth_error');
+    const linked = params.get('oauth_linked');
+    const twoFactorToken = params.get('two_factor_token');
+    if (!login && !error && !linked && !twoFactorToken) return;
+
+    // Keep the parameters out of the history and of later page loads
+    history.replaceState(null, '', window.location.pathname);
+
+    if (login === 'success') {
+        // The session cookie is set; fetch the user it belongs to
+        await validateSession();
+    } else if (twoFactorToken) {
+        showPage('login');
+        startTwoFactorLogin(twoFactorToken);
+    } else if (linked) {
+        alert('Your account is now linked. You can log in with it from now on.');
+    } else {
+        showPage('login');
+        alert(oauthErrorMessage(error));
+    }
+}
+
 // Initialize authentication state
 async function initializeAuth() {
     if (isLoggedIn()) {
@@ -44,6 +98,7 @@ async function initializeAuth() {
             if (userId) {
                 console.log("User session validated. Initializing chat.");
                 initializeChat(userId);
+                loadAnnouncements();
             }
         } else {
             console.log("Session validation failed, clearing auth state");
@@ -267,10 +322,40 @@ export function setupForms() {
                 const userId = getUserId();
                 if (userId) {
                     initializeChat(userId);
+                    loadAnnouncements();
                 }
             } catch (error) {
                 console.error('Login failed:', error);
             }
+        } else if (e.target.id === 'forgot-password-form') {
+            e.preventDefault();
+            const errorElement = document.getElementById('forgot-email-error');
+            try {
+                const message = await requestPasswordReset(document.getElementById('forgot-email').value.trim());
+                errorElement.textContent = '';
+                alert(message);
+                showPage('login');
+            } catch (error) {
+                errorElement.textContent = error.message;
+            }
+        } else if (e.target.id === 'reset-password-form') {
+            e.preventDefault();
+            const errorElement = document.getElementById('reset-password-error');
+            try {
+                const message = await resetPassword(
+                    document.getElementById('reset-token').value,
+                    document.getElementById('reset-password').value,
+                    document.getElementById('reset-confirm-password').value
+                );
+                errorElement.textContent = '';
+                e.target.reset();
+                handleLogout();
+                updateAuthUI();
+                alert(message);
+                showPage('login');
+            } catch (error) {
+                errorElement.textContent = error.message;
+            }
         } else if (e.target.id === 'register-form') {
             e.preventDefault();
             console.log('Register form submitted');
@@ -336,7 +421,7 @@ export function setupForms() {
         } else if (e.target.id === 'logout-form') {
             e.preventDefault();
             closeUserDropdown(); // Close dropdown before logout
-            handleLogout();
+            await logout();
             showPage('home');
             updateAuthUI();
             // Hide chat system when logged out
@@ -350,7 +435,12 @@ export function setupForms() {
 
 // Make functions available globally for HTML onclick handlers
 window.showPage = showPage;
+window.filterByTag = tag => loadPosts({ tags: [tag] });
 window.handleReaction = handleReaction;
 window.showComments = showComments;
 window.handleCreateComment = handleCreateComment;
-window.handleCommentReaction = handleCommentReaction;
\ No newline at end of file
+window.handleCommentReaction = handleCommentReaction;
+window.handleReplyComment = handleReplyComment;
+window.expandCommentThread = expandCommentThread;
+window.handleEditComment = handleEditComment;
+window.handleDeleteComment = handleDeleteComment;
\ No newline at end of file
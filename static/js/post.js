@@ -86,13 +86,61 @@ export async function handleCreatePost() {
 }
 
 
+// Reaction types other than like/dislike, keyed by code, for emoji buttons
+let extraReactionTypes = null;
+
+async function loadReactionTypes() {
+    if (extraReactionTypes) return extraReactionTypes;
+    extraReactionTypes = {};
+    try {
+        const response = await fetch('/api/reaction-types', { credentials: 'include' });
+        if (response.ok) {
+            const types = await response.json();
+            types.filter(t => t.code !== 'like' && t.code !== 'dislike')
+                 .forEach(t => { extraReactionTypes[t.code] = t; });
+        }
+    } catch (error) {
+        console.error('Error loading reaction types:', error);
+    }
+    return extraReactionTypes;
+}
+
+function renderExtraReactions(post) {
+    const available = post.available_reactions || [];
+    const counts = post.reaction_counts || {};
+    return available
+        .filter(code => extraReactionTypes && extraReactionTypes[code])
+        .map(code => `
+                    <button class="action-btn emoji-reaction-btn ${post.user_reaction === code ? 'active' : ''}"
+                            data-reaction="${code}" title="${escapeHtml(extraReactionTypes[code].label)}"
+                            onclick="handleReaction(${post.post_id}, '${code}')">
+                        <span>${extraReactionTypes[code].emoji}</span>
+                        <span class="reaction-count">${counts[code] || 0}</span>
+                    </button>`)
+        .join('');
+}
+
+// renderPostImage shows one gallery image. Processed uploads come with
+// smaller sizes the browser can pick from.
+function renderPostImage(image) {
+    const alt = escapeHtml(image.alt_text || 'Post image');
+    const img = image.srcset
+        ? `<img src="${image.url}" srcset="${image.srcset}" sizes="(max-width: 800px) 100vw, 800px" width="${image.width}" height="${image.height}" loading="lazy" alt="${alt}" class="post-image">`
+        : `<img src="${image.url}" alt="${alt}" class="post-image">`;
+    if (!image.caption) return img;
+    return `<figure class="post-figure">${img}<figcaption>${escapeHtml(image.caption)}</figcaption></figure>`;
+}
+
 export async function loadPosts(filters = {}) {
     // This function remains unchanged.
     try {
         const queryParams = new URLSearchParams();
         if (filters.category) queryParams.append('category', filters.category);
+        (filters.tags || []).forEach(tag => queryParams.append('tag', tag));
         if (filters.myPostsOnly) queryParams.append('my_posts_only', 'true');
         if (filters.likedPostsOnly) queryParams.append('liked_posts_only', 'true');
+        if (filters.savedOnly) queryParams.append('saved_only', 'true');
+        if (filters.collectionId) queryParams.append('collection_id', filters.collectionId);
         
         const response = await fetch(`/api/posts?${queryParams.toString()}`, {
             credentials: 'include',
@@ -102,6 +150,7 @@ export async function loadPosts(filters = {}) {
         if (!response.ok) throw new Error(`HTTP error! status: ${response.status}`);
         
         const posts = await response.json();
+        await loadReactionTypes();
         displayPosts(posts);
     } catch (error) {
         console.error('Error loading posts:', error);
@@ -137,9 +186,23 @@ export function displayPosts(posts) {
         // Create HTML for category tags
         const categories = post.categories ? post.categories.split(',') : [];
         const categoriesHtml = categories.map(cat => `<span class="post-category-tag">${escapeHtml(cat)}</span>`).join('');
+        const tagsHtml = (post.tags || []).map(tag => `<a href="#" class="post-tag" onclick="filterByTag('${escapeHtml(tag)}'); return false;">#${escapeHtml(tag)}</a>`).join('');
+
+        // Pinned, locked and announcement markers set by moderators
+        const badgesHtml = [
+            post.announcement ? '<span class="post-badge announcement"><i class="fas fa-bullhorn"></i> Announcement</span>' : '',
+            post.pinned ? '<span class="post-badge pinned"><i class="fas fa-thumbtack"></i> Pinned</span>' : '',
+            post.locked ? '<span class="post-badge locked"><i class="fas fa-lock"></i> Locked</span>' : '',
+        ].join('');
 
-        // Create HTML for the post image, if it exists
-        const imageHtml = post.image_url ? `<img src="${post.image_url}" alt="Post image" class="post-image">` : '';
+        // Create HTML for the post images, if any
+        const images = post.images && post.images.length
+            ? post.images
+            : (post.image_url ? [{ url: post.image_url, srcset: post.image_srcset, width: post.image_width, height: post.image_height }] : []);
+        let imageHtml = images.map(renderPostImage).join('');
+        if (images.length > 1) {
+            imageHtml = `<div class="post-gallery">${imageHtml}</div>`;
+        }
 
         return `
         <article class="post-card" data-post-id="${post.post_id}">
@@ -156,8 +219,10 @@ export function displayPosts(posts) {
             </header>
             
             <div class="post-body">
+                ${badgesHtml ? `<div class="post-badges">${badgesHtml}</div>` : ''}
                 <h3 class="post-title">${escapeHtml(post.title)}</h3>
                 ${categoriesHtml ? `<div class="post-categories">${categoriesHtml}</div>` : ''}
+                ${tagsHtml ? `<div class="post-tags">${tagsHtml}</div>` : ''}
                 <p class="post-text">${escapeHtml(post.content)}</p>
             </div>
             
@@ -177,6 +242,7 @@ export function displayPosts(posts) {
                         <span>Dislike</span>
                          <span class="dislike-count">(${post.dislikes || 0})</span>
                     </button>
+                    ${renderExtraReactions(post)}
                     <button class="action-btn comment-btn" onclick="showComments(${post.post_id})">
                         <i class="far fa-comment"></i>
                         <span>Comment</span>
@@ -187,18 +253,39 @@ export function displayPosts(posts) {
 
             <div class="comments-wrapper" id="comments-wrapper-for-post-${post.post_id}" style="display: none;">
                 <div class="comment-list" id="comment-list-for-post-${post.post_id}"></div>
+                ${post.locked ? '<p class="comments-locked"><i class="fas fa-lock"></i> This post is locked. New comments are not allowed.</p>' : `
                 <form class="comment-form" onsubmit="handleCreateComment(event, ${post.post_id})">
                     <input type="text" name="content" class="comment-input" placeholder="Add a comment..." required>
                     <button type="submit" class="comment-submit-btn primary-btn">Post</button>
-                </form>
+                </form>`}
             </div>
         </article>
     `}).join('');
 }
 
 
+// orderCategories puts each category straight after its parent, returning
+// [category, depth] pairs. Categories whose parent is hidden are top-level.
+function orderCategories(categories) {
+    const ids = new Set(categories.map(cat => cat.category_id));
+    const children = new Map();
+    for (const cat of categories) {
+        const parent = ids.has(cat.parent_id) ? cat.parent_id : null;
+        if (!children.has(parent)) children.set(parent, []);
+        children.get(parent).push(cat);
+    }
+    const ordered = [];
+    const visit = (parent, depth) => {
+        for (const cat of children.get(parent) || []) {
+            ordered.push([cat, depth]);
+            visit(cat.category_id, depth + 1);
+        }
+    };
+    visit(null, 0);
+    return ordered;
+}
+
 export async function loadCategories() {
-    // This function remains unchanged.
     try {
         const response = await fetch('/api/categories', { credentials: 'include' });
         if (!response.ok) throw new Error(`HTTP error! status: ${response.status}`);
@@ -206,10 +293,14 @@ export async function loadCategories() {
         
         const container = document.getElementById('categories-container');
         if (container) {
-            container.innerHTML = categories.map(cat => `
-                <div class="category-option">
+            container.innerHTML = orderCategories(categories).map(([cat, depth]) => `
+                <div class="category-option" style="margin-left: ${depth * 1.2}rem">
                     <input type="checkbox" id="category-${cat.category_id}" name="category" value="${cat.category_id}">
-                    <label for="category-${cat.category_id}">${cat.name}</label>
+                    <label for="category-${cat.category_id}">
+                        ${cat.color ? `<span class="category-color" style="background: ${cat.color}"></span>` : ''}
+                        ${cat.icon ? `<span class="category-icon">${escapeHtml(cat.icon)}</span>` : ''}
+                        ${escapeHtml(cat.name)}
+                    </label>
                 </div>
             `).join('');
         }
@@ -218,4 +309,37 @@ export async function loadCategories() {
         const container = document.getElementById('categories-container');
         if (container) container.innerHTML = `<div class="error">Failed to load categories.</div>`;
     }
-}
\ No newline at end of file
+}
+
+// setupTagAutocomplete suggests existing tags for the last tag being typed
+// in the create post form.
+export function setupTagAutocomplete() {
+    const input = document.getElementById('post-tags');
+    const list = document.getElementById('post-tags-suggestions');
+    if (!input || !list) return;
+
+    let timer;
+    input.addEventListener('input', () => {
+        clearTimeout(timer);
+        timer = setTimeout(async () => {
+            const parts = input.value.split(',');
+            const current = parts.pop().trim();
+            const before = parts.map(p => p.trim()).filter(Boolean);
+            if (!current) {
+                list.innerHTML = '';
+                return;
+            }
+            try {
+                const response = await fetch(`/api/tags?q=${encodeURIComponent(current)}`, { credentials: 'include' });
+                if (!response.ok) return;
+                const tags = await response.json();
+                list.innerHTML = tags.map(tag => {
+                    const value = [...before, tag.name].join(', ');
+                    return `<option value="${escapeHtml(value).replace(/"/g, '&quot;')}">${tag.post_count} posts</option>`;
+                }).join('');
+            } catch (error) {
+                console.error('Error loading tag suggestions:', error);
+            }
+        }, 200);
+    });
+}
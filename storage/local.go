@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignedPrefix is the path signed URLs of Local are served under.
+const SignedPrefix = "/media/"
+
+// Local keeps media in a directory. Public URLs point at BaseURL, which the
+// server is expected to serve from Dir; signed URLs point at SignedPrefix and
+// are checked with Verify.
+type Local struct {
+	Dir     string
+	BaseURL string
+	key     []byte
+}
+
+// NewLocal returns a Local storing files under dir. signingKey protects its
+// signed URLs; when empty a random key is used, so signed URLs stop working
+// when the server restarts.
+func NewLocal(dir, baseURL, signingKey string) (*Local, error) {
+	key := []byte(signingKey)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &Local{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/"), key: key}, nil
+}
+
+// Path is where key is kept on disk.
+func (l *Local) Path(key string) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(key)), nil
+}
+
+func (l *Local) Put(key string, data []byte, contentType string) error {
+	p, err := l.Path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0o644)
+}
+
+func (l *Local) Get(key string) ([]byte, error) {
+	p, err := l.Path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (l *Local) Delete(key string) error {
+	p, err := l.Path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) List(prefix string, fn func(key string, modTime time.Time) error) error {
+	err := filepath.WalkDir(l.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(l.Dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(key, info.ModTime())
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (l *Local) URL(key string) string {
+	return l.BaseURL + "/" + escapePath(key)
+}
+
+func (l *Local) SignedURL(key string, ttl time.Duration) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	query := url.Values{"expires": {expires}, "signature": {l.signature(key, expires)}}
+	return SignedPrefix + escapePath(key) + "?" + query.Encode(), nil
+}
+
+// Verify reports whether expires and signature, from a URL made by
+// SignedURL, are valid for key and have not expired.
+func (l *Local) Verify(key, expires, signature string) bool {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(l.signature(key, expires)))
+}
+
+func (l *Local) signature(key, expires string) string {
+	mac := hmac.New(sha256.New, l.key)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// escapePath escapes each segment of a key for use in a URL path.
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"posts/abc.jpg", true},
+		{"abc.jpg", true},
+		{"posts/a b.jpg", true},
+		{"", false},
+		{"/posts/abc.jpg", false},
+		{"..", false},
+		{"../abc.jpg", false},
+		{"posts/../../abc.jpg", false},
+		{"posts/./abc.jpg", false},
+		{"posts//abc.jpg", false},
+		{"posts/", false},
+		{`posts\..\abc.jpg`, false},
+	}
+	for _, tt := range tests {
+		err := CheckKey(tt.key)
+		if tt.valid && err != nil {
+			t.Errorf("CheckKey(%q) = %v, want nil", tt.key, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("CheckKey(%q) = %v, want ErrInvalidKey", tt.key, err)
+		}
+	}
+}
+
+func TestLocalKeepsFilesUnderDir(t *testing.T) {
+	l, err := NewLocal(t.TempDir(), "/static/images/", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Put("posts/abc.jpg", []byte("image"), "image/jpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if data, err := l.Get("posts/abc.jpg"); err != nil || string(data) != "image" {
+		t.Errorf("Get = %q, %v; want \"image\"", data, err)
+	}
+	if err := l.Put("../escaped.jpg", nil, "image/jpeg"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Put outside Dir = %v, want ErrInvalidKey", err)
+	}
+	if err := l.Delete("posts/abc.jpg"); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	if _, err := l.Get("posts/abc.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if err := l.Delete("posts/abc.jpg"); err != nil {
+		t.Errorf("Delete of a missing key = %v, want nil", err)
+	}
+	if got, want := l.URL("posts/a b.jpg"), "/static/images/posts/a%20b.jpg"; got != want {
+		t.Errorf("URL = %s, want %s", got, want)
+	}
+}
+
+// signedQuery signs key with l and returns the query of the URL.
+func signedQuery(t *testing.T, l *Local, key string, ttl time.Duration) url.Values {
+	t.Helper()
+	signed, err := l.SignedURL(key, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path, _ := url.PathUnescape(u.EscapedPath()); path != SignedPrefix+key {
+		t.Fatalf("signed URL path = %s, want %s", path, SignedPrefix+key)
+	}
+	return u.Query()
+}
+
+func TestLocalVerify(t *testing.T) {
+	l, err := NewLocal(t.TempDir(), "/static/images", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewLocal(t.TempDir(), "/static/images", "another key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := signedQuery(t, l, "posts/a b.jpg", time.Hour)
+	expired := signedQuery(t, l, "posts/a b.jpg", -time.Minute)
+	later := strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name                    string
+		l                       *Local
+		key, expires, signature string
+		want                    bool
+	}{
+		{"valid", l, "posts/a b.jpg", valid.Get("expires"), valid.Get("signature"), true},
+		{"expired", l, "posts/a b.jpg", expired.Get("expires"), expired.Get("signature"), false},
+		{"expiry extended", l, "posts/a b.jpg", later, valid.Get("signature"), false},
+		{"other key", l, "posts/other.jpg", valid.Get("expires"), valid.Get("signature"), false},
+		{"tampered signature", l, "posts/a b.jpg", valid.Get("expires"), strings.Repeat("0", 64), false},
+		{"no signature", l, "posts/a b.jpg", valid.Get("expires"), "", false},
+		{"bad expiry", l, "posts/a b.jpg", "soon", valid.Get("signature"), false},
+		{"other signing key", other, "posts/a b.jpg", valid.Get("expires"), valid.Get("signature"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.l.Verify(tt.key, tt.expires, tt.signature); got != tt.want {
+				t.Errorf("Verify = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalSignedURLRejectsInvalidKeys(t *testing.T) {
+	l, err := NewLocal(t.TempDir(), "/static/images", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.SignedURL("../db/forum.db", time.Hour); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("SignedURL = %v, want ErrInvalidKey", err)
+	}
+}
@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	amzDateFormat   = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// maxPresignTTL is the longest expiry S3 accepts for a presigned URL.
+	maxPresignTTL = 7 * 24 * time.Hour
+)
+
+// S3Config describes an S3-compatible bucket.
+type S3Config struct {
+	Endpoint  string // e.g. "https://s3.eu-west-1.amazonaws.com" or "http://localhost:9000"
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses objects as <endpoint>/<bucket>/<key>, which MinIO
+	// and most self-hosted stores need, instead of <bucket>.<endpoint>/<key>.
+	PathStyle bool
+	// PublicURL, when set, is used for public links instead of the bucket
+	// address, e.g. a CDN in front of the bucket.
+	PublicURL string
+}
+
+// S3 keeps media in an S3-compatible bucket. Requests are signed with AWS
+// Signature Version 4.
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("S3 storage needs S3_ENDPOINT and S3_BUCKET")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("S3 storage needs S3_ACCESS_KEY and S3_SECRET_KEY")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT %q", cfg.Endpoint)
+	}
+	cfg.PublicURL = strings.TrimSuffix(cfg.PublicURL, "/")
+	return &S3{cfg: cfg, endpoint: endpoint, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// objectURL returns the scheme and host of key's address, and its escaped
+// path.
+func (s *S3) objectURL(key string) (base, escapedPath string) {
+	host := s.endpoint.Host
+	prefix := s.endpoint.EscapedPath()
+	if s.cfg.PathStyle {
+		prefix += "/" + uriEncode(s.cfg.Bucket, false)
+	} else {
+		host = s.cfg.Bucket + "." + host
+	}
+	return s.endpoint.Scheme + "://" + host, prefix + "/" + uriEncode(key, false)
+}
+
+func (s *S3) Put(key string, data []byte, contentType string) error {
+	resp, err := s.do(http.MethodPut, key, data, contentType)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(key string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (s *S3) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, "")
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) URL(key string) string {
+	if s.cfg.PublicURL != "" {
+		return s.cfg.PublicURL + "/" + uriEncode(key, false)
+	}
+	base, p := s.objectURL(key)
+	return base + p
+}
+
+// listBucketResult is the part of a ListObjectsV2 response List reads.
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List pages through ListObjectsV2, a thousand keys at a time.
+func (s *S3) List(prefix string, fn func(key string, modTime time.Time) error) error {
+	base, escapedPath := s.objectURL("")
+	if s.cfg.PathStyle {
+		escapedPath = strings.TrimSuffix(escapedPath, "/")
+	}
+	query := map[string]string{"list-type": "2", "prefix": prefix}
+	for {
+		resp, err := s.send(http.MethodGet, base, escapedPath, query, nil, "")
+		if err != nil {
+			return err
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("s3 list %s: %w", prefix, err)
+		}
+
+		for _, obj := range page.Contents {
+			if err := fn(obj.Key, obj.LastModified); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		query["continuation-token"] = page.NextContinuationToken
+	}
+}
+
+// SignedURL presigns a GET of key. S3 caps the expiry at seven days.
+func (s *S3) SignedURL(key string, ttl time.Duration) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	return s.presign(key, ttl, time.Now()), nil
+}
+
+func (s *S3) presign(key string, ttl time.Duration, now time.Time) string {
+	if ttl > maxPresignTTL {
+		ttl = maxPresignTTL
+	}
+	base, escapedPath := s.objectURL(key)
+	host := strings.TrimPrefix(strings.TrimPrefix(base, "https://"), "http://")
+	amzDate := now.UTC().Format(amzDateFormat)
+	scope := s.scope(amzDate)
+
+	query := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    s.cfg.AccessKey + "/" + scope,
+		"X-Amz-Date":          amzDate,
+		"X-Amz-Expires":       strconv.Itoa(int(ttl.Seconds())),
+		"X-Amz-SignedHeaders": "host",
+	}
+	canonicalQuery := canonicalQueryString(query)
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		escapedPath,
+		canonicalQuery,
+		"host:" + host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	signature := s.signature(amzDate, scope, canonicalRequest)
+	return base + escapedPath + "?" + canonicalQuery + "&X-Amz-Signature=" + signature
+}
+
+// do sends a signed request for key, turning a 404 into ErrNotFound and any
+// other non-2xx response into an error.
+func (s *S3) do(method, key string, body []byte, contentType string) (*http.Response, error) {
+	if err := CheckKey(key); err != nil {
+		return nil, err
+	}
+	base, escapedPath := s.objectURL(key)
+	return s.send(method, base, escapedPath, nil, body, contentType)
+}
+
+func (s *S3) send(method, base, escapedPath string, query map[string]string, body []byte, contentType string) (*http.Response, error) {
+	canonicalQuery := canonicalQueryString(query)
+	target := base + escapedPath
+	if canonicalQuery != "" {
+		target += "?" + canonicalQuery
+	}
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, escapedPath, canonicalQuery, body, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, escapedPath, resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+// sign adds SigV4 headers to req, covering host, x-amz-content-sha256 and
+// x-amz-date.
+func (s *S3) sign(req *http.Request, escapedPath, canonicalQuery string, body []byte, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	scope := s.scope(amzDate)
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapedPath,
+		canonicalQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, s.signature(amzDate, scope, canonicalRequest),
+	))
+}
+
+func (s *S3) scope(amzDate string) string {
+	return amzDate[:8] + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+func (s *S3) signature(amzDate, scope, canonicalRequest string) string {
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), amzDate[:8])
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func canonicalQueryString(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = uriEncode(k, true) + "=" + uriEncode(params[k], true)
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode escapes s the way SigV4 expects: everything except unreserved
+// characters, and "/" too when encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
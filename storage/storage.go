@@ -0,0 +1,143 @@
+// Package storage keeps uploaded media on a pluggable backend: the local disk
+// or an S3-compatible object store such as AWS S3 or MinIO.
+//
+// Media is addressed by key, a slash-separated relative path such as
+// "posts/<uuid>.jpg". The database stores keys, and URLs are only produced
+// when a response is built, so the backend can change without rewriting rows.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"real/config"
+)
+
+var (
+	ErrNotFound   = errors.New("media not found")
+	ErrInvalidKey = errors.New("invalid media key")
+)
+
+// Storage is a place uploaded files are kept.
+type Storage interface {
+	// Put stores data under key, replacing anything already there.
+	Put(key string, data []byte, contentType string) error
+	// Get returns the data stored under key, or ErrNotFound.
+	Get(key string) ([]byte, error)
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(key string) error
+	// URL is the public address of key.
+	URL(key string) string
+	// SignedURL is an address for key that stops working after ttl.
+	SignedURL(key string, ttl time.Duration) (string, error)
+	// List calls fn for every stored key starting with prefix, with the time
+	// it was last written.
+	List(prefix string, fn func(key string, modTime time.Time) error) error
+}
+
+// Media is the backend uploads are stored in, set up by Init.
+var Media Storage
+
+// Private media is only linked through signed URLs that expire after
+// signedURLTTL, instead of through the backend's public URL.
+var (
+	private      bool
+	signedURLTTL time.Duration
+)
+
+// Init sets up Media from the MEDIA_* and S3_* settings.
+func Init() error {
+	s, err := FromConfig()
+	if err != nil {
+		return err
+	}
+	private = config.Bool("MEDIA_PRIVATE", false)
+	if local, ok := s.(*Local); ok && private {
+		served, err := within(local.Dir, staticDir)
+		if err != nil {
+			return err
+		}
+		if served {
+			return fmt.Errorf("MEDIA_PRIVATE needs MEDIA_DIR outside %s, which is served without signatures; %q is inside it", staticDir, local.Dir)
+		}
+	}
+	Media = s
+	signedURLTTL = config.Duration("MEDIA_URL_TTL", time.Hour)
+	return nil
+}
+
+// staticDir is served as is by the /static/ and /images/ file servers.
+const staticDir = "static"
+
+// within reports whether dir is root or a directory below it.
+func within(dir, root string) (bool, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false, err
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(absRoot, absDir)
+	if err != nil {
+		return false, nil
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))), nil
+}
+
+// FromConfig builds the backend selected by MEDIA_STORAGE ("local" or "s3").
+func FromConfig() (Storage, error) {
+	switch backend := config.String("MEDIA_STORAGE", "local"); backend {
+	case "local":
+		return NewLocal(
+			config.String("MEDIA_DIR", "static/images"),
+			config.String("MEDIA_BASE_URL", "/static/images"),
+			config.String("MEDIA_SIGNING_KEY", ""),
+		)
+	case "s3":
+		return NewS3(S3Config{
+			Endpoint:  config.String("S3_ENDPOINT", ""),
+			Region:    config.String("S3_REGION", "us-east-1"),
+			Bucket:    config.String("S3_BUCKET", ""),
+			AccessKey: config.String("S3_ACCESS_KEY", ""),
+			SecretKey: config.String("S3_SECRET_KEY", ""),
+			PathStyle: config.Bool("S3_PATH_STYLE", true),
+			PublicURL: config.String("S3_PUBLIC_URL", ""),
+		})
+	default:
+		return nil, fmt.Errorf("unknown MEDIA_STORAGE %q", backend)
+	}
+}
+
+// URL is the address clients should use for key: a signed URL when media is
+// private, the public one otherwise. Values that are already URLs (from
+// before media was stored by key) are returned unchanged.
+func URL(key string) string {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "://") {
+		return key
+	}
+	if !private {
+		return Media.URL(key)
+	}
+	signed, err := Media.SignedURL(key, signedURLTTL)
+	if err != nil {
+		log.Printf("Error signing media URL for %s: %v", key, err)
+		return ""
+	}
+	return signed
+}
+
+// CheckKey rejects keys that are empty, absolute or that would escape the
+// storage root.
+func CheckKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, `\`) || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return nil
+}
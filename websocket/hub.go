@@ -12,17 +12,24 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// CloseSessionEnded is the websocket close code sent when the session a
+// connection was opened with is logged out or revoked.
+const CloseSessionEnded = 4001
+
 // Client is a middleman between the websocket connection and the hub.
 type Client struct {
-	Hub    *Hub
-	Conn   *websocket.Conn
-	Send   chan []byte
-	UserID int
+	Hub       *Hub
+	Conn      *websocket.Conn
+	Send      chan []byte
+	UserID    int
+	SessionID string
 }
 
-// Hub maintains the set of active clients and broadcasts messages.
+// Hub maintains the set of active clients and broadcasts messages. A user can
+// be connected from several devices at once, so Clients holds every
+// connection of each user.
 type Hub struct {
-	Clients    map[int]*Client
+	Clients    map[int]map[*Client]bool
 	Broadcast  chan []byte
 	Register   chan *Client
 	Unregister chan *Client
@@ -36,6 +43,13 @@ type PrivateMessagePayload struct {
 	RecipientID int    `json:"recipientId"`
 	Content     string `json:"content"`
 }
+// UserStatusUpdate is the payload of "user_status_update", sent when a user
+// comes online or goes offline.
+type UserStatusUpdate struct {
+	UserID   int    `json:"userId"`
+	Username string `json:"username"`
+	IsOnline bool   `json:"isOnline"`
+}
 type NewMessageNotification struct {
 	SenderID       int    `json:"senderId"`
 	ReceiverID     int    `json:"receiverId"`
@@ -49,48 +63,171 @@ func NewHub() *Hub {
 		Broadcast:  make(chan []byte),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
-		Clients:    make(map[int]*Client),
+		Clients:    make(map[int]map[*Client]bool),
 	}
 }
 
+// BroadcastEvent sends a typed message to every connected client.
+func (h *Hub) BroadcastEvent(msgType string, payload interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(WebSocketMessage{Type: msgType, Payload: payloadBytes})
+	if err != nil {
+		return err
+	}
+	h.Broadcast <- msg
+	return nil
+}
+
 func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.Register:
 			h.mu.Lock()
-			h.Clients[client.UserID] = client
+			if len(h.Clients[client.UserID]) == 0 {
+				h.Clients[client.UserID] = make(map[*Client]bool)
+				h.setStatus(client.UserID, true)
+			}
+			h.Clients[client.UserID][client] = true
 			h.mu.Unlock()
-			db.UpdateUserStatus(client.UserID, true)
-			
 
 		case client := <-h.Unregister:
 			h.mu.Lock()
-			if _, ok := h.Clients[client.UserID]; ok {
-				delete(h.Clients, client.UserID)
-				close(client.Send)
-				db.UpdateUserStatus(client.UserID, false)
-				
-			}
+			h.removeClient(client)
 			h.mu.Unlock()
 
 		case message := <-h.Broadcast:
 			h.mu.Lock()
-			for _, client := range h.Clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.Clients, client.UserID)
-				}
-			}
+			h.broadcast(message)
 			h.mu.Unlock()
 		}
 	}
 }
 
+// broadcast queues message on every connection, dropping the ones that are
+// not keeping up. h.mu must be held.
+func (h *Hub) broadcast(message []byte) {
+	var stalled []*Client
+	for _, clients := range h.Clients {
+		for client := range clients {
+			select {
+			case client.Send <- message:
+			default:
+				stalled = append(stalled, client)
+			}
+		}
+	}
+	for _, client := range stalled {
+		h.removeClient(client)
+	}
+}
 
+// setStatus records whether userID is online and tells every connected
+// client with a "user_status_update". h.mu must be held.
+func (h *Hub) setStatus(userID int, online bool) {
+	db.UpdateUserStatus(userID, online)
 
+	username, err := db.GetUsernameByID(userID)
+	if err != nil {
+		log.Printf("Error getting username for status update of %d: %v", userID, err)
+		return
+	}
+	payload, _ := json.Marshal(UserStatusUpdate{UserID: userID, Username: username, IsOnline: online})
+	msg, _ := json.Marshal(WebSocketMessage{Type: "user_status_update", Payload: payload})
+	h.broadcast(msg)
+}
 
+// removeClient drops a connection, marking its user offline once their last
+// connection is gone. h.mu must be held.
+func (h *Hub) removeClient(client *Client) {
+	clients := h.Clients[client.UserID]
+	if !clients[client] {
+		return
+	}
+	delete(clients, client)
+	close(client.Send)
+	if len(clients) == 0 {
+		delete(h.Clients, client.UserID)
+		h.setStatus(client.UserID, false)
+	}
+}
+
+// sendToUser queues message on every connection of userID. h.mu must be held.
+func (h *Hub) sendToUser(userID int, message []byte) {
+	var stalled []*Client
+	for client := range h.Clients[userID] {
+		select {
+		case client.Send <- message:
+		default:
+			stalled = append(stalled, client)
+		}
+	}
+	for _, client := range stalled {
+		h.removeClient(client)
+	}
+}
+
+// closeSessions disconnects the connections opened with one of sessionIDs,
+// telling the clients their session has ended. h.mu must be held.
+func (h *Hub) closeSessions(sessionIDs []string) {
+	ended := make(map[string]bool, len(sessionIDs))
+	for _, id := range sessionIDs {
+		ended[id] = true
+	}
+	closeMsg := websocket.FormatCloseMessage(CloseSessionEnded, "session ended")
+
+	var closing []*Client
+	for _, clients := range h.Clients {
+		for client := range clients {
+			if ended[client.SessionID] {
+				closing = append(closing, client)
+			}
+		}
+	}
+	for _, client := range closing {
+		client.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		client.Conn.Close()
+		h.removeClient(client)
+	}
+}
+
+// CloseSessions disconnects every connection opened with one of sessionIDs,
+// such as sessions that were just revoked.
+func (h *Hub) CloseSessions(sessionIDs ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closeSessions(sessionIDs)
+}
+
+// RenameSession moves the connections opened with oldID over to newID after
+// the session ID was rotated, so that ending the session still closes them.
+func (h *Hub) RenameSession(oldID, newID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, clients := range h.Clients {
+		for client := range clients {
+			if client.SessionID == oldID {
+				client.SessionID = newID
+			}
+		}
+	}
+}
+
+// LogOut disconnects the connections of a session that was logged out. The
+// user goes offline unless they are still connected from another device;
+// login marks users online before they connect, so they are marked offline
+// here even when this session had no connection.
+func (h *Hub) LogOut(userID int, sessionID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	wasConnected := len(h.Clients[userID]) > 0
+	h.closeSessions([]string{sessionID})
+	if !wasConnected {
+		h.setStatus(userID, false)
+	}
+}
 
 func (c *Client) ReadPump() {
 	defer func() {
@@ -146,31 +283,17 @@ func (c *Client) ReadPump() {
 			finalMsg := WebSocketMessage{Type: "new_message", Payload: payloadBytes}
 			finalMsgBytes, _ := json.Marshal(finalMsg)
 
-			// 4. Send to recipient if they are online
+			// 4. Send to the recipient's connections if they are online, and
+			// back to all of the sender's, this one included
 			c.Hub.mu.Lock()
-			if recipientClient, ok := c.Hub.Clients[pmp.RecipientID]; ok {
-				select {
-				case recipientClient.Send <- finalMsgBytes:
-					
-				default:
-					close(recipientClient.Send)
-					delete(c.Hub.Clients, recipientClient.UserID)
-					
-				}
-			
-			c.Hub.mu.Unlock()
-
-			// 5. Send confirmation back to the sender
-			select {
-			case c.Send <- finalMsgBytes:
-				
-			default:
-				
+			c.Hub.sendToUser(pmp.RecipientID, finalMsgBytes)
+			if pmp.RecipientID != c.UserID {
+				c.Hub.sendToUser(c.UserID, finalMsgBytes)
 			}
+			c.Hub.mu.Unlock()
 		}
 	}
 }
-}
 
 func (c *Client) WritePump() {
 	defer c.Conn.Close()